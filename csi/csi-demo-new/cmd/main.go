@@ -26,7 +26,19 @@ var (
 	endpoint      = flag.String("csi-address", "unix://tmp/csi.sock", "CSI endpoint")
 	driverName    = flag.String("drivername", "my-csi-driver", "name of the driver")
 	nodeID        = flag.String("nodeid", "", "node id")
+	dataRoot      = flag.String("data-root", "/var/lib/my-csi-driver", "volume 数据以及索引的存放目录, controller 和 node 分开部署时需要在同一个节点上共享这个目录")
+	mode          = flag.String("mode", "all", "运行模式 controller|node|all, controller 以 Deployment 部署, node 以 DaemonSet 部署")
 	vendorVersion = "1.0.0"
+
+	// topology,volume 只能被 topology 一致的节点访问
+	nodeTopologyKey   = flag.String("topology-node-key", "topology.my-csi-driver/node", "节点 topology 的 key,为空不上报")
+	zoneTopologyKey   = flag.String("topology-zone-key", "topology.kubernetes.io/zone", "可用区 topology 的 key")
+	zone              = flag.String("zone", "", "节点所在的可用区,为空不上报")
+	maxVolumesPerNode = flag.Int64("max-volumes-per-node", 0, "每个节点最多可以发布的 volume 数量,0 不限制")
+
+	// metrics 以及优雅关闭
+	metricsAddress  = flag.String("metrics-address", "", "prometheus metrics 的监听地址,例如 :9808,为空不暴露")
+	shutdownTimeout = flag.Duration("shutdown-timeout", csiDriver.DefaultShutdownTimeout, "收到 SIGTERM 后等待正在处理的调用结束的时间")
 )
 
 func main() {
@@ -42,12 +54,34 @@ func main() {
 func handle() {
 	// 实例化 csi driver
 	driver := csiDriver.GetCSIDriver()
+	if err := driver.SetMode(csiDriver.DriverMode(*mode)); err != nil {
+		glog.Fatal(err)
+	}
+	// csi-node 需要节点id
+	if driver.RunsNode() && *nodeID == "" {
+		glog.Fatalf("%s 模式需要指定 -nodeid", *mode)
+	}
+	// volume 后端存储
+	backend, err := csiDriver.NewHostPathBackend(*dataRoot)
+	if err != nil {
+		glog.Fatalf("初始化 volume 后端存储失败: %v", err)
+	}
 	// 初始化 csi driver
-	// 驱动名称 版本 节点id
-	err := driver.InitializeDriver(*driverName, vendorVersion, *nodeID)
+	// 驱动名称 版本 节点id 后端存储
+	err = driver.InitializeDriver(*driverName, vendorVersion, *nodeID, backend)
 	if err != nil {
 		glog.Fatalf("初始化 csi driver 失败: %v", err)
 	}
+	// topology segments
+	segments := map[string]string{}
+	if *nodeTopologyKey != "" && *nodeID != "" {
+		segments[*nodeTopologyKey] = *nodeID
+	}
+	if *zoneTopologyKey != "" && *zone != "" {
+		segments[*zoneTopologyKey] = *zone
+	}
+	driver.SetTopology(segments, *maxVolumesPerNode)
+	driver.SetServerOptions(*metricsAddress, *shutdownTimeout)
 	// 运行 csi driver
 	driver.Run(*endpoint)
 }
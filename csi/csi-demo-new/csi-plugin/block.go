@@ -0,0 +1,90 @@
+package mycsi
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/golang/glog"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// raw block volume: 镜像文件关联一个 loop 设备,再把设备文件 bind mount 到 pod 的 targetPath 文件上
+
+// 发布 raw block volume,调用方已经检查过 targetPath 没有挂载
+func (ns *NodeServer) publishBlockVolume(volumeID, targetPath string, readonly bool) error {
+	vol, err := ns.Driver.backend.GetVolumeByID(volumeID)
+	if err == ErrVolumeNotFound {
+		return status.Error(codes.NotFound, fmt.Sprintf("volume %s 不存在", volumeID))
+	}
+	if err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	if encrypted, _ := isEncrypted(vol.Parameters); encrypted {
+		return status.Error(codes.InvalidArgument, fmt.Sprintf("volume %s 是加密的, 不支持 raw block", volumeID))
+	}
+
+	// 镜像文件已经关联了 loop 设备时直接复用
+	device, err := ns.mounter.FindLoopDevice(vol.Path)
+	if err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	if device == "" {
+		if device, err = ns.mounter.AttachLoopDevice(vol.Path); err != nil {
+			return status.Error(codes.Internal, err.Error())
+		}
+		glog.V(4).Infof("volume %s 关联 loop 设备 %s", volumeID, device)
+	}
+
+	// targetPath 是一个文件
+	if err := os.MkdirAll(filepath.Dir(targetPath), 0750); err != nil {
+		return status.Error(codes.Internal, fmt.Sprintf("创建目录 %s 失败: %v", filepath.Dir(targetPath), err))
+	}
+	f, err := os.OpenFile(targetPath, os.O_CREATE, 0640)
+	if err != nil {
+		return status.Error(codes.Internal, fmt.Sprintf("创建文件 %s 失败: %v", targetPath, err))
+	}
+	f.Close()
+
+	options := []string{"bind"}
+	if readonly {
+		options = append(options, "ro")
+	}
+	if err := ns.mounter.Mount(device, targetPath, "", options); err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	return nil
+}
+
+// 解除 volume 镜像文件关联的 loop 设备,没有关联时什么也不做
+func (ns *NodeServer) detachBlockVolume(volumeID string) error {
+	vol, err := ns.Driver.backend.GetVolumeByID(volumeID)
+	if err == ErrVolumeNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	device, err := ns.mounter.FindLoopDevice(vol.Path)
+	if err != nil || device == "" {
+		return err
+	}
+	glog.V(4).Infof("volume %s 解除 loop 设备 %s", volumeID, device)
+	return ns.mounter.DetachLoopDevice(device)
+}
+
+// 镜像文件扩容后刷新 loop 设备的大小
+func (ns *NodeServer) refreshBlockVolume(vol *Volume) error {
+	device, err := ns.mounter.FindLoopDevice(vol.Path)
+	if err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	if device == "" {
+		return status.Error(codes.NotFound, fmt.Sprintf("volume %s 没有关联 loop 设备", vol.ID))
+	}
+	if err := ns.mounter.RefreshLoopDevice(device); err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	return nil
+}
@@ -2,6 +2,11 @@ package mycsi
 
 import (
 	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	"github.com/golang/glog"
@@ -12,6 +17,21 @@ import (
 const (
 	// 插件的文件夹
 	PluginFolder = "/var/lib/kubelet/plugins/my-csi-driver"
+	// 收到 SIGTERM 后等待正在处理的调用结束的默认时间
+	DefaultShutdownTimeout = 30 * time.Second
+)
+
+// 运行模式,决定 grpc server 注册哪些服务
+// csi-controller 以 Deployment 部署,csi-node 以 DaemonSet 部署
+type DriverMode string
+
+const (
+	// 只运行 csi-identity 和 csi-controller
+	ModeController DriverMode = "controller"
+	// 只运行 csi-identity 和 csi-node
+	ModeNode DriverMode = "node"
+	// 运行所有服务
+	ModeAll DriverMode = "all"
 )
 
 // 定义 csi driver
@@ -29,11 +49,26 @@ type MyCSIDriver struct {
 	name          string
 	vendorVersion string
 	nodeID        string
+	// 运行模式
+	mode DriverMode
+	// 驱动可以访问的 topology segments
+	topology map[string]string
+	// 每个节点最多可以发布的 volume 数量,0 不限制
+	maxVolumesPerNode int64
+	// metrics 的监听地址,为空不暴露 metrics
+	metricsAddress string
+	// 优雅关闭的超时时间
+	shutdownTimeout time.Duration
 
 	ids *IdentityServer
 	ns  *NodeServer
 	cs  *ControllerServer
 
+	// volume 的后端存储
+	backend VolumeBackend
+	// csi-node 的文件系统操作
+	mounter Mounter
+
 	// volume的capability accessmode访问模式校验
 	// csi-controller csi-node的capability
 	vcap  []*csi.VolumeCapability_AccessMode
@@ -48,7 +83,11 @@ func init() {
 // 获取 实例化 csi driver
 func GetCSIDriver() *MyCSIDriver {
 	glog.Infof("mycsi: GetCSIDriver")
-	return &MyCSIDriver{}
+	return &MyCSIDriver{
+		mode:            ModeAll,
+		mounter:         NewMounter(),
+		shutdownTimeout: DefaultShutdownTimeout,
+	}
 }
 
 func NewIdentityServer(d *MyCSIDriver) *IdentityServer {
@@ -61,23 +100,30 @@ func NewIdentityServer(d *MyCSIDriver) *IdentityServer {
 func NewControllerServer(d *MyCSIDriver) *ControllerServer {
 	glog.V(3).Infof("mycsi: NewControllerServer")
 	return &ControllerServer{
-		Driver: d,
+		Driver:   d,
+		inFlight: NewInFlight(),
 	}
 }
 
 func NewNodeServer(d *MyCSIDriver) *NodeServer {
 	glog.V(3).Infof("mycsi: NewNodeServer")
 	return &NodeServer{
-		Driver: d,
+		Driver:   d,
+		mounter:  d.mounter,
+		inFlight: NewInFlight(),
 	}
 }
 
 // 初始化 csi driver 驱动程序
-func (driver *MyCSIDriver) InitializeDriver(name, vendorVersion, nodeID string) error {
+func (driver *MyCSIDriver) InitializeDriver(name, vendorVersion, nodeID string, backend VolumeBackend) error {
 	glog.V(3).Infof("mycsi: InitializeDriver. name: %s, version: %v, nodeID: %s", name, vendorVersion, nodeID)
 	if name == "" {
 		return fmt.Errorf("Driver name missing")
 	}
+	if backend == nil {
+		return fmt.Errorf("Volume backend missing")
+	}
+	driver.backend = backend
 
 	err := driver.PluginInitialize()
 	if err != nil {
@@ -91,10 +137,8 @@ func (driver *MyCSIDriver) InitializeDriver(name, vendorVersion, nodeID string)
 	driver.nodeID = nodeID
 
 	// 设置 csi 的 capability(volume) accessmode
-	vcam := []csi.VolumeCapability_AccessMode_Mode{
-		// rwx 多个节点读写,后续volume校验访问模式有用
-		csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER,
-	}
+	// 访问模式由后端存储决定,后续volume校验访问模式有用
+	vcam := backend.AccessModes()
 	// add volume capability
 	driver.AddVolumeCapabilityAccessModes(vcam)
 
@@ -104,6 +148,18 @@ func (driver *MyCSIDriver) InitializeDriver(name, vendorVersion, nodeID string)
 		csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
 		csi.ControllerServiceCapability_RPC_PUBLISH_UNPUBLISH_VOLUME,
 		csi.ControllerServiceCapability_RPC_EXPAND_VOLUME,
+		// 快照的创建删除 list
+		csi.ControllerServiceCapability_RPC_CREATE_DELETE_SNAPSHOT,
+		csi.ControllerServiceCapability_RPC_LIST_SNAPSHOTS,
+		// 通过 VolumeContentSource 克隆 volume
+		csi.ControllerServiceCapability_RPC_CLONE_VOLUME,
+		// list volume 获取 volume 以及发布到的节点和健康状态
+		csi.ControllerServiceCapability_RPC_LIST_VOLUMES,
+		csi.ControllerServiceCapability_RPC_LIST_VOLUMES_PUBLISHED_NODES,
+		csi.ControllerServiceCapability_RPC_GET_VOLUME,
+		csi.ControllerServiceCapability_RPC_VOLUME_CONDITION,
+		// 剩余容量
+		csi.ControllerServiceCapability_RPC_GET_CAPACITY,
 	}
 	// add csi-controller capability
 	driver.AddControllerServiceCapabilities(csc)
@@ -112,6 +168,9 @@ func (driver *MyCSIDriver) InitializeDriver(name, vendorVersion, nodeID string)
 		// 容量修改 stage/unstage 针对块存储是否格式化挂载进某个临时全局目录
 		csi.NodeServiceCapability_RPC_STAGE_UNSTAGE_VOLUME,
 		csi.NodeServiceCapability_RPC_EXPAND_VOLUME,
+		// volume 的容量 inode 使用情况以及健康状态
+		csi.NodeServiceCapability_RPC_GET_VOLUME_STATS,
+		csi.NodeServiceCapability_RPC_VOLUME_CONDITION,
 	}
 	// add csi-node capability
 	driver.AddNodeServiceCapabilities(ns)
@@ -134,11 +193,84 @@ func (driver *MyCSIDriver) Run(endpoint string) {
 	glog.Infof("Driver: %v version: %v", driver.name, driver.vendorVersion)
 	// 创建一个 grpc server 的接口         启动 阻塞
 	// csi 存储体系中的 csi 存储插件都是 grpc 实现的服务
-	s := NewNonBlockingGRPCServer()
-	s.Start(endpoint, driver.ids, driver.cs, driver.ns)
+	// 根据运行模式只注册需要的服务,注意不能传 nil 指针给接口
+	var cs csi.ControllerServer
+	var ns csi.NodeServer
+	if driver.RunsController() {
+		cs = driver.cs
+	}
+	if driver.RunsNode() {
+		ns = driver.ns
+	}
+	glog.Infof("Driver mode: %v", driver.mode)
+	// 回收 csi-node 重启期间遗留的 inline ephemeral volume
+	if driver.RunsNode() {
+		if err := driver.ns.GarbageCollectEphemeralVolumes(); err != nil {
+			glog.Errorf("回收 ephemeral volume 失败: %v", err)
+		}
+	}
+	metrics := newGRPCMetrics(driver.name)
+	s := NewNonBlockingGRPCServer(metrics.interceptor)
+	s.Start(endpoint, driver.ids, cs, ns)
+
+	var metricsServer *http.Server
+	if driver.metricsAddress != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", metrics.handler())
+		metricsServer = &http.Server{Addr: driver.metricsAddress, Handler: mux}
+		go func() {
+			glog.Infof("metrics 监听在 %s", driver.metricsAddress)
+			if err := metricsServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				glog.Errorf("metrics server 失败: %v", err)
+			}
+		}()
+	}
+
+	// 收到 SIGTERM 或者 SIGINT 后不再接收新的调用,等待正在处理的调用结束再退出
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGTERM, syscall.SIGINT)
+	go func() {
+		sig := <-sigs
+		glog.Infof("收到信号 %v, 开始关闭", sig)
+		s.Shutdown(driver.shutdownTimeout)
+		if metricsServer != nil {
+			metricsServer.Close()
+		}
+	}()
+
 	s.Wait()
 }
 
+// 设置运行模式
+func (driver *MyCSIDriver) SetMode(mode DriverMode) error {
+	switch mode {
+	case ModeController, ModeNode, ModeAll:
+		driver.mode = mode
+		return nil
+	}
+	return fmt.Errorf("不支持的运行模式 %q, 可选 controller|node|all", mode)
+}
+
+// 是否运行 csi-controller
+func (driver *MyCSIDriver) RunsController() bool {
+	return driver.mode == ModeController || driver.mode == ModeAll
+}
+
+// 是否运行 csi-node
+func (driver *MyCSIDriver) RunsNode() bool {
+	return driver.mode == ModeNode || driver.mode == ModeAll
+}
+
+// 访问模式是否是驱动支持的
+func (driver *MyCSIDriver) IsSupportedAccessMode(mode csi.VolumeCapability_AccessMode_Mode) bool {
+	for _, m := range driver.vcap {
+		if m.GetMode() == mode {
+			return true
+		}
+	}
+	return false
+}
+
 // 校验 csi-controller 服务的请求
 // csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME
 func (driver *MyCSIDriver) ValidateControllerServiceRequest(c csi.ControllerServiceCapability_RPC_Type) error {
@@ -193,3 +325,39 @@ func (driver *MyCSIDriver) AddNodeServiceCapabilities(nl []csi.NodeServiceCapabi
 	driver.nscap = nsc
 	return nil
 }
+
+// 设置驱动的 topology,为空表示不支持 topology
+func (driver *MyCSIDriver) SetTopology(segments map[string]string, maxVolumesPerNode int64) {
+	glog.V(3).Infof("mycsi: SetTopology. segments: %v, maxVolumesPerNode: %d", segments, maxVolumesPerNode)
+	driver.topology = segments
+	driver.maxVolumesPerNode = maxVolumesPerNode
+}
+
+// 设置 metrics 的监听地址以及优雅关闭的超时时间
+func (driver *MyCSIDriver) SetServerOptions(metricsAddress string, shutdownTimeout time.Duration) {
+	glog.V(3).Infof("mycsi: SetServerOptions. metricsAddress: %s, shutdownTimeout: %v", metricsAddress, shutdownTimeout)
+	driver.metricsAddress = metricsAddress
+	driver.shutdownTimeout = shutdownTimeout
+}
+
+// 驱动的 topology,没有配置时返回 nil
+func (driver *MyCSIDriver) AccessibleTopology() *csi.Topology {
+	if len(driver.topology) == 0 {
+		return nil
+	}
+	segments := make(map[string]string, len(driver.topology))
+	for k, v := range driver.topology {
+		segments[k] = v
+	}
+	return &csi.Topology{Segments: segments}
+}
+
+// topology 中的每个 segment 都和驱动的一致才可以访问
+func (driver *MyCSIDriver) IsAccessibleFrom(topology *csi.Topology) bool {
+	for k, v := range topology.GetSegments() {
+		if driver.topology[k] != v {
+			return false
+		}
+	}
+	return true
+}
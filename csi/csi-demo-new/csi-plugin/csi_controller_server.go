@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	"github.com/golang/glog"
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/kubernetes-csi/csi-lib-utils/protosanitizer"
 	"golang.org/x/net/context"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
@@ -22,30 +24,63 @@ const (
 type ControllerServer struct {
 	// csi driver
 	Driver *MyCSIDriver
+	// 同一个 volume 或者快照上同时只能有一个操作
+	inFlight *InFlight
 }
 
 // 容量扩展
+// 扩容后端存储,文件系统的扩容由 csi-node 的 NodeExpandVolume 完成
 func (cs *ControllerServer) ControllerExpandVolume(ctx context.Context, req *csi.ControllerExpandVolumeRequest) (*csi.ControllerExpandVolumeResponse, error) {
+	glog.V(3).Infof("controller expand volume req: %s", protosanitizer.StripSecrets(req))
+
+	if err := cs.Driver.ValidateControllerServiceRequest(csi.ControllerServiceCapability_RPC_EXPAND_VOLUME); err != nil {
+		glog.V(3).Infof("expand volume 无效的请求: %s", protosanitizer.StripSecrets(req))
+		return nil, err
+	}
 
 	volumeID := req.GetVolumeId()
 	if volumeID == "" {
 		return nil, status.Error(codes.InvalidArgument, "VolumeID不存在")
 	}
+	capRange := req.GetCapacityRange()
+	if capRange == nil {
+		return nil, status.Error(codes.InvalidArgument, "CapacityRange必填")
+	}
+	newSize := capRange.GetRequiredBytes()
+	if newSize <= 0 {
+		return nil, status.Error(codes.InvalidArgument, "CapacityRange.RequiredBytes必须大于0")
+	}
+	if limit := capRange.GetLimitBytes(); limit > 0 && newSize > limit {
+		return nil, status.Error(codes.OutOfRange, fmt.Sprintf("RequiredBytes %d 大于 LimitBytes %d", newSize, limit))
+	}
 
-	// newSize := int(req.GetCapacityRange().GetRequiredBytes() / GiB)
-
-	// Perform Actual Volume Expansion using your API
+	release, err := cs.inFlight.Acquire(ctx, volumeID)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	vol, err := cs.Driver.backend.ExpandVolume(volumeID, newSize)
+	switch err {
+	case nil:
+	case ErrVolumeNotFound:
+		return nil, status.Error(codes.NotFound, fmt.Sprintf("volume %s 不存在", volumeID))
+	case ErrVolumeShrink:
+		return nil, status.Error(codes.OutOfRange, fmt.Sprintf("volume %s 不支持缩容到 %d", volumeID, newSize))
+	default:
+		return nil, status.Error(codes.Internal, fmt.Sprintf("扩容 volume %s 失败: %v", volumeID, err))
+	}
 
 	return &csi.ControllerExpandVolumeResponse{
-		CapacityBytes: req.GetCapacityRange().GetRequiredBytes(),
-		// 是否需要扩展volume容量
-		NodeExpansionRequired: false,
+		CapacityBytes: vol.CapacityBytes,
+		// 镜像文件上的文件系统需要 csi-node 扩容
+		NodeExpansionRequired: true,
 	}, nil
 }
 
 // ControllerGetCapabilities csi-controller 的 capbility(例如. 插件可能未实现 GetCapacity、Snapshotting)
 func (cs *ControllerServer) ControllerGetCapabilities(ctx context.Context, req *csi.ControllerGetCapabilitiesRequest) (*csi.ControllerGetCapabilitiesResponse, error) {
-	glog.V(4).Infof("ControllerGetCapabilities called with req: %#v", req)
+	glog.V(4).Infof("ControllerGetCapabilities called with req: %s", protosanitizer.StripSecrets(req))
 	return &csi.ControllerGetCapabilitiesResponse{
 		Capabilities: cs.Driver.cscap,
 	}, nil
@@ -55,10 +90,10 @@ func (cs *ControllerServer) ControllerGetCapabilities(ctx context.Context, req *
 func (cs *ControllerServer) ControllerPublishVolume(ctx context.Context, req *csi.ControllerPublishVolumeRequest) (*csi.ControllerPublishVolumeResponse, error) {
 
 	glog.V(3).Infof("controllerserver ControllerPublishVolume")
-	glog.V(4).Infof("ControllerPublishVolume : req %#v", req)
+	glog.V(4).Infof("ControllerPublishVolume : req %s", protosanitizer.StripSecrets(req))
 
 	if err := cs.Driver.ValidateControllerServiceRequest(csi.ControllerServiceCapability_RPC_PUBLISH_UNPUBLISH_VOLUME); err != nil {
-		glog.V(3).Infof("invalid Publish volume request: %v", req)
+		glog.V(3).Infof("invalid Publish volume request: %s", protosanitizer.StripSecrets(req))
 		return nil, status.Error(codes.Internal, fmt.Sprintf("ControllerPublishVolume: ValidateControllerServiceRequest failed: %v", err))
 	}
 
@@ -72,7 +107,19 @@ func (cs *ControllerServer) ControllerPublishVolume(ctx context.Context, req *cs
 		return nil, status.Error(codes.InvalidArgument, "VolumeID is not present")
 	}
 
-	// Perform attaching the volume to the worker node using nodeID (worker node host name) and volumeID (volume being asked to be attached to worker node)
+	release, err := cs.inFlight.Acquire(ctx, volumeID)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	// 记录 volume 发布到的节点,ControllerGetVolume 和 ListVolumes 会返回
+	if err := cs.Driver.backend.PublishVolume(volumeID, nodeID); err != nil {
+		if err == ErrVolumeNotFound {
+			return nil, status.Error(codes.NotFound, fmt.Sprintf("volume %s 不存在", volumeID))
+		}
+		return nil, status.Error(codes.Internal, fmt.Sprintf("发布 volume %s 到节点 %s 失败: %v", volumeID, nodeID, err))
+	}
 
 	return &csi.ControllerPublishVolumeResponse{}, nil
 }
@@ -81,10 +128,10 @@ func (cs *ControllerServer) ControllerPublishVolume(ctx context.Context, req *cs
 func (cs *ControllerServer) ControllerUnpublishVolume(ctx context.Context, req *csi.ControllerUnpublishVolumeRequest) (*csi.ControllerUnpublishVolumeResponse, error) {
 
 	glog.V(3).Infof("controllerserver ControllerUnpublishVolume")
-	glog.V(4).Infof("ControllerUnpublishVolume : req %#v", req)
+	glog.V(4).Infof("ControllerUnpublishVolume : req %s", protosanitizer.StripSecrets(req))
 
 	if err := cs.Driver.ValidateControllerServiceRequest(csi.ControllerServiceCapability_RPC_PUBLISH_UNPUBLISH_VOLUME); err != nil {
-		glog.V(3).Infof("invalid Unpublish volume request: %v", req)
+		glog.V(3).Infof("invalid Unpublish volume request: %s", protosanitizer.StripSecrets(req))
 		return nil, status.Error(codes.Internal, fmt.Sprintf("ControllerUnpublishVolume: ValidateControllerServiceRequest failed: %v", err))
 	}
 
@@ -98,34 +145,137 @@ func (cs *ControllerServer) ControllerUnpublishVolume(ctx context.Context, req *
 		return nil, status.Error(codes.InvalidArgument, "VolumeID is not present")
 	}
 
-	// Perform detaching the volume from the worker node using nodeID and volumeID
+	release, err := cs.inFlight.Acquire(ctx, volumeID)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	// 删除 volume 发布到节点的记录
+	if err := cs.Driver.backend.UnpublishVolume(volumeID, nodeID); err != nil {
+		return nil, status.Error(codes.Internal, fmt.Sprintf("从节点 %s 卸载 volume %s 失败: %v", nodeID, volumeID, err))
+	}
 
 	return &csi.ControllerUnpublishVolumeResponse{}, nil
 }
 
 // CreateSnapshot 快照
 func (cs *ControllerServer) CreateSnapshot(ctx context.Context, req *csi.CreateSnapshotRequest) (*csi.CreateSnapshotResponse, error) {
-	return nil, status.Error(codes.Unimplemented, "")
+	glog.V(3).Infof("create snapshot req: %s", protosanitizer.StripSecrets(req))
+
+	if err := cs.Driver.ValidateControllerServiceRequest(csi.ControllerServiceCapability_RPC_CREATE_DELETE_SNAPSHOT); err != nil {
+		glog.V(3).Infof("create snapshot 无效的请求: %s", protosanitizer.StripSecrets(req))
+		return nil, err
+	}
+
+	name := req.GetName()
+	if name == "" {
+		return nil, status.Error(codes.InvalidArgument, "快照名称必填,为空失败")
+	}
+	sourceVolumeID := req.GetSourceVolumeId()
+	if sourceVolumeID == "" {
+		return nil, status.Error(codes.InvalidArgument, "SourceVolumeId为空")
+	}
+
+	release, err := cs.inFlight.Acquire(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	snap, err := cs.Driver.backend.CreateSnapshot(name, sourceVolumeID)
+	switch err {
+	case nil:
+	case ErrVolumeNotFound:
+		return nil, status.Error(codes.NotFound, fmt.Sprintf("源 volume %s 不存在", sourceVolumeID))
+	case ErrSnapshotExists:
+		return nil, status.Error(codes.AlreadyExists, fmt.Sprintf("快照 %s 已存在并且源 volume 不是 %s", name, sourceVolumeID))
+	default:
+		return nil, status.Error(codes.Internal, fmt.Sprintf("创建快照 %s 失败: %v", name, err))
+	}
+
+	return &csi.CreateSnapshotResponse{
+		Snapshot: newCSISnapshot(snap),
+	}, nil
 }
 
 // DeleteSnapshot 删除快照
 func (cs *ControllerServer) DeleteSnapshot(ctx context.Context, req *csi.DeleteSnapshotRequest) (*csi.DeleteSnapshotResponse, error) {
-	return nil, status.Error(codes.Unimplemented, "")
+	glog.V(3).Infof("delete snapshot req: %s", protosanitizer.StripSecrets(req))
+
+	if err := cs.Driver.ValidateControllerServiceRequest(csi.ControllerServiceCapability_RPC_CREATE_DELETE_SNAPSHOT); err != nil {
+		glog.V(3).Infof("delete snapshot 无效的请求: %s", protosanitizer.StripSecrets(req))
+		return nil, err
+	}
+
+	snapshotID := req.GetSnapshotId()
+	if snapshotID == "" {
+		return nil, status.Error(codes.InvalidArgument, "SnapshotId为空")
+	}
+	release, err := cs.inFlight.Acquire(ctx, snapshotID)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	// 快照不存在也返回成功
+	if err := cs.Driver.backend.DeleteSnapshot(snapshotID); err != nil {
+		return nil, status.Error(codes.Internal, fmt.Sprintf("删除快照 %s 失败: %v", snapshotID, err))
+	}
+
+	return &csi.DeleteSnapshotResponse{}, nil
 }
 
 // ListSnapshots list 快照
+// StartingToken 是上一页返回的下标,可以按 SnapshotId 或者 SourceVolumeId 过滤
 func (cs *ControllerServer) ListSnapshots(ctx context.Context, req *csi.ListSnapshotsRequest) (*csi.ListSnapshotsResponse, error) {
-	return nil, status.Error(codes.Unimplemented, "")
+	glog.V(4).Infof("list snapshots req: %s", protosanitizer.StripSecrets(req))
+
+	if err := cs.Driver.ValidateControllerServiceRequest(csi.ControllerServiceCapability_RPC_LIST_SNAPSHOTS); err != nil {
+		glog.V(3).Infof("list snapshots 无效的请求: %s", protosanitizer.StripSecrets(req))
+		return nil, err
+	}
+
+	all, err := cs.Driver.backend.ListSnapshots()
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	var snaps []*Snapshot
+	for _, snap := range all {
+		if req.GetSnapshotId() != "" && snap.ID != req.GetSnapshotId() {
+			continue
+		}
+		if req.GetSourceVolumeId() != "" && snap.SourceVolumeID != req.GetSourceVolumeId() {
+			continue
+		}
+		snaps = append(snaps, snap)
+	}
+
+	start, end, nextToken, err := paginate(req.GetStartingToken(), req.GetMaxEntries(), len(snaps))
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]*csi.ListSnapshotsResponse_Entry, 0, end-start)
+	for _, snap := range snaps[start:end] {
+		entries = append(entries, &csi.ListSnapshotsResponse_Entry{
+			Snapshot: newCSISnapshot(snap),
+		})
+	}
+
+	return &csi.ListSnapshotsResponse{
+		Entries:   entries,
+		NextToken: nextToken,
+	}, nil
 }
 
 // CreateVolume 创建volume
 func (cs *ControllerServer) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest) (*csi.CreateVolumeResponse, error) {
 
-	glog.V(3).Infof("create volume req: %v", req)
+	glog.V(3).Infof("create volume req: %s", protosanitizer.StripSecrets(req))
 
 	// 首先判断 grpc 调用 csi-controller 的请求是否由创建和删除 volume 的 capability
 	if err := cs.Driver.ValidateControllerServiceRequest(csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME); err != nil {
-		glog.V(3).Infof("create volume 无效的请求: %v", req)
+		glog.V(3).Infof("create volume 无效的请求: %s", protosanitizer.StripSecrets(req))
 		return nil, status.Error(codes.Internal, fmt.Sprintf("创建 volume 失败: %v", err))
 	}
 
@@ -139,58 +289,102 @@ func (cs *ControllerServer) CreateVolume(ctx context.Context, req *csi.CreateVol
 		return nil, status.Error(codes.InvalidArgument, "volume 名称必填,为空失败")
 	}
 
+	release, err := cs.inFlight.Acquire(ctx, volName)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
 	// 获取 volume 的 byte大小的
 	volSize, err := cs.GetVolumeSizeInBytes(req)
 	if err != nil {
 		return nil, err
 	}
-	// 换算成 GiB
-	volSize = (volSize) / GiB
 
 	// volume 的 capability
 	reqCapabilities := req.GetVolumeCapabilities()
 	if reqCapabilities == nil {
 		return nil, status.Error(codes.InvalidArgument, "volume capability 必填")
 	}
+	if err := cs.validateVolumeCapabilities(reqCapabilities); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
 
 	// 获取 create volume 的参数
 	parameters := req.GetParameters()
+	encrypted, err := isEncrypted(parameters)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if encrypted {
+		for _, cap := range reqCapabilities {
+			if cap.GetBlock() != nil {
+				return nil, status.Error(codes.InvalidArgument, "加密 volume 不支持 raw block")
+			}
+		}
+	}
+
+	// volume 创建在本驱动的后端存储上,只能满足和驱动 topology 一致的要求
+	if err := cs.checkAccessibilityRequirements(req.GetAccessibilityRequirements()); err != nil {
+		return nil, err
+	}
+
+	// volume 的数据源
+	var source *VolumeSource
+	if snapshot := req.GetVolumeContentSource().GetSnapshot(); snapshot != nil {
+		if err := cs.Driver.ValidateControllerServiceRequest(csi.ControllerServiceCapability_RPC_CREATE_DELETE_SNAPSHOT); err != nil {
+			return nil, err
+		}
+		source = &VolumeSource{SnapshotID: snapshot.GetSnapshotId()}
+	}
+	if volume := req.GetVolumeContentSource().GetVolume(); volume != nil {
+		if err := cs.Driver.ValidateControllerServiceRequest(csi.ControllerServiceCapability_RPC_CLONE_VOLUME); err != nil {
+			return nil, err
+		}
+		source = &VolumeSource{VolumeID: volume.GetVolumeId()}
+	}
 
-	// name := volName
-	// sizeize := int(volSize)
-	// volumeType := parameters["type"]
-	// availabilityZone := parameters["availability"]
-	// fsType := parameters["fstype"]
-	// multiAttach := false
-
-	/*
-	   for _, reqCap := range reqCapabilities {
-	           if reqCap.GetAccessMode().GetMode() == csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY {
-	                   multiAttach = true
-	           }
-	           if reqCap.GetAccessMode().GetMode() == csi.VolumeCapability_AccessMode_MULTI_NODE_SINGLE_WRITER {
-	                   multiAttach = true
-	           }
-	           if reqCap.GetAccessMode().GetMode() == csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER {
-	                   multiAttach = true
-	           }
-	   }
-
-	*/
-
-	// 创建 volume 逻辑,比如判断 volume 是否已经存在
-	// volume id要唯一
-	// 可以rand now uuid.NewUUID.String()
-	ID := "volume id collected"
+	// 没有指定容量时使用数据源的大小,数据源可能大于默认的容量
+	if source != nil && req.GetCapacityRange().GetRequiredBytes() == 0 {
+		if volSize, err = cs.sourceSize(source); err != nil {
+			return nil, err
+		}
+		if limit := req.GetCapacityRange().GetLimitBytes(); limit > 0 && volSize > limit {
+			return nil, status.Error(codes.OutOfRange, fmt.Sprintf("数据源的大小 %d 大于 LimitBytes %d", volSize, limit))
+		}
+	}
+
+	// 创建 volume,同名 volume 已经存在时后端直接返回已有的 volume
+	// volume id 由后端生成,保证唯一
+	vol, err := cs.Driver.backend.CreateVolume(volName, volSize, parameters, source)
+	switch err {
+	case nil:
+	case ErrVolumeExists:
+		return nil, status.Error(codes.AlreadyExists, fmt.Sprintf("volume %s 已存在并且容量或者数据源不一致", volName))
+	case ErrSnapshotNotFound:
+		return nil, status.Error(codes.NotFound, fmt.Sprintf("快照 %s 不存在", source.SnapshotID))
+	case ErrVolumeNotFound:
+		return nil, status.Error(codes.NotFound, fmt.Sprintf("源 volume %s 不存在", source.VolumeID))
+	case ErrVolumeTooSmall:
+		return nil, status.Error(codes.OutOfRange, fmt.Sprintf("volume 容量 %d 小于数据源的大小", volSize))
+	default:
+		return nil, status.Error(codes.Internal, fmt.Sprintf("创建 volume %s 失败: %v", volName, err))
+	}
+	// 已有的 volume 扩容之后可能超过这次请求的 LimitBytes
+	if limit := req.GetCapacityRange().GetLimitBytes(); limit > 0 && vol.CapacityBytes > limit {
+		return nil, status.Error(codes.AlreadyExists, fmt.Sprintf("volume %s 已存在并且容量 %d 大于 LimitBytes %d", volName, vol.CapacityBytes, limit))
+	}
 
 	return &csi.CreateVolumeResponse{
 		// 返回 volume
-		// XXX 可忽略
 		Volume: &csi.Volume{
-			VolumeId:      ID,
-			CapacityBytes: 0,
+			VolumeId:      vol.ID,
+			CapacityBytes: vol.CapacityBytes,
 			// 放在自动创建的 spec.csi.volumeAttributes
 			VolumeContext: parameters,
+			ContentSource: req.GetVolumeContentSource(),
+			// volume 只能被 topology 一致的节点访问
+			AccessibleTopology: cs.accessibleTopologies(),
 		},
 	}, nil
 }
@@ -198,36 +392,161 @@ func (cs *ControllerServer) CreateVolume(ctx context.Context, req *csi.CreateVol
 // DeleteVolume 删除volume
 func (cs *ControllerServer) DeleteVolume(ctx context.Context, req *csi.DeleteVolumeRequest) (*csi.DeleteVolumeResponse, error) {
 
-	glog.V(3).Infof("delete volume req: %v", req)
+	glog.V(3).Infof("delete volume req: %s", protosanitizer.StripSecrets(req))
 
 	// 通过卷id删除
 	volumeID := req.GetVolumeId()
 	if volumeID == "" {
 		return nil, status.Error(codes.InvalidArgument, "VolumeID为空")
 	}
-	// 删除卷的逻辑 claim volume 删除
-	// 比如 hostpath 那就是删除目录,还有将 volume 删除,往往会有个切片管理所有的 volume
-	// s.Volumes = append(s.Volumes[:i], s.Volumes[i+1:]...)  删除第i个
+	release, err := cs.inFlight.Acquire(ctx, volumeID)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	// 删除卷的数据以及索引,volume 不存在也返回成功
+	if err := cs.Driver.backend.DeleteVolume(volumeID); err != nil {
+		return nil, status.Error(codes.Internal, fmt.Sprintf("删除 volume %s 失败: %v", volumeID, err))
+	}
 
 	return &csi.DeleteVolumeResponse{}, nil
 }
 
-// GetCapacity volume 的 capability
+// GetCapacity 后端存储的剩余容量
+// 请求的 topology 不是本驱动可以访问的,剩余容量为 0
 func (cs *ControllerServer) GetCapacity(ctx context.Context, req *csi.GetCapacityRequest) (*csi.GetCapacityResponse, error) {
-	return nil, status.Error(codes.Unimplemented, "")
+	glog.V(4).Infof("get capacity req: %s", protosanitizer.StripSecrets(req))
+
+	if err := cs.Driver.ValidateControllerServiceRequest(csi.ControllerServiceCapability_RPC_GET_CAPACITY); err != nil {
+		glog.V(3).Infof("get capacity 无效的请求: %s", protosanitizer.StripSecrets(req))
+		return nil, err
+	}
+
+	if topology := req.GetAccessibleTopology(); topology != nil && !cs.Driver.IsAccessibleFrom(topology) {
+		return &csi.GetCapacityResponse{AvailableCapacity: 0}, nil
+	}
+
+	available, err := cs.Driver.backend.GetCapacity()
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &csi.GetCapacityResponse{
+		AvailableCapacity: available,
+	}, nil
 }
 
 // ListVolumes list volume
+// StartingToken 是上一页返回的下标
 func (cs *ControllerServer) ListVolumes(ctx context.Context, req *csi.ListVolumesRequest) (*csi.ListVolumesResponse, error) {
-	return nil, status.Error(codes.Unimplemented, "")
+	glog.V(4).Infof("list volumes req: %s", protosanitizer.StripSecrets(req))
+
+	if err := cs.Driver.ValidateControllerServiceRequest(csi.ControllerServiceCapability_RPC_LIST_VOLUMES); err != nil {
+		glog.V(3).Infof("list volumes 无效的请求: %s", protosanitizer.StripSecrets(req))
+		return nil, err
+	}
+
+	vols, err := cs.Driver.backend.ListVolumes()
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	start, end, nextToken, err := paginate(req.GetStartingToken(), req.GetMaxEntries(), len(vols))
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]*csi.ListVolumesResponse_Entry, 0, end-start)
+	for _, vol := range vols[start:end] {
+		entries = append(entries, &csi.ListVolumesResponse_Entry{
+			Volume: cs.newCSIVolume(vol),
+			Status: &csi.ListVolumesResponse_VolumeStatus{
+				PublishedNodeIds: vol.PublishedNodes,
+				VolumeCondition:  cs.volumeCondition(vol),
+			},
+		})
+	}
+
+	return &csi.ListVolumesResponse{
+		Entries:   entries,
+		NextToken: nextToken,
+	}, nil
 }
 
-// 次要 ControllerGetVolume csi-controller 获取 volume
+// 次要 ControllerGetVolume csi-controller 获取 volume,以及 volume 发布到的节点和健康状态
 func (cs *ControllerServer) ControllerGetVolume(ctx context.Context, req *csi.ControllerGetVolumeRequest) (*csi.ControllerGetVolumeResponse, error) {
-	return nil, status.Error(codes.Unimplemented, "")
+	glog.V(4).Infof("controller get volume req: %s", protosanitizer.StripSecrets(req))
+
+	if err := cs.Driver.ValidateControllerServiceRequest(csi.ControllerServiceCapability_RPC_GET_VOLUME); err != nil {
+		glog.V(3).Infof("controller get volume 无效的请求: %s", protosanitizer.StripSecrets(req))
+		return nil, err
+	}
+
+	volumeID := req.GetVolumeId()
+	if volumeID == "" {
+		return nil, status.Error(codes.InvalidArgument, "VolumeID为空")
+	}
+	vol, err := cs.Driver.backend.GetVolumeByID(volumeID)
+	if err == ErrVolumeNotFound {
+		return nil, status.Error(codes.NotFound, fmt.Sprintf("volume %s 不存在", volumeID))
+	}
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	return &csi.ControllerGetVolumeResponse{
+		Volume: cs.newCSIVolume(vol),
+		Status: &csi.ControllerGetVolumeResponse_VolumeStatus{
+			PublishedNodeIds: vol.PublishedNodes,
+			VolumeCondition:  cs.volumeCondition(vol),
+		},
+	}, nil
+}
+
+// backend 的 volume 转换成 csi 的 volume
+func (cs *ControllerServer) newCSIVolume(vol *Volume) *csi.Volume {
+	return &csi.Volume{
+		VolumeId:           vol.ID,
+		CapacityBytes:      vol.CapacityBytes,
+		VolumeContext:      vol.Parameters,
+		AccessibleTopology: cs.accessibleTopologies(),
+	}
+}
+
+// 没有配置 topology 时返回 nil,表示所有节点都可以访问
+func (cs *ControllerServer) accessibleTopologies() []*csi.Topology {
+	if topology := cs.Driver.AccessibleTopology(); topology != nil {
+		return []*csi.Topology{topology}
+	}
+	return nil
+}
+
+// 驱动的 topology 必须满足 requisite 中的一个,preferred 只是优先级,驱动只有一个 topology 可以选
+// 没有 requisite 时不限制
+func (cs *ControllerServer) checkAccessibilityRequirements(req *csi.TopologyRequirement) error {
+	if req == nil {
+		return nil
+	}
+	candidates := req.GetRequisite()
+	if len(candidates) == 0 {
+		return nil
+	}
+	for _, topology := range append(req.GetPreferred(), candidates...) {
+		if cs.Driver.IsAccessibleFrom(topology) {
+			glog.V(4).Infof("选择 topology %v", topology.GetSegments())
+			return nil
+		}
+	}
+	return status.Error(codes.ResourceExhausted, fmt.Sprintf("驱动的 topology %v 不满足 requisite topology 要求", cs.Driver.topology))
+}
+
+func (cs *ControllerServer) volumeCondition(vol *Volume) *csi.VolumeCondition {
+	if err := cs.Driver.backend.CheckVolume(vol); err != nil {
+		return &csi.VolumeCondition{Abnormal: true, Message: err.Error()}
+	}
+	return &csi.VolumeCondition{Abnormal: false, Message: "volume is healthy"}
 }
 
 // ValidateVolumeCapabilities 校验 volume 的 capability(例如：是否可以同时用于多个节点的读/写)
+// 访问模式必须是后端存储支持的
 func (cs *ControllerServer) ValidateVolumeCapabilities(ctx context.Context, req *csi.ValidateVolumeCapabilitiesRequest) (*csi.ValidateVolumeCapabilitiesResponse, error) {
 
 	volumeID := req.GetVolumeId()
@@ -240,21 +559,91 @@ func (cs *ControllerServer) ValidateVolumeCapabilities(ctx context.Context, req
 		return nil, status.Error(codes.InvalidArgument, "No volume capability specified")
 	}
 
-	for _, cap := range req.VolumeCapabilities {
-		if cap.GetAccessMode().GetMode() != csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER {
-			return &csi.ValidateVolumeCapabilitiesResponse{Message: ""}, nil
-		}
+	if _, err := cs.Driver.backend.GetVolumeByID(volumeID); err == ErrVolumeNotFound {
+		return nil, status.Error(codes.NotFound, fmt.Sprintf("volume %s 不存在", volumeID))
+	} else if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	if err := cs.validateVolumeCapabilities(req.VolumeCapabilities); err != nil {
+		return &csi.ValidateVolumeCapabilitiesResponse{Message: err.Error()}, nil
 	}
 	return &csi.ValidateVolumeCapabilitiesResponse{
 		Confirmed: &csi.ValidateVolumeCapabilitiesResponse_Confirmed{
+			VolumeContext:      req.GetVolumeContext(),
 			VolumeCapabilities: req.VolumeCapabilities,
+			Parameters:         req.GetParameters(),
 		},
 	}, nil
 }
 
+// 访问模式必须是驱动支持的,访问类型支持文件系统和 raw block
+func (cs *ControllerServer) validateVolumeCapabilities(caps []*csi.VolumeCapability) error {
+	for _, cap := range caps {
+		if cap.GetMount() == nil && cap.GetBlock() == nil {
+			return fmt.Errorf("不支持的访问类型 %v", cap.GetAccessType())
+		}
+		if mode := cap.GetAccessMode().GetMode(); !cs.Driver.IsSupportedAccessMode(mode) {
+			return fmt.Errorf("不支持的访问模式 %v", mode)
+		}
+	}
+	return nil
+}
+
 // 次要 GetVolumeSizeInBytes volume size
+// 没有指定 RequiredBytes 时使用默认大小,但不能超过 LimitBytes
 func (cs *ControllerServer) GetVolumeSizeInBytes(req *csi.CreateVolumeRequest) (int64, error) {
 
 	cap := req.GetCapacityRange()
-	return cap.GetRequiredBytes(), nil
+	required := cap.GetRequiredBytes()
+	limit := cap.GetLimitBytes()
+	if required < 0 || limit < 0 {
+		return 0, status.Error(codes.InvalidArgument, "volume 容量不能为负数")
+	}
+	if limit > 0 && required > limit {
+		return 0, status.Error(codes.OutOfRange, fmt.Sprintf("RequiredBytes %d 大于 LimitBytes %d", required, limit))
+	}
+	if required == 0 {
+		required = DefaultVolumeSize
+		if limit > 0 && limit < required {
+			required = limit
+		}
+	}
+	return required, nil
+}
+
+// 快照或者源 volume 的大小
+func (cs *ControllerServer) sourceSize(source *VolumeSource) (int64, error) {
+	if source.SnapshotID != "" {
+		snap, err := cs.Driver.backend.GetSnapshotByID(source.SnapshotID)
+		if err == ErrSnapshotNotFound {
+			return 0, status.Error(codes.NotFound, fmt.Sprintf("快照 %s 不存在", source.SnapshotID))
+		}
+		if err != nil {
+			return 0, status.Error(codes.Internal, err.Error())
+		}
+		return snap.SizeBytes, nil
+	}
+	vol, err := cs.Driver.backend.GetVolumeByID(source.VolumeID)
+	if err == ErrVolumeNotFound {
+		return 0, status.Error(codes.NotFound, fmt.Sprintf("源 volume %s 不存在", source.VolumeID))
+	}
+	if err != nil {
+		return 0, status.Error(codes.Internal, err.Error())
+	}
+	return vol.CapacityBytes, nil
+}
+
+// backend 的快照转换成 csi 的快照,拷贝完成即可使用
+func newCSISnapshot(snap *Snapshot) *csi.Snapshot {
+	return &csi.Snapshot{
+		SnapshotId:     snap.ID,
+		SourceVolumeId: snap.SourceVolumeID,
+		SizeBytes:      snap.SizeBytes,
+		CreationTime: &timestamp.Timestamp{
+			Seconds: snap.CreationTime.Unix(),
+			Nanos:   int32(snap.CreationTime.Nanosecond()),
+		},
+		ReadyToUse: true,
+	}
 }
@@ -0,0 +1,278 @@
+package mycsi
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestListVolumesPaging(t *testing.T) {
+	driver, _ := newTestDriver(t)
+	ctx := context.Background()
+	for i := 0; i < 5; i++ {
+		if _, err := driver.backend.CreateVolume(fmt.Sprintf("pvc-%d", i), MiB, nil, nil); err != nil {
+			t.Fatalf("CreateVolume: %v", err)
+		}
+	}
+
+	seen := map[string]bool{}
+	token := ""
+	for pages := 0; ; pages++ {
+		if pages > 3 {
+			t.Fatalf("too many pages")
+		}
+		resp, err := driver.cs.ListVolumes(ctx, &csi.ListVolumesRequest{MaxEntries: 2, StartingToken: token})
+		if err != nil {
+			t.Fatalf("ListVolumes: %v", err)
+		}
+		if len(resp.Entries) > 2 {
+			t.Fatalf("expected at most 2 entries, got %d", len(resp.Entries))
+		}
+		for _, e := range resp.Entries {
+			seen[e.Volume.VolumeId] = true
+		}
+		token = resp.NextToken
+		if token == "" {
+			break
+		}
+	}
+	if len(seen) != 5 {
+		t.Fatalf("expected 5 volumes, got %d", len(seen))
+	}
+
+	_, err := driver.cs.ListVolumes(ctx, &csi.ListVolumesRequest{StartingToken: "bogus"})
+	if status.Code(err) != codes.Aborted {
+		t.Fatalf("expected Aborted for invalid token, got %v", err)
+	}
+}
+
+func TestListReportsIndexErrors(t *testing.T) {
+	driver, _ := newTestDriver(t)
+	ctx := context.Background()
+	if _, err := driver.backend.CreateVolume("pvc-1", MiB, nil, nil); err != nil {
+		t.Fatalf("CreateVolume: %v", err)
+	}
+	// 索引损坏时不能返回空列表,CO 会认为 volume 已经不存在
+	root := driver.backend.(*hostPathBackend).root
+	if err := os.WriteFile(filepath.Join(root, volumeIndexFile), []byte("{"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := driver.cs.ListVolumes(ctx, &csi.ListVolumesRequest{}); status.Code(err) != codes.Internal {
+		t.Errorf("expected Internal from ListVolumes, got %v", err)
+	}
+	if _, err := driver.cs.ListSnapshots(ctx, &csi.ListSnapshotsRequest{}); status.Code(err) != codes.Internal {
+		t.Errorf("expected Internal from ListSnapshots, got %v", err)
+	}
+}
+
+func TestControllerGetVolume(t *testing.T) {
+	driver, _ := newTestDriver(t)
+	ctx := context.Background()
+	vol, err := driver.backend.CreateVolume("pvc-1", MiB, nil, nil)
+	if err != nil {
+		t.Fatalf("CreateVolume: %v", err)
+	}
+
+	if _, err := driver.cs.ControllerPublishVolume(ctx, &csi.ControllerPublishVolumeRequest{VolumeId: vol.ID, NodeId: "node-1"}); err != nil {
+		t.Fatalf("ControllerPublishVolume: %v", err)
+	}
+	resp, err := driver.cs.ControllerGetVolume(ctx, &csi.ControllerGetVolumeRequest{VolumeId: vol.ID})
+	if err != nil {
+		t.Fatalf("ControllerGetVolume: %v", err)
+	}
+	if nodes := resp.Status.PublishedNodeIds; len(nodes) != 1 || nodes[0] != "node-1" {
+		t.Errorf("expected published node node-1, got %v", nodes)
+	}
+	if resp.Status.VolumeCondition.Abnormal {
+		t.Errorf("expected healthy volume, got %v", resp.Status.VolumeCondition)
+	}
+
+	// 镜像文件丢失
+	if err := os.Remove(vol.Path); err != nil {
+		t.Fatal(err)
+	}
+	resp, err = driver.cs.ControllerGetVolume(ctx, &csi.ControllerGetVolumeRequest{VolumeId: vol.ID})
+	if err != nil {
+		t.Fatalf("ControllerGetVolume: %v", err)
+	}
+	if !resp.Status.VolumeCondition.Abnormal {
+		t.Errorf("expected abnormal volume condition")
+	}
+
+	_, err = driver.cs.ControllerGetVolume(ctx, &csi.ControllerGetVolumeRequest{VolumeId: "missing"})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("expected NotFound, got %v", err)
+	}
+}
+
+func TestGetCapacityTopology(t *testing.T) {
+	driver, _ := newTestDriver(t)
+	ctx := context.Background()
+
+	resp, err := driver.cs.GetCapacity(ctx, &csi.GetCapacityRequest{})
+	if err != nil {
+		t.Fatalf("GetCapacity: %v", err)
+	}
+	if resp.AvailableCapacity <= 0 {
+		t.Errorf("expected available capacity, got %d", resp.AvailableCapacity)
+	}
+
+	resp, err = driver.cs.GetCapacity(ctx, &csi.GetCapacityRequest{
+		AccessibleTopology: &csi.Topology{Segments: map[string]string{"topology.kubernetes.io/zone": "elsewhere"}},
+	})
+	if err != nil {
+		t.Fatalf("GetCapacity: %v", err)
+	}
+	if resp.AvailableCapacity != 0 {
+		t.Errorf("expected no capacity for a foreign topology, got %d", resp.AvailableCapacity)
+	}
+}
+
+func TestControllerExpandVolume(t *testing.T) {
+	driver, _ := newTestDriver(t)
+	ctx := context.Background()
+	vol, err := driver.backend.CreateVolume("pvc-1", 4*MiB, nil, nil)
+	if err != nil {
+		t.Fatalf("CreateVolume: %v", err)
+	}
+
+	resp, err := driver.cs.ControllerExpandVolume(ctx, &csi.ControllerExpandVolumeRequest{
+		VolumeId:      vol.ID,
+		CapacityRange: &csi.CapacityRange{RequiredBytes: 8 * MiB},
+	})
+	if err != nil {
+		t.Fatalf("ControllerExpandVolume: %v", err)
+	}
+	if resp.CapacityBytes != 8*MiB || !resp.NodeExpansionRequired {
+		t.Errorf("unexpected expand response: %v", resp)
+	}
+	if fi, err := os.Stat(vol.Path); err != nil || fi.Size() != 8*MiB {
+		t.Errorf("expected image file to grow to %d bytes, got %v, %v", 8*MiB, fi, err)
+	}
+
+	// 扩容之后重试原来的 CreateVolume 仍然成功,超过 LimitBytes 时失败
+	createReq := &csi.CreateVolumeRequest{
+		Name:               "pvc-1",
+		VolumeCapabilities: []*csi.VolumeCapability{mountCapability("ext4")},
+		CapacityRange:      &csi.CapacityRange{RequiredBytes: 4 * MiB},
+	}
+	created, err := driver.cs.CreateVolume(ctx, createReq)
+	if err != nil || created.Volume.VolumeId != vol.ID || created.Volume.CapacityBytes != 8*MiB {
+		t.Errorf("expected retried CreateVolume to return the expanded volume, got %v, %v", created, err)
+	}
+	createReq.CapacityRange.LimitBytes = 4 * MiB
+	if _, err := driver.cs.CreateVolume(ctx, createReq); status.Code(err) != codes.AlreadyExists {
+		t.Errorf("expected AlreadyExists above LimitBytes, got %v", err)
+	}
+
+	_, err = driver.cs.ControllerExpandVolume(ctx, &csi.ControllerExpandVolumeRequest{
+		VolumeId:      vol.ID,
+		CapacityRange: &csi.CapacityRange{RequiredBytes: 2 * MiB},
+	})
+	if status.Code(err) != codes.OutOfRange {
+		t.Errorf("expected OutOfRange when shrinking, got %v", err)
+	}
+
+	_, err = driver.cs.ControllerExpandVolume(ctx, &csi.ControllerExpandVolumeRequest{
+		VolumeId:      vol.ID,
+		CapacityRange: &csi.CapacityRange{LimitBytes: 16 * MiB},
+	})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("expected InvalidArgument without RequiredBytes, got %v", err)
+	}
+}
+
+func TestCreateVolumeTopology(t *testing.T) {
+	driver, _ := newTestDriver(t)
+	ctx := context.Background()
+	driver.SetTopology(map[string]string{"topology.my-csi-driver/node": "node-1"}, 0)
+
+	req := &csi.CreateVolumeRequest{
+		Name:               "pvc-1",
+		VolumeCapabilities: []*csi.VolumeCapability{mountCapability("ext4")},
+		CapacityRange:      &csi.CapacityRange{RequiredBytes: MiB},
+		AccessibilityRequirements: &csi.TopologyRequirement{
+			Requisite: []*csi.Topology{{Segments: map[string]string{"topology.my-csi-driver/node": "node-2"}}},
+		},
+	}
+	if _, err := driver.cs.CreateVolume(ctx, req); status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("expected ResourceExhausted for unsatisfiable topology, got %v", err)
+	}
+
+	req.AccessibilityRequirements.Requisite = append(req.AccessibilityRequirements.Requisite,
+		&csi.Topology{Segments: map[string]string{"topology.my-csi-driver/node": "node-1"}})
+	resp, err := driver.cs.CreateVolume(ctx, req)
+	if err != nil {
+		t.Fatalf("CreateVolume: %v", err)
+	}
+	topologies := resp.Volume.AccessibleTopology
+	if len(topologies) != 1 || topologies[0].Segments["topology.my-csi-driver/node"] != "node-1" {
+		t.Errorf("unexpected accessible topology: %v", topologies)
+	}
+}
+
+func TestValidateVolumeCapabilities(t *testing.T) {
+	driver, _ := newTestDriver(t)
+	ctx := context.Background()
+	vol, err := driver.backend.CreateVolume("pvc-1", MiB, nil, nil)
+	if err != nil {
+		t.Fatalf("CreateVolume: %v", err)
+	}
+
+	resp, err := driver.cs.ValidateVolumeCapabilities(ctx, &csi.ValidateVolumeCapabilitiesRequest{
+		VolumeId:           vol.ID,
+		VolumeCapabilities: []*csi.VolumeCapability{mountCapability("ext4")},
+	})
+	if err != nil || resp.Confirmed == nil {
+		t.Fatalf("expected single node writer to be confirmed, got %v, %v", resp, err)
+	}
+
+	multi := mountCapability("ext4")
+	multi.AccessMode.Mode = csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER
+	resp, err = driver.cs.ValidateVolumeCapabilities(ctx, &csi.ValidateVolumeCapabilitiesRequest{
+		VolumeId:           vol.ID,
+		VolumeCapabilities: []*csi.VolumeCapability{multi},
+	})
+	if err != nil || resp.Confirmed != nil {
+		t.Fatalf("expected multi node writer to be rejected, got %v, %v", resp, err)
+	}
+}
+
+func TestCreateVolumeDefaultsToSourceSize(t *testing.T) {
+	driver, _ := newTestDriver(t)
+	ctx := context.Background()
+	// 源 volume 大于默认容量
+	src, err := driver.backend.CreateVolume("pvc-src", DefaultVolumeSize+MiB, nil, nil)
+	if err != nil {
+		t.Fatalf("CreateVolume: %v", err)
+	}
+	req := &csi.CreateVolumeRequest{
+		Name:               "pvc-clone",
+		VolumeCapabilities: []*csi.VolumeCapability{mountCapability("ext4")},
+		VolumeContentSource: &csi.VolumeContentSource{
+			Type: &csi.VolumeContentSource_Volume{
+				Volume: &csi.VolumeContentSource_VolumeSource{VolumeId: src.ID},
+			},
+		},
+	}
+
+	req.CapacityRange = &csi.CapacityRange{LimitBytes: DefaultVolumeSize}
+	if _, err := driver.cs.CreateVolume(ctx, req); status.Code(err) != codes.OutOfRange {
+		t.Errorf("expected OutOfRange when the source exceeds LimitBytes, got %v", err)
+	}
+
+	req.CapacityRange = nil
+	resp, err := driver.cs.CreateVolume(ctx, req)
+	if err != nil {
+		t.Fatalf("CreateVolume: %v", err)
+	}
+	if resp.Volume.CapacityBytes != src.CapacityBytes {
+		t.Errorf("expected clone capacity %d, got %d", src.CapacityBytes, resp.Volume.CapacityBytes)
+	}
+}
@@ -14,16 +14,29 @@ type IdentityServer struct {
 }
 
 func (is *IdentityServer) GetPluginCapabilities(ctx context.Context, req *csi.GetPluginCapabilitiesRequest) (*csi.GetPluginCapabilitiesResponse, error) {
-	return &csi.GetPluginCapabilitiesResponse{
-		Capabilities: []*csi.PluginCapability{
-			{
-				Type: &csi.PluginCapability_Service_{
-					Service: &csi.PluginCapability_Service{
-						Type: csi.PluginCapability_Service_CONTROLLER_SERVICE,
-					},
+	var caps []*csi.PluginCapability
+	// 只有运行了 csi-controller 才上报 CONTROLLER_SERVICE
+	if is.Driver.RunsController() {
+		caps = append(caps, &csi.PluginCapability{
+			Type: &csi.PluginCapability_Service_{
+				Service: &csi.PluginCapability_Service{
+					Type: csi.PluginCapability_Service_CONTROLLER_SERVICE,
+				},
+			},
+		})
+	}
+	// 配置了 topology,volume 只能在 topology 一致的节点访问
+	if is.Driver.AccessibleTopology() != nil {
+		caps = append(caps, &csi.PluginCapability{
+			Type: &csi.PluginCapability_Service_{
+				Service: &csi.PluginCapability_Service{
+					Type: csi.PluginCapability_Service_VOLUME_ACCESSIBILITY_CONSTRAINTS,
 				},
 			},
-		},
+		})
+	}
+	return &csi.GetPluginCapabilitiesResponse{
+		Capabilities: caps,
 	}, nil
 }
 
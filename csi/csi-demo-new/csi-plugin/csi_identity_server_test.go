@@ -0,0 +1,39 @@
+package mycsi
+
+import (
+	"context"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+)
+
+func TestGetPluginCapabilitiesByMode(t *testing.T) {
+	driver, _ := newTestDriver(t)
+	ctx := context.Background()
+
+	hasController := func() bool {
+		resp, err := driver.ids.GetPluginCapabilities(ctx, &csi.GetPluginCapabilitiesRequest{})
+		if err != nil {
+			t.Fatalf("GetPluginCapabilities: %v", err)
+		}
+		for _, c := range resp.Capabilities {
+			if c.GetService().GetType() == csi.PluginCapability_Service_CONTROLLER_SERVICE {
+				return true
+			}
+		}
+		return false
+	}
+
+	if !hasController() {
+		t.Errorf("expected CONTROLLER_SERVICE in mode all")
+	}
+	if err := driver.SetMode(ModeNode); err != nil {
+		t.Fatal(err)
+	}
+	if hasController() {
+		t.Errorf("expected no CONTROLLER_SERVICE in mode node")
+	}
+	if err := driver.SetMode("bogus"); err == nil {
+		t.Errorf("expected an error for an unknown mode")
+	}
+}
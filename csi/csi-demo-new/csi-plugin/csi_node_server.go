@@ -3,28 +3,30 @@ package mycsi
 import (
 	"fmt"
 	"os"
-	"path/filepath"
-	"sync"
-	"time"
 
 	"github.com/golang/glog"
+	"github.com/kubernetes-csi/csi-lib-utils/protosanitizer"
 	"golang.org/x/net/context"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
-	"github.com/nightlyone/lockfile"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 )
 
+// 没有指定文件系统类型时默认格式化成 ext4
+const defaultFsType = "ext4"
+
 type NodeServer struct {
 	Driver *MyCSIDriver
-	// TODO: Only lock mutually exclusive calls and make locking more fine grained
-	mux sync.Mutex
+	// 挂载 格式化 等文件系统操作
+	mounter Mounter
+	// 同一个 volume 或者挂载路径上同时只能有一个操作
+	inFlight *InFlight
 }
 
 func (ns *NodeServer) NodePublishVolume(ctx context.Context, req *csi.NodePublishVolumeRequest) (*csi.NodePublishVolumeResponse, error) {
 	glog.V(3).Infof("nodeserver NodePublishVolume")
-	glog.V(4).Infof("NodePublishVolume called with req: %#v", req)
+	glog.V(4).Infof("NodePublishVolume called with req: %s", protosanitizer.StripSecrets(req))
 
 	// Validate Arguments
 	volumeID := req.GetVolumeId()
@@ -37,23 +39,71 @@ func (ns *NodeServer) NodePublishVolume(ctx context.Context, req *csi.NodePublis
 	if len(targetPath) == 0 {
 		return nil, status.Error(codes.InvalidArgument, "NodeStageVolume Target Path must be provided")
 	}
-	if len(stagingTargetPath) == 0 {
+	// inline ephemeral volume 不经过 stage
+	ephemeral := isEphemeral(req.GetVolumeContext())
+	if len(stagingTargetPath) == 0 && !ephemeral {
 		return nil, status.Error(codes.InvalidArgument, "NodeStageVolume Staging Target Path must be provided")
 	}
 	if volumeCapability == nil {
 		return nil, status.Error(codes.InvalidArgument, "NodeStageVolume Volume Capability must be provided")
 	}
 
-	// Perform the following:
-	// Create directory targetPath
-	// mount --bind -r stagingTargetPath targetPath
+	release, err := ns.inFlight.Acquire(ctx, volumeID, targetPath)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	if ephemeral {
+		if err := ns.publishEphemeralVolume(req); err != nil {
+			return nil, err
+		}
+		return &csi.NodePublishVolumeResponse{}, nil
+	}
+
+	// 已经 bind mount 过了,kubelet 重试直接返回成功
+	mounted, err := ns.isMountPoint(targetPath)
+	if err != nil {
+		return nil, status.Error(codes.Internal, fmt.Sprintf("检查 %s 是否挂载失败: %v", targetPath, err))
+	}
+	if mounted {
+		glog.V(4).Infof("NodePublishVolume: %s 已经挂载", targetPath)
+		return &csi.NodePublishVolumeResponse{}, nil
+	}
+	if volumeCapability.GetBlock() != nil {
+		if err := ns.publishBlockVolume(volumeID, targetPath, req.GetReadonly()); err != nil {
+			return nil, err
+		}
+		return &csi.NodePublishVolumeResponse{}, nil
+	}
+
+	// volume 必须已经 stage 到 stagingTargetPath
+	staged, err := ns.isMountPoint(stagingTargetPath)
+	if err != nil {
+		return nil, status.Error(codes.Internal, fmt.Sprintf("检查 %s 是否挂载失败: %v", stagingTargetPath, err))
+	}
+	if !staged {
+		return nil, status.Error(codes.FailedPrecondition, fmt.Sprintf("volume %s 还没有 stage 到 %s", volumeID, stagingTargetPath))
+	}
+
+	// mount --bind [-o ro] stagingTargetPath targetPath
+	if err := os.MkdirAll(targetPath, 0750); err != nil {
+		return nil, status.Error(codes.Internal, fmt.Sprintf("创建目录 %s 失败: %v", targetPath, err))
+	}
+	options := []string{"bind"}
+	if req.GetReadonly() {
+		options = append(options, "ro")
+	}
+	if err := ns.mounter.Mount(stagingTargetPath, targetPath, "", options); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
 
 	return &csi.NodePublishVolumeResponse{}, nil
 }
 
 func (ns *NodeServer) NodeUnpublishVolume(ctx context.Context, req *csi.NodeUnpublishVolumeRequest) (*csi.NodeUnpublishVolumeResponse, error) {
 	glog.V(3).Infof("nodeserver NodeUnpublishVolume")
-	glog.V(4).Infof("NodeUnpublishVolume called with args: %v", req)
+	glog.V(4).Infof("NodeUnpublishVolume called with args: %s", protosanitizer.StripSecrets(req))
 
 	// Validate Arguments
 	volumeID := req.GetVolumeId()
@@ -65,15 +115,28 @@ func (ns *NodeServer) NodeUnpublishVolume(ctx context.Context, req *csi.NodeUnpu
 		return nil, status.Error(codes.InvalidArgument, "NodeStageVolume Staging Target Path must be provided")
 	}
 
-	// Perform the following:
-	// unmount targetPath
+	release, err := ns.inFlight.Acquire(ctx, volumeID, targetPath)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	// umount targetPath 并删除目录,没有挂载或者目录不存在也返回成功
+	// raw block volume 可能还发布在其他 targetPath 上,loop 设备在 NodeUnstageVolume 中解除
+	if err := ns.unmountAndRemove(targetPath); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	// inline ephemeral volume 卸载后删除
+	if err := ns.deleteEphemeralVolume(volumeID); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
 
 	return &csi.NodeUnpublishVolumeResponse{}, nil
 }
 
 func (ns *NodeServer) NodeStageVolume(ctx context.Context, req *csi.NodeStageVolumeRequest) (
 	*csi.NodeStageVolumeResponse, error) {
-	glog.V(3).Infof("nodeserver NodeStageVolume %#v", req)
+	glog.V(3).Infof("nodeserver NodeStageVolume %s", protosanitizer.StripSecrets(req))
 
 	// Validate Arguments
 	volumeID := req.GetVolumeId()
@@ -89,32 +152,58 @@ func (ns *NodeServer) NodeStageVolume(ctx context.Context, req *csi.NodeStageVol
 		return nil, status.Error(codes.InvalidArgument, "NodeStageVolume Volume Capability must be provided")
 	}
 
-	fsType := volumeCapability.GetMount().FsType
+	fsType := volumeCapability.GetMount().GetFsType()
 	glog.V(3).Infof("nodeserver NodeStageVolume Required Filesystem Type : %s", fsType)
 
-	var pID = os.Getpid()
-	lock, err := lockfile.New(filepath.Join(os.TempDir(), "my-scsiscan.lck"))
+	release, err := ns.inFlight.Acquire(ctx, volumeID, stagingTargetPath)
 	if err != nil {
-		return nil, status.Error(codes.InvalidArgument, (fmt.Sprintf("%d : Cannot init lock. Reason. %v", pID, err)))
+		return nil, err
 	}
+	defer release()
 
-	for i := 0; i < 30; i++ {
-		err = lock.TryLock()
-		if err == nil {
-			break
-		}
-		glog.V(3).Infof("%d : Could not get lock, error is %v. Sleeping for 5 seconds", pID, err)
-		time.Sleep(5 * time.Second)
+	vol, err := ns.Driver.backend.GetVolumeByID(volumeID)
+	if err == ErrVolumeNotFound {
+		return nil, status.Error(codes.NotFound, fmt.Sprintf("volume %s 不存在", volumeID))
+	}
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
 	}
-	glog.V(3).Infof("%d : Got hold of Scsiscan lock", pID)
 
-	defer lock.Unlock()
+	// raw block volume 不需要格式化和挂载,在 NodePublishVolume 中直接发布 loop 设备
+	if volumeCapability.GetBlock() != nil {
+		return &csi.NodeStageVolumeResponse{}, nil
+	}
+
+	encrypted, err := isEncrypted(vol.Parameters)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if encrypted && req.GetSecrets()[encryptionPassphraseKey] == "" {
+		return nil, status.Error(codes.InvalidArgument, fmt.Sprintf("volume %s 是加密的, node-stage secret 中缺少 %s", volumeID, encryptionPassphraseKey))
+	}
+
+	// 已经 stage 过了,kubelet 重试直接返回成功
+	mounted, err := ns.isMountPoint(stagingTargetPath)
+	if err != nil {
+		return nil, status.Error(codes.Internal, fmt.Sprintf("检查 %s 是否挂载失败: %v", stagingTargetPath, err))
+	}
+	if mounted {
+		glog.V(4).Infof("NodeStageVolume: %s 已经挂载", stagingTargetPath)
+		return &csi.NodeStageVolumeResponse{}, nil
+	}
 
-	// Perform the following:
-	// Find where the attached volume exist on the worker node. Call it volDevicePath.
-	// See if volDevicePath has any file system created on it. If not, Do mkfsX on volDevicePath with fsType
-	// Create a directory stagingTargetPath
-	// mount volDevicePath stagingTargetPath
+	// 加密 volume 先打开 LUKS 映射,再格式化和挂载映射后的设备
+	device := vol.Path
+	if encrypted {
+		if device, err = ns.openEncryptedVolume(vol, req.GetSecrets()); err != nil {
+			return nil, err
+		}
+	}
+
+	// mount -o loop volDevicePath stagingTargetPath
+	if err := ns.formatAndMount(vol, device, stagingTargetPath, fsType, volumeCapability.GetMount().GetMountFlags()); err != nil {
+		return nil, err
+	}
 
 	return &csi.NodeStageVolumeResponse{}, nil
 }
@@ -133,53 +222,261 @@ func (ns *NodeServer) NodeUnstageVolume(ctx context.Context, req *csi.NodeUnstag
 		return nil, status.Error(codes.InvalidArgument, "NodeStageVolume Staging Target Path must be provided")
 	}
 
-	// Perform the following:
-	// Get the 'device mounted path' for stagingTargetPath:
-	//    1. run mount -w and grep for stagingTargetPath
-	//    2. first field in the output is 'device mounted path'
-	// Get all blockdevices of the 'device mounted path':
-	//    1. if device mounted path is /dev/mapper/mpath then:
-	//        a. multipath -l 'device mounted path'
-	//        b. get parent device from output
-	//        c. get slave device for parent device:
-	//           1. split parent device into sub strings with '/' as separator.
-	//           2. if number of fields is not 3 or second field has no 'dev' as prefix, return empty list as blockdevices.
-	//           3. else get third field from split, look for /sys/block/<third field>/slaves/, read it as directory and return files under the direcotry as blockdevices
-	//    2. if 'device mounted path' is not a multipath, return 'device mounted path' as block devices list of size 1.
-	// unmount stagingTargetPath
-	// Delete all blockdevices
-	// If applicable, remove all multiple path devices for 'device mounted path'
+	release, err := ns.inFlight.Acquire(ctx, volumeID, stagingTargetPath)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	// umount stagingTargetPath 并删除目录,没有挂载或者目录不存在也返回成功
+	if err := ns.unmountAndRemove(stagingTargetPath); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	// 加密 volume 关闭 LUKS 映射,volume 可能已经被删除,所以不依赖 volume 的参数
+	if err := ns.mounter.LuksClose(luksMapperName(volumeID)); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	// raw block volume 所有的 targetPath 都已经 unpublish,解除 loop 设备
+	// 文件系统 volume 的 loop 设备在 umount 时已经自动解除,这里什么也不做
+	if err := ns.detachBlockVolume(volumeID); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
 
 	return &csi.NodeUnstageVolumeResponse{}, nil
 }
 
 func (ns *NodeServer) NodeGetCapabilities(ctx context.Context, req *csi.NodeGetCapabilitiesRequest) (*csi.NodeGetCapabilitiesResponse, error) {
-	glog.V(4).Infof("NodeGetCapabilities called with req: %#v", req)
+	glog.V(4).Infof("NodeGetCapabilities called with req: %s", protosanitizer.StripSecrets(req))
 	return &csi.NodeGetCapabilitiesResponse{
 		Capabilities: ns.Driver.nscap,
 	}, nil
 }
 
 func (ns *NodeServer) NodeGetInfo(ctx context.Context, req *csi.NodeGetInfoRequest) (*csi.NodeGetInfoResponse, error) {
-	glog.V(4).Infof("NodeGetInfo called with req: %#v", req)
+	glog.V(4).Infof("NodeGetInfo called with req: %s", protosanitizer.StripSecrets(req))
 	return &csi.NodeGetInfoResponse{
-		NodeId: ns.Driver.nodeID,
+		NodeId:             ns.Driver.nodeID,
+		MaxVolumesPerNode:  ns.Driver.maxVolumesPerNode,
+		AccessibleTopology: ns.Driver.AccessibleTopology(),
 	}, nil
 }
 
+// NodeExpandVolume 在线扩容 volume 上的文件系统,后端存储已经由 ControllerExpandVolume 扩容
 func (ns *NodeServer) NodeExpandVolume(ctx context.Context, req *csi.NodeExpandVolumeRequest) (*csi.NodeExpandVolumeResponse, error) {
+	glog.V(4).Infof("NodeExpandVolume called with req: %s", protosanitizer.StripSecrets(req))
 
 	volumeID := req.GetVolumeId()
 	if volumeID == "" {
 		return nil, status.Error(codes.InvalidArgument, "VolumeID is not present")
 	}
+	volumePath := req.GetVolumePath()
+	if volumePath == "" {
+		return nil, status.Error(codes.InvalidArgument, "NodeExpandVolume Volume Path must be provided")
+	}
 
-	// newSize := int(req.GetCapacityRange().GetRequiredBytes() / GiB)
+	release, err := ns.inFlight.Acquire(ctx, volumeID)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
 
-	return &csi.NodeExpandVolumeResponse{}, nil
+	vol, err := ns.Driver.backend.GetVolumeByID(volumeID)
+	if err == ErrVolumeNotFound {
+		return nil, status.Error(codes.NotFound, fmt.Sprintf("volume %s 不存在", volumeID))
+	}
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if required := req.GetCapacityRange().GetRequiredBytes(); required > vol.CapacityBytes {
+		return nil, status.Error(codes.OutOfRange, fmt.Sprintf("volume %s 的容量 %d 小于 %d, 需要先扩容后端存储", volumeID, vol.CapacityBytes, required))
+	}
 
+	// raw block volume 只需要刷新 loop 设备的大小
+	if req.GetVolumeCapability().GetBlock() != nil {
+		if err := ns.refreshBlockVolume(vol); err != nil {
+			return nil, err
+		}
+		return &csi.NodeExpandVolumeResponse{CapacityBytes: vol.CapacityBytes}, nil
+	}
+
+	// 优先从 staging 目录找到挂载的设备
+	mountPath := volumePath
+	if stagingPath := req.GetStagingTargetPath(); stagingPath != "" {
+		mountPath = stagingPath
+	}
+	mounted, err := ns.isMountPoint(mountPath)
+	if err != nil {
+		return nil, status.Error(codes.Internal, fmt.Sprintf("检查 %s 是否挂载失败: %v", mountPath, err))
+	}
+	if !mounted {
+		return nil, status.Error(codes.NotFound, fmt.Sprintf("volume path %s 没有挂载", mountPath))
+	}
+	device, err := ns.mounter.GetMountDevice(mountPath)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	// 加密 volume 挂载的是 /dev/mapper 下的映射,需要先扩展映射
+	encrypted, err := isEncrypted(vol.Parameters)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if encrypted {
+		if err := ns.resizeEncryptedVolume(vol, req.GetSecrets()); err != nil {
+			return nil, err
+		}
+	}
+	if err := ns.mounter.ResizeFs(device, mountPath); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	return &csi.NodeExpandVolumeResponse{
+		CapacityBytes: vol.CapacityBytes,
+	}, nil
 }
 
+// NodeGetVolumeStats 通过 statfs 获取已挂载 volume 的容量和 inode 使用情况
+// volumePath 不存在或者没有挂载时返回不正常的 VolumeCondition
 func (ns *NodeServer) NodeGetVolumeStats(ctx context.Context, req *csi.NodeGetVolumeStatsRequest) (*csi.NodeGetVolumeStatsResponse, error) {
-	return nil, status.Error(codes.Unimplemented, "")
+	glog.V(4).Infof("NodeGetVolumeStats called with req: %s", protosanitizer.StripSecrets(req))
+
+	volumeID := req.GetVolumeId()
+	volumePath := req.GetVolumePath()
+	if len(volumeID) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "NodeGetVolumeStats Volume ID must be provided")
+	}
+	if len(volumePath) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "NodeGetVolumeStats Volume Path must be provided")
+	}
+
+	vol, err := ns.Driver.backend.GetVolumeByID(volumeID)
+	if err == ErrVolumeNotFound {
+		return nil, status.Error(codes.NotFound, fmt.Sprintf("volume %s 不存在", volumeID))
+	} else if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	fi, err := os.Stat(volumePath)
+	if os.IsNotExist(err) {
+		return abnormalVolumeStats(fmt.Sprintf("volume path %s 不存在", volumePath)), nil
+	} else if err != nil {
+		return nil, status.Error(codes.Internal, fmt.Sprintf("stat %s 失败: %v", volumePath, err))
+	}
+	mounted, err := ns.isMountPoint(volumePath)
+	if err != nil {
+		return nil, status.Error(codes.Internal, fmt.Sprintf("检查 %s 是否挂载失败: %v", volumePath, err))
+	}
+	if !mounted {
+		return abnormalVolumeStats(fmt.Sprintf("volume path %s 没有挂载", volumePath)), nil
+	}
+
+	// raw block volume 没有文件系统,只上报设备的大小
+	if !fi.IsDir() {
+		return &csi.NodeGetVolumeStatsResponse{
+			Usage: []*csi.VolumeUsage{
+				{
+					Unit:  csi.VolumeUsage_BYTES,
+					Total: vol.CapacityBytes,
+				},
+			},
+			VolumeCondition: &csi.VolumeCondition{
+				Abnormal: false,
+				Message:  "volume is healthy",
+			},
+		}, nil
+	}
+
+	stats, err := getFsStats(volumePath)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &csi.NodeGetVolumeStatsResponse{
+		Usage: []*csi.VolumeUsage{
+			{
+				Unit:      csi.VolumeUsage_BYTES,
+				Total:     stats.totalBytes,
+				Available: stats.availableBytes,
+				Used:      stats.usedBytes,
+			},
+			{
+				Unit:      csi.VolumeUsage_INODES,
+				Total:     stats.totalInodes,
+				Available: stats.freeInodes,
+				Used:      stats.usedInodes,
+			},
+		},
+		VolumeCondition: &csi.VolumeCondition{
+			Abnormal: false,
+			Message:  "volume is healthy",
+		},
+	}, nil
+}
+
+func abnormalVolumeStats(message string) *csi.NodeGetVolumeStatsResponse {
+	return &csi.NodeGetVolumeStatsResponse{
+		VolumeCondition: &csi.VolumeCondition{
+			Abnormal: true,
+			Message:  message,
+		},
+	}
+}
+
+// 镜像文件没有文件系统时按 fsType 格式化,已有文件系统则不能和 fsType 冲突
+// 然后通过 loop 设备挂载到 target
+func (ns *NodeServer) formatAndMount(vol *Volume, device, target, fsType string, mountFlags []string) error {
+	existingFormat, err := ns.mounter.GetDiskFormat(device)
+	if err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	switch {
+	case existingFormat == "":
+		if fsType == "" {
+			fsType = defaultFsType
+		}
+		if err := ns.mounter.Format(device, fsType); err != nil {
+			return status.Error(codes.Internal, err.Error())
+		}
+	case fsType == "":
+		fsType = existingFormat
+	case existingFormat != fsType:
+		return status.Error(codes.FailedPrecondition, fmt.Sprintf("volume %s 已经格式化成 %s, 与请求的 %s 不一致", vol.ID, existingFormat, fsType))
+	}
+
+	if err := os.MkdirAll(target, 0750); err != nil {
+		return status.Error(codes.Internal, fmt.Sprintf("创建目录 %s 失败: %v", target, err))
+	}
+	// 镜像文件需要通过 loop 挂载,LUKS 映射后的设备直接挂载
+	options := mountFlags
+	if device == vol.Path {
+		options = append([]string{"loop"}, mountFlags...)
+	}
+	if err := ns.mounter.Mount(device, target, fsType, options); err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	return nil
+}
+
+// path 不存在当作没有挂载
+func (ns *NodeServer) isMountPoint(path string) (bool, error) {
+	mounted, err := ns.mounter.IsMountPoint(path)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return mounted, err
+}
+
+// 卸载 path 并删除挂载目录
+func (ns *NodeServer) unmountAndRemove(path string) error {
+	mounted, err := ns.isMountPoint(path)
+	if err != nil {
+		return fmt.Errorf("检查 %s 是否挂载失败: %v", path, err)
+	}
+	if mounted {
+		if err := ns.mounter.Unmount(path); err != nil {
+			return err
+		}
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("删除目录 %s 失败: %v", path, err)
+	}
+	return nil
 }
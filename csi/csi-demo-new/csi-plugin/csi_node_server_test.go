@@ -0,0 +1,393 @@
+package mycsi
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// 使用 fake mounter 和临时目录下的 hostpath 后端实例化 csi driver
+func newTestDriver(t *testing.T) (*MyCSIDriver, *FakeMounter) {
+	t.Helper()
+	backend, err := NewHostPathBackend(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewHostPathBackend: %v", err)
+	}
+	mounter := NewFakeMounter()
+	driver := GetCSIDriver()
+	driver.mounter = mounter
+	if err := driver.InitializeDriver("test.csi.driver", "test", "node-1", backend); err != nil {
+		t.Fatalf("InitializeDriver: %v", err)
+	}
+	return driver, mounter
+}
+
+func mountCapability(fsType string) *csi.VolumeCapability {
+	return &csi.VolumeCapability{
+		AccessType: &csi.VolumeCapability_Mount{
+			Mount: &csi.VolumeCapability_MountVolume{FsType: fsType},
+		},
+		AccessMode: &csi.VolumeCapability_AccessMode{
+			Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+		},
+	}
+}
+
+func TestNodeStagePublishLifecycle(t *testing.T) {
+	driver, mounter := newTestDriver(t)
+	ctx := context.Background()
+	vol, err := driver.backend.CreateVolume("pvc-1", 10*MiB, nil, nil)
+	if err != nil {
+		t.Fatalf("CreateVolume: %v", err)
+	}
+	dir := t.TempDir()
+	staging := filepath.Join(dir, "staging")
+	target := filepath.Join(dir, "target")
+
+	stageReq := &csi.NodeStageVolumeRequest{
+		VolumeId:          vol.ID,
+		StagingTargetPath: staging,
+		VolumeCapability:  mountCapability("xfs"),
+	}
+	// 重复调用只会格式化和挂载一次
+	for i := 0; i < 2; i++ {
+		if _, err := driver.ns.NodeStageVolume(ctx, stageReq); err != nil {
+			t.Fatalf("NodeStageVolume #%d: %v", i, err)
+		}
+	}
+	if mounter.Formats[vol.Path] != "xfs" {
+		t.Errorf("expected %s formatted as xfs, got %q", vol.Path, mounter.Formats[vol.Path])
+	}
+	mp, ok := mounter.GetMountPoint(staging)
+	if !ok || mp.Source != vol.Path || mp.FsType != "xfs" {
+		t.Errorf("unexpected staging mount point: %+v, %v", mp, ok)
+	}
+
+	publishReq := &csi.NodePublishVolumeRequest{
+		VolumeId:          vol.ID,
+		StagingTargetPath: staging,
+		TargetPath:        target,
+		VolumeCapability:  mountCapability("xfs"),
+		Readonly:          true,
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := driver.ns.NodePublishVolume(ctx, publishReq); err != nil {
+			t.Fatalf("NodePublishVolume #%d: %v", i, err)
+		}
+	}
+	mp, ok = mounter.GetMountPoint(target)
+	if !ok || mp.Source != staging || len(mp.Options) != 2 || mp.Options[0] != "bind" || mp.Options[1] != "ro" {
+		t.Errorf("unexpected target mount point: %+v, %v", mp, ok)
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err := driver.ns.NodeUnpublishVolume(ctx, &csi.NodeUnpublishVolumeRequest{VolumeId: vol.ID, TargetPath: target}); err != nil {
+			t.Fatalf("NodeUnpublishVolume #%d: %v", i, err)
+		}
+		if _, err := driver.ns.NodeUnstageVolume(ctx, &csi.NodeUnstageVolumeRequest{VolumeId: vol.ID, StagingTargetPath: staging}); err != nil {
+			t.Fatalf("NodeUnstageVolume #%d: %v", i, err)
+		}
+	}
+	if len(mounter.MountPoints) != 0 {
+		t.Errorf("expected no mount points left, got %+v", mounter.MountPoints)
+	}
+
+	want := []string{"format", "mount", "mount", "umount", "umount"}
+	if len(mounter.Log) != len(want) {
+		t.Fatalf("expected operations %v, got %v", want, mounter.Log)
+	}
+	for i := range want {
+		if mounter.Log[i] != want[i] {
+			t.Fatalf("expected operations %v, got %v", want, mounter.Log)
+		}
+	}
+}
+
+func TestNodeStageVolumeErrors(t *testing.T) {
+	driver, mounter := newTestDriver(t)
+	ctx := context.Background()
+	staging := filepath.Join(t.TempDir(), "staging")
+
+	_, err := driver.ns.NodeStageVolume(ctx, &csi.NodeStageVolumeRequest{
+		VolumeId:          "missing",
+		StagingTargetPath: staging,
+		VolumeCapability:  mountCapability("ext4"),
+	})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("expected NotFound for missing volume, got %v", err)
+	}
+
+	vol, err := driver.backend.CreateVolume("pvc-1", 10*MiB, nil, nil)
+	if err != nil {
+		t.Fatalf("CreateVolume: %v", err)
+	}
+	mounter.Formats[vol.Path] = "ext4"
+	_, err = driver.ns.NodeStageVolume(ctx, &csi.NodeStageVolumeRequest{
+		VolumeId:          vol.ID,
+		StagingTargetPath: staging,
+		VolumeCapability:  mountCapability("xfs"),
+	})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("expected FailedPrecondition for fsType mismatch, got %v", err)
+	}
+
+	_, err = driver.ns.NodePublishVolume(ctx, &csi.NodePublishVolumeRequest{
+		VolumeId:          vol.ID,
+		StagingTargetPath: staging,
+		TargetPath:        filepath.Join(t.TempDir(), "target"),
+		VolumeCapability:  mountCapability("ext4"),
+	})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("expected FailedPrecondition for unstaged volume, got %v", err)
+	}
+}
+
+func TestNodeGetVolumeStats(t *testing.T) {
+	driver, mounter := newTestDriver(t)
+	ctx := context.Background()
+	vol, err := driver.backend.CreateVolume("pvc-1", 10*MiB, nil, nil)
+	if err != nil {
+		t.Fatalf("CreateVolume: %v", err)
+	}
+	dir := t.TempDir()
+
+	resp, err := driver.ns.NodeGetVolumeStats(ctx, &csi.NodeGetVolumeStatsRequest{VolumeId: vol.ID, VolumePath: filepath.Join(dir, "missing")})
+	if err != nil {
+		t.Fatalf("NodeGetVolumeStats: %v", err)
+	}
+	if !resp.VolumeCondition.GetAbnormal() {
+		t.Errorf("expected abnormal condition for a missing path")
+	}
+
+	resp, err = driver.ns.NodeGetVolumeStats(ctx, &csi.NodeGetVolumeStatsRequest{VolumeId: vol.ID, VolumePath: dir})
+	if err != nil {
+		t.Fatalf("NodeGetVolumeStats: %v", err)
+	}
+	if !resp.VolumeCondition.GetAbnormal() {
+		t.Errorf("expected abnormal condition for a path that is not mounted")
+	}
+
+	mounter.Mount(vol.Path, dir, "ext4", nil)
+	resp, err = driver.ns.NodeGetVolumeStats(ctx, &csi.NodeGetVolumeStatsRequest{VolumeId: vol.ID, VolumePath: dir})
+	if err != nil {
+		t.Fatalf("NodeGetVolumeStats: %v", err)
+	}
+	if resp.VolumeCondition.GetAbnormal() || len(resp.Usage) != 2 || resp.Usage[0].Total <= 0 {
+		t.Errorf("unexpected stats for a mounted path: %v", resp)
+	}
+
+	_, err = driver.ns.NodeGetVolumeStats(ctx, &csi.NodeGetVolumeStatsRequest{VolumeId: "missing", VolumePath: dir})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("expected NotFound, got %v", err)
+	}
+}
+
+func TestNodeExpandVolume(t *testing.T) {
+	driver, mounter := newTestDriver(t)
+	ctx := context.Background()
+	vol, err := driver.backend.CreateVolume("pvc-1", 4*MiB, nil, nil)
+	if err != nil {
+		t.Fatalf("CreateVolume: %v", err)
+	}
+	staging := filepath.Join(t.TempDir(), "staging")
+
+	req := &csi.NodeExpandVolumeRequest{
+		VolumeId:          vol.ID,
+		VolumePath:        filepath.Join(t.TempDir(), "target"),
+		StagingTargetPath: staging,
+		CapacityRange:     &csi.CapacityRange{RequiredBytes: 4 * MiB},
+	}
+	if _, err := driver.ns.NodeExpandVolume(ctx, req); status.Code(err) != codes.NotFound {
+		t.Fatalf("expected NotFound for an unmounted volume, got %v", err)
+	}
+
+	mounter.Mount(vol.Path, staging, "ext4", []string{"loop"})
+	resp, err := driver.ns.NodeExpandVolume(ctx, req)
+	if err != nil {
+		t.Fatalf("NodeExpandVolume: %v", err)
+	}
+	if resp.CapacityBytes != 4*MiB || mounter.Log[len(mounter.Log)-1] != "resize" {
+		t.Errorf("expected filesystem resize, got %v, log %v", resp, mounter.Log)
+	}
+}
+
+func blockCapability() *csi.VolumeCapability {
+	return &csi.VolumeCapability{
+		AccessType: &csi.VolumeCapability_Block{
+			Block: &csi.VolumeCapability_BlockVolume{},
+		},
+		AccessMode: &csi.VolumeCapability_AccessMode{
+			Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+		},
+	}
+}
+
+func TestNodeBlockVolumeLifecycle(t *testing.T) {
+	driver, mounter := newTestDriver(t)
+	ctx := context.Background()
+	vol, err := driver.backend.CreateVolume("pvc-1", 4*MiB, nil, nil)
+	if err != nil {
+		t.Fatalf("CreateVolume: %v", err)
+	}
+	dir := t.TempDir()
+	staging := filepath.Join(dir, "staging")
+	target := filepath.Join(dir, "pods", "block", vol.ID)
+
+	// block volume 不做格式化和挂载
+	if _, err := driver.ns.NodeStageVolume(ctx, &csi.NodeStageVolumeRequest{
+		VolumeId:          vol.ID,
+		StagingTargetPath: staging,
+		VolumeCapability:  blockCapability(),
+	}); err != nil {
+		t.Fatalf("NodeStageVolume: %v", err)
+	}
+	if len(mounter.Log) != 0 {
+		t.Fatalf("expected no operations for block stage, got %v", mounter.Log)
+	}
+
+	publishReq := &csi.NodePublishVolumeRequest{
+		VolumeId:          vol.ID,
+		StagingTargetPath: staging,
+		TargetPath:        target,
+		VolumeCapability:  blockCapability(),
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := driver.ns.NodePublishVolume(ctx, publishReq); err != nil {
+			t.Fatalf("NodePublishVolume #%d: %v", i, err)
+		}
+	}
+	// 重试不会重复挂载
+	if len(mounter.Log) != 2 || mounter.Log[0] != "attach" || mounter.Log[1] != "mount" {
+		t.Errorf("expected a single attach and mount, got %v", mounter.Log)
+	}
+	device, _ := mounter.FindLoopDevice(vol.Path)
+	if device == "" {
+		t.Fatalf("expected %s to be attached to a loop device", vol.Path)
+	}
+	mp, ok := mounter.GetMountPoint(target)
+	if !ok || mp.Source != device || len(mp.Options) != 1 || mp.Options[0] != "bind" {
+		t.Errorf("unexpected target mount point: %+v, %v", mp, ok)
+	}
+	if fi, err := os.Stat(target); err != nil || fi.IsDir() {
+		t.Errorf("expected target to be a file, got %v, %v", fi, err)
+	}
+
+	resp, err := driver.ns.NodeGetVolumeStats(ctx, &csi.NodeGetVolumeStatsRequest{VolumeId: vol.ID, VolumePath: target})
+	if err != nil {
+		t.Fatalf("NodeGetVolumeStats: %v", err)
+	}
+	if len(resp.Usage) != 1 || resp.Usage[0].Total != 4*MiB {
+		t.Errorf("unexpected block volume stats: %v", resp)
+	}
+
+	if _, err := driver.ns.NodeExpandVolume(ctx, &csi.NodeExpandVolumeRequest{
+		VolumeId:         vol.ID,
+		VolumePath:       target,
+		VolumeCapability: blockCapability(),
+	}); err != nil {
+		t.Fatalf("NodeExpandVolume: %v", err)
+	}
+	if mounter.Log[len(mounter.Log)-1] != "refresh" {
+		t.Errorf("expected loop device refresh, got %v", mounter.Log)
+	}
+
+	// 同一个 volume 发布到第二个 targetPath,复用同一个 loop 设备
+	other := filepath.Join(dir, "pods", "other", vol.ID)
+	publishReq.TargetPath = other
+	if _, err := driver.ns.NodePublishVolume(ctx, publishReq); err != nil {
+		t.Fatalf("NodePublishVolume to a second target: %v", err)
+	}
+	if mp, ok := mounter.GetMountPoint(other); !ok || mp.Source != device {
+		t.Errorf("expected second target to share %s, got %+v, %v", device, mp, ok)
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err := driver.ns.NodeUnpublishVolume(ctx, &csi.NodeUnpublishVolumeRequest{VolumeId: vol.ID, TargetPath: target}); err != nil {
+			t.Fatalf("NodeUnpublishVolume #%d: %v", i, err)
+		}
+	}
+	if _, err := os.Stat(target); !os.IsNotExist(err) {
+		t.Errorf("expected target to be removed, got %v", err)
+	}
+	// 另一个 targetPath 还在使用 loop 设备
+	if got, _ := mounter.FindLoopDevice(vol.Path); got != device {
+		t.Fatalf("expected %s to stay attached while %s is published, got %q", device, other, got)
+	}
+	if _, err := driver.ns.NodeUnpublishVolume(ctx, &csi.NodeUnpublishVolumeRequest{VolumeId: vol.ID, TargetPath: other}); err != nil {
+		t.Fatalf("NodeUnpublishVolume: %v", err)
+	}
+	if got, _ := mounter.FindLoopDevice(vol.Path); got != device {
+		t.Fatalf("expected %s to stay attached until unstage, got %q", device, got)
+	}
+
+	if _, err := driver.ns.NodeUnstageVolume(ctx, &csi.NodeUnstageVolumeRequest{VolumeId: vol.ID, StagingTargetPath: staging}); err != nil {
+		t.Fatalf("NodeUnstageVolume: %v", err)
+	}
+	if len(mounter.MountPoints) != 0 || len(mounter.LoopDevices) != 0 {
+		t.Errorf("expected no mount points and loop devices left, got %+v, %+v", mounter.MountPoints, mounter.LoopDevices)
+	}
+}
+
+// controller 和 node 分开部署,共享同一个 data-root
+// node 进程启动之后 controller 创建的 volume 也能 stage,两个进程的修改不会互相覆盖
+func TestSplitModeSharedDataRoot(t *testing.T) {
+	root := t.TempDir()
+	controllerBackend, err := NewHostPathBackend(root)
+	if err != nil {
+		t.Fatalf("NewHostPathBackend: %v", err)
+	}
+	nodeBackend, err := NewHostPathBackend(root)
+	if err != nil {
+		t.Fatalf("NewHostPathBackend: %v", err)
+	}
+	mounter := NewFakeMounter()
+	node := GetCSIDriver()
+	node.mounter = mounter
+	if err := node.SetMode(ModeNode); err != nil {
+		t.Fatal(err)
+	}
+	if err := node.InitializeDriver("test.csi.driver", "test", "node-1", nodeBackend); err != nil {
+		t.Fatalf("InitializeDriver: %v", err)
+	}
+	ctx := context.Background()
+
+	vol, err := controllerBackend.CreateVolume("pvc-1", 10*MiB, nil, nil)
+	if err != nil {
+		t.Fatalf("CreateVolume: %v", err)
+	}
+	staging := filepath.Join(t.TempDir(), "staging")
+	if _, err := node.ns.NodeStageVolume(ctx, &csi.NodeStageVolumeRequest{
+		VolumeId:          vol.ID,
+		StagingTargetPath: staging,
+		VolumeCapability:  mountCapability("ext4"),
+	}); err != nil {
+		t.Fatalf("NodeStageVolume of a volume created by the controller: %v", err)
+	}
+	if mp, ok := mounter.GetMountPoint(staging); !ok || mp.Source != vol.Path {
+		t.Errorf("unexpected staging mount point: %+v, %v", mp, ok)
+	}
+
+	// 两边交替修改索引
+	if err := nodeBackend.PublishVolume(vol.ID, "node-1"); err != nil {
+		t.Fatalf("PublishVolume: %v", err)
+	}
+	other, err := controllerBackend.CreateVolume("pvc-2", MiB, nil, nil)
+	if err != nil {
+		t.Fatalf("CreateVolume: %v", err)
+	}
+	if err := nodeBackend.UnpublishVolume(other.ID, "node-1"); err != nil {
+		t.Fatalf("UnpublishVolume: %v", err)
+	}
+	if got, err := controllerBackend.ListVolumes(); err != nil || len(got) != 2 {
+		t.Fatalf("expected both volumes in the shared index, got %d", len(got))
+	}
+	got, err := controllerBackend.GetVolumeByID(vol.ID)
+	if err != nil || len(got.PublishedNodes) != 1 || got.PublishedNodes[0] != "node-1" {
+		t.Errorf("expected publish from the node process to be kept, got %+v, %v", got, err)
+	}
+}
@@ -0,0 +1,125 @@
+package mycsi
+
+import (
+	"fmt"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/golang/glog"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// kubelet 在 inline ephemeral volume 的 VolumeContext 中设置的 key
+	ephemeralContextKey = "csi.storage.k8s.io/ephemeral"
+	// pod 中 volumeAttributes 指定的容量和文件系统类型
+	ephemeralSizeKey   = "size"
+	ephemeralFsTypeKey = "fsType"
+	// 后端存储中 inline ephemeral volume 名称的前缀
+	ephemeralVolumePrefix = "ephemeral-"
+)
+
+func isEphemeral(volumeContext map[string]string) bool {
+	return volumeContext[ephemeralContextKey] == "true"
+}
+
+// kubelet 为每个 inline volume 生成唯一的 volume id,后端存储中用它作为名称
+func ephemeralVolumeName(podVolumeID string) string {
+	return ephemeralVolumePrefix + podVolumeID
+}
+
+// 在后端存储中创建 inline ephemeral volume,格式化后直接挂载到 targetPath,不经过 stage
+func (ns *NodeServer) publishEphemeralVolume(req *csi.NodePublishVolumeRequest) error {
+	volumeID := req.GetVolumeId()
+	targetPath := req.GetTargetPath()
+	volumeContext := req.GetVolumeContext()
+
+	if req.GetVolumeCapability().GetBlock() != nil {
+		return status.Error(codes.InvalidArgument, "ephemeral volume 不支持 raw block")
+	}
+
+	mounted, err := ns.isMountPoint(targetPath)
+	if err != nil {
+		return status.Error(codes.Internal, fmt.Sprintf("检查 %s 是否挂载失败: %v", targetPath, err))
+	}
+	if mounted {
+		glog.V(4).Infof("NodePublishVolume: ephemeral volume %s 已经挂载到 %s", volumeID, targetPath)
+		return nil
+	}
+
+	// 加密需要 node-stage secret,inline ephemeral volume 不经过 stage,拿不到 passphrase
+	if _, ok := volumeContext[encryptedParameterKey]; ok {
+		return status.Error(codes.InvalidArgument, fmt.Sprintf("ephemeral volume 不支持 %s 参数", encryptedParameterKey))
+	}
+
+	size := DefaultVolumeSize
+	if v := volumeContext[ephemeralSizeKey]; v != "" {
+		if size, err = parseSize(v); err != nil {
+			return status.Error(codes.InvalidArgument, fmt.Sprintf("无效的 ephemeral volume 容量 %q: %v", v, err))
+		}
+	}
+	fsType := volumeContext[ephemeralFsTypeKey]
+	if fsType == "" {
+		fsType = req.GetVolumeCapability().GetMount().GetFsType()
+	}
+
+	// 同名 volume 已经存在时直接复用,kubelet 重试是幂等的
+	ephemeral := &EphemeralVolume{
+		PodVolumeID: volumeID,
+		NodeID:      ns.Driver.nodeID,
+		TargetPath:  targetPath,
+	}
+	vol, err := ns.Driver.backend.CreateEphemeralVolume(ephemeralVolumeName(volumeID), size, volumeContext, ephemeral)
+	if err == ErrVolumeExists {
+		return status.Error(codes.AlreadyExists, fmt.Sprintf("ephemeral volume %s 已存在并且容量不一致", volumeID))
+	}
+	if err != nil {
+		return status.Error(codes.Internal, fmt.Sprintf("创建 ephemeral volume %s 失败: %v", volumeID, err))
+	}
+
+	mountFlags := append([]string{}, req.GetVolumeCapability().GetMount().GetMountFlags()...)
+	if req.GetReadonly() {
+		mountFlags = append(mountFlags, "ro")
+	}
+	glog.V(4).Infof("NodePublishVolume: 挂载 ephemeral volume %s(%s) 到 %s", volumeID, vol.ID, targetPath)
+	return ns.formatAndMount(vol, vol.Path, targetPath, fsType, mountFlags)
+}
+
+// 删除 podVolumeID 对应的 inline ephemeral volume,不是 inline volume 时什么也不做
+func (ns *NodeServer) deleteEphemeralVolume(podVolumeID string) error {
+	vol, err := ns.Driver.backend.GetVolumeByName(ephemeralVolumeName(podVolumeID))
+	if err == ErrVolumeNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	glog.V(4).Infof("删除 ephemeral volume %s(%s)", podVolumeID, vol.ID)
+	return ns.Driver.backend.DeleteVolume(vol.ID)
+}
+
+// GarbageCollectEphemeralVolumes 删除本节点上已经没有挂载的 inline ephemeral volume
+// csi-node 重启期间 kubelet 可能已经删除了 pod,这些 volume 不会再收到 NodeUnpublishVolume
+func (ns *NodeServer) GarbageCollectEphemeralVolumes() error {
+	vols, err := ns.Driver.backend.ListVolumes()
+	if err != nil {
+		return fmt.Errorf("获取 volume 列表失败: %v", err)
+	}
+	for _, vol := range vols {
+		if vol.Ephemeral == nil || vol.Ephemeral.NodeID != ns.Driver.nodeID {
+			continue
+		}
+		mounted, err := ns.isMountPoint(vol.Ephemeral.TargetPath)
+		if err != nil {
+			return fmt.Errorf("检查 %s 是否挂载失败: %v", vol.Ephemeral.TargetPath, err)
+		}
+		if mounted {
+			continue
+		}
+		glog.Infof("回收孤儿 ephemeral volume %s(%s)", vol.Ephemeral.PodVolumeID, vol.ID)
+		if err := ns.Driver.backend.DeleteVolume(vol.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package mycsi
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestEphemeralVolumeLifecycle(t *testing.T) {
+	driver, mounter := newTestDriver(t)
+	ctx := context.Background()
+	target := filepath.Join(t.TempDir(), "target")
+	req := &csi.NodePublishVolumeRequest{
+		VolumeId:         "csi-inline-1",
+		TargetPath:       target,
+		VolumeCapability: mountCapability(""),
+		VolumeContext: map[string]string{
+			ephemeralContextKey: "true",
+			ephemeralSizeKey:    "16Mi",
+			ephemeralFsTypeKey:  "xfs",
+		},
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err := driver.ns.NodePublishVolume(ctx, req); err != nil {
+			t.Fatalf("NodePublishVolume #%d: %v", i, err)
+		}
+	}
+	vol, err := driver.backend.GetVolumeByName(ephemeralVolumeName("csi-inline-1"))
+	if err != nil {
+		t.Fatalf("expected an ephemeral volume in the backend: %v", err)
+	}
+	if vol.CapacityBytes != 16*MiB || vol.Ephemeral == nil || vol.Ephemeral.TargetPath != target {
+		t.Errorf("unexpected ephemeral volume: %+v", vol)
+	}
+	if mp, ok := mounter.GetMountPoint(target); !ok || mp.Source != vol.Path || mp.FsType != "xfs" {
+		t.Errorf("unexpected target mount point: %+v, %v", mp, ok)
+	}
+
+	if _, err := driver.ns.NodeUnpublishVolume(ctx, &csi.NodeUnpublishVolumeRequest{VolumeId: "csi-inline-1", TargetPath: target}); err != nil {
+		t.Fatalf("NodeUnpublishVolume: %v", err)
+	}
+	if _, err := driver.backend.GetVolumeByID(vol.ID); err != ErrVolumeNotFound {
+		t.Errorf("expected the ephemeral volume to be deleted, got %v", err)
+	}
+}
+
+func TestEphemeralVolumeRejectsEncryption(t *testing.T) {
+	driver, _ := newTestDriver(t)
+	_, err := driver.ns.NodePublishVolume(context.Background(), &csi.NodePublishVolumeRequest{
+		VolumeId:         "csi-inline-1",
+		TargetPath:       filepath.Join(t.TempDir(), "target"),
+		VolumeCapability: mountCapability("ext4"),
+		VolumeContext: map[string]string{
+			ephemeralContextKey:   "true",
+			encryptedParameterKey: "true",
+		},
+	})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("expected InvalidArgument for an encrypted ephemeral volume, got %v", err)
+	}
+	if _, err := driver.backend.GetVolumeByName(ephemeralVolumeName("csi-inline-1")); err != ErrVolumeNotFound {
+		t.Errorf("expected no volume to be created, got %v", err)
+	}
+}
+
+func TestGarbageCollectEphemeralVolumes(t *testing.T) {
+	driver, mounter := newTestDriver(t)
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	publish := func(id string) string {
+		target := filepath.Join(dir, id)
+		_, err := driver.ns.NodePublishVolume(ctx, &csi.NodePublishVolumeRequest{
+			VolumeId:         id,
+			TargetPath:       target,
+			VolumeCapability: mountCapability("ext4"),
+			VolumeContext:    map[string]string{ephemeralContextKey: "true", ephemeralSizeKey: "1Mi"},
+		})
+		if err != nil {
+			t.Fatalf("NodePublishVolume %s: %v", id, err)
+		}
+		return target
+	}
+	publish("csi-inline-live")
+	orphan := publish("csi-inline-orphan")
+	// 模拟 csi-node 重启期间 pod 被删除
+	mounter.Unmount(orphan)
+
+	if err := driver.ns.GarbageCollectEphemeralVolumes(); err != nil {
+		t.Fatalf("GarbageCollectEphemeralVolumes: %v", err)
+	}
+	if _, err := driver.backend.GetVolumeByName(ephemeralVolumeName("csi-inline-orphan")); err != ErrVolumeNotFound {
+		t.Errorf("expected the orphaned volume to be deleted, got %v", err)
+	}
+	if _, err := driver.backend.GetVolumeByName(ephemeralVolumeName("csi-inline-live")); err != nil {
+		t.Errorf("expected the mounted volume to be kept, got %v", err)
+	}
+}
@@ -0,0 +1,220 @@
+package mycsi
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+)
+
+// FakeMountPoint fake mounter 记录的一个挂载点
+type FakeMountPoint struct {
+	Source  string
+	Target  string
+	FsType  string
+	Options []string
+}
+
+// FakeMounter 只在内存中记录挂载和格式化,用于单元测试
+type FakeMounter struct {
+	mutex sync.Mutex
+	// target -> mount point
+	MountPoints map[string]FakeMountPoint
+	// device -> fsType
+	Formats map[string]string
+	// loop device -> image
+	LoopDevices map[string]string
+	// device -> LUKS passphrase
+	LuksDevices map[string]string
+	// mapper name -> device
+	LuksMappings map[string]string
+	// 执行过的操作,例如 "mount"、"umount"、"format"
+	Log []string
+	// 不为空时在 Mount 开始时调用,测试中用来模拟耗时的挂载
+	MountHook func(target string)
+}
+
+// NewFakeMounter 实例化一个 fake mounter
+func NewFakeMounter() *FakeMounter {
+	return &FakeMounter{
+		MountPoints:  map[string]FakeMountPoint{},
+		Formats:      map[string]string{},
+		LoopDevices:  map[string]string{},
+		LuksDevices:  map[string]string{},
+		LuksMappings: map[string]string{},
+	}
+}
+
+func (f *FakeMounter) Mount(source, target, fsType string, options []string) error {
+	if f.MountHook != nil {
+		f.MountHook(target)
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	target = filepath.Clean(target)
+	// 和真实的 mount 不同,重复挂载同一个 target 直接报错,测试中可以发现叠加的挂载
+	if _, ok := f.MountPoints[target]; ok {
+		return fmt.Errorf("%s 已经挂载", target)
+	}
+	f.MountPoints[target] = FakeMountPoint{
+		Source:  source,
+		Target:  target,
+		FsType:  fsType,
+		Options: append([]string(nil), options...),
+	}
+	f.Log = append(f.Log, "mount")
+	return nil
+}
+
+func (f *FakeMounter) Unmount(target string) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	delete(f.MountPoints, filepath.Clean(target))
+	f.Log = append(f.Log, "umount")
+	return nil
+}
+
+func (f *FakeMounter) IsMountPoint(path string) (bool, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	_, ok := f.MountPoints[filepath.Clean(path)]
+	return ok, nil
+}
+
+func (f *FakeMounter) GetDiskFormat(device string) (string, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	return f.Formats[device], nil
+}
+
+func (f *FakeMounter) Format(device, fsType string) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.Formats[device] = fsType
+	f.Log = append(f.Log, "format")
+	return nil
+}
+
+func (f *FakeMounter) GetMountDevice(path string) (string, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	mp, ok := f.MountPoints[filepath.Clean(path)]
+	if !ok {
+		return "", fmt.Errorf("%s is not mounted", path)
+	}
+	return mp.Source, nil
+}
+
+func (f *FakeMounter) ResizeFs(device, mountPath string) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.Log = append(f.Log, "resize")
+	return nil
+}
+
+func (f *FakeMounter) AttachLoopDevice(image string) (string, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	for i := 0; ; i++ {
+		device := fmt.Sprintf("/dev/loop%d", i)
+		if _, ok := f.LoopDevices[device]; !ok {
+			f.LoopDevices[device] = image
+			f.Log = append(f.Log, "attach")
+			return device, nil
+		}
+	}
+}
+
+func (f *FakeMounter) FindLoopDevice(image string) (string, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	for device, img := range f.LoopDevices {
+		if img == image {
+			return device, nil
+		}
+	}
+	return "", nil
+}
+
+func (f *FakeMounter) DetachLoopDevice(device string) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	delete(f.LoopDevices, device)
+	f.Log = append(f.Log, "detach")
+	return nil
+}
+
+func (f *FakeMounter) RefreshLoopDevice(device string) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.Log = append(f.Log, "refresh")
+	return nil
+}
+
+func (f *FakeMounter) LuksFormat(device, passphrase string) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.LuksDevices[device] = passphrase
+	f.Formats[device] = luksFsType
+	f.Log = append(f.Log, "luksFormat")
+	return nil
+}
+
+func (f *FakeMounter) LuksOpen(device, name, passphrase string) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.LuksDevices[device] != passphrase {
+		return ErrLuksPassphrase
+	}
+	if _, ok := f.LuksMappings[name]; ok {
+		return nil
+	}
+	f.LuksMappings[name] = device
+	f.Log = append(f.Log, "luksOpen")
+	return nil
+}
+
+func (f *FakeMounter) LuksClose(name string) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if _, ok := f.LuksMappings[name]; !ok {
+		return nil
+	}
+	delete(f.LuksMappings, name)
+	f.Log = append(f.Log, "luksClose")
+	return nil
+}
+
+func (f *FakeMounter) LuksResize(name, passphrase string) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if _, ok := f.LuksMappings[name]; !ok {
+		return fmt.Errorf("LUKS 映射 %s 不存在", name)
+	}
+	f.Log = append(f.Log, "luksResize")
+	return nil
+}
+
+// GetMountPoint 获取 target 上的挂载点
+func (f *FakeMounter) GetMountPoint(target string) (FakeMountPoint, bool) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	mp, ok := f.MountPoints[filepath.Clean(target)]
+	return mp, ok
+}
@@ -0,0 +1,557 @@
+package mycsi
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"syscall"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/golang/glog"
+)
+
+const (
+	// 持久化的 volume 索引文件
+	volumeIndexFile = "volumes.json"
+	// 读写索引时加的文件锁
+	volumeIndexLockFile = "volumes.lock"
+	// volume 镜像文件所在目录
+	volumeDir = "volumes"
+	// 快照镜像文件所在目录
+	snapshotDir = "snapshots"
+	// 没有指定容量时 volume 的默认大小
+	DefaultVolumeSize = 1 * GiB
+)
+
+// hostPathBackend 在 root 目录下为每个 volume 创建一个固定大小的镜像文件(稀疏文件)
+// 镜像文件的大小就是 volume 的容量上限,csi-node 通过 loop 设备挂载使用
+// --mode=controller 和 --mode=node 分开部署时两个进程共享同一个 root(同一个节点上的 hostPath),
+// 每次读写都在文件锁内从磁盘重新加载索引,内存中的 map 只是本次调用的缓存
+// 镜像文件需要在 csi-node 所在的节点上,root 不能在不同的节点之间共享
+type hostPathBackend struct {
+	root string
+
+	// 同一个进程内的互斥,进程之间由文件锁互斥
+	mutex sync.Mutex
+	// volume id -> volume
+	volumes map[string]*Volume
+	// snapshot id -> snapshot
+	snapshots map[string]*Snapshot
+}
+
+// volume 索引持久化的格式
+type volumeIndex struct {
+	Volumes   []*Volume   `json:"volumes"`
+	Snapshots []*Snapshot `json:"snapshots,omitempty"`
+}
+
+// NewHostPathBackend 实例化一个基于本地目录的 volume 后端,并加载已经持久化的 volume 索引
+func NewHostPathBackend(root string) (VolumeBackend, error) {
+	glog.V(3).Infof("mycsi: NewHostPathBackend. root: %s", root)
+	if root == "" {
+		return nil, fmt.Errorf("volume backend root missing")
+	}
+	for _, dir := range []string{volumeDir, snapshotDir} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0750); err != nil {
+			return nil, fmt.Errorf("创建 %s 目录失败: %v", dir, err)
+		}
+	}
+
+	b := &hostPathBackend{root: root}
+	unlock, err := b.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	glog.V(3).Infof("hostpath backend: 加载了 %d 个 volume, %d 个快照", len(b.volumes), len(b.snapshots))
+	return b, nil
+}
+
+// lock 加锁并从磁盘重新加载索引,调用方 defer 返回的函数释放锁
+// 另一个进程(controller 或者 node)可能已经修改了索引,不能使用上一次调用留在内存中的数据
+func (b *hostPathBackend) lock() (func(), error) {
+	b.mutex.Lock()
+	f, err := os.OpenFile(filepath.Join(b.root, volumeIndexLockFile), os.O_RDWR|os.O_CREATE, 0640)
+	if err != nil {
+		b.mutex.Unlock()
+		return nil, fmt.Errorf("打开 volume 索引锁失败: %v", err)
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		f.Close()
+		b.mutex.Unlock()
+		return nil, fmt.Errorf("锁定 volume 索引失败: %v", err)
+	}
+	unlock := func() {
+		syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+		f.Close()
+		b.mutex.Unlock()
+	}
+	if err := b.loadIndex(); err != nil {
+		unlock()
+		return nil, err
+	}
+	return unlock, nil
+}
+
+// 镜像文件通过 loop 设备挂载在一个节点上,只支持单节点的访问模式
+func (b *hostPathBackend) AccessModes() []csi.VolumeCapability_AccessMode_Mode {
+	return []csi.VolumeCapability_AccessMode_Mode{
+		csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+		csi.VolumeCapability_AccessMode_SINGLE_NODE_READER_ONLY,
+		csi.VolumeCapability_AccessMode_SINGLE_NODE_SINGLE_WRITER,
+		csi.VolumeCapability_AccessMode_SINGLE_NODE_MULTI_WRITER,
+	}
+}
+
+func (b *hostPathBackend) CreateVolume(name string, capacityBytes int64, parameters map[string]string, source *VolumeSource) (*Volume, error) {
+	unlock, err := b.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	return b.createVolume(name, capacityBytes, parameters, source, nil)
+}
+
+// 创建 volume 和记录 ephemeral 标记在同一次索引写入中完成,中途失败不会留下没有标记的 volume
+func (b *hostPathBackend) CreateEphemeralVolume(name string, capacityBytes int64, parameters map[string]string, ephemeral *EphemeralVolume) (*Volume, error) {
+	unlock, err := b.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	return b.createVolume(name, capacityBytes, parameters, nil, ephemeral)
+}
+
+// 调用方需要持有锁
+func (b *hostPathBackend) createVolume(name string, capacityBytes int64, parameters map[string]string, source *VolumeSource, ephemeral *EphemeralVolume) (*Volume, error) {
+	if capacityBytes <= 0 {
+		capacityBytes = DefaultVolumeSize
+	}
+
+	// 同名 volume 已经存在,幂等,扩容过的 volume 容量可能大于请求的容量
+	if vol := b.volumeByName(name); vol != nil {
+		if vol.CapacityBytes < capacityBytes || !sameVolumeSource(vol.Source, source) {
+			return nil, ErrVolumeExists
+		}
+		return copyVolume(vol), nil
+	}
+
+	// 数据源的镜像文件
+	var sourcePath string
+	if source != nil {
+		var sourceSize int64
+		switch {
+		case source.SnapshotID != "":
+			snap, ok := b.snapshots[source.SnapshotID]
+			if !ok {
+				return nil, ErrSnapshotNotFound
+			}
+			sourcePath, sourceSize = snap.Path, snap.SizeBytes
+		case source.VolumeID != "":
+			src, ok := b.volumes[source.VolumeID]
+			if !ok {
+				return nil, ErrVolumeNotFound
+			}
+			sourcePath, sourceSize = src.Path, src.CapacityBytes
+		}
+		if capacityBytes < sourceSize {
+			return nil, ErrVolumeTooSmall
+		}
+	}
+
+	id, err := b.newID()
+	if err != nil {
+		return nil, err
+	}
+	vol := &Volume{
+		ID:            id,
+		Name:          name,
+		CapacityBytes: capacityBytes,
+		Path:          filepath.Join(b.root, volumeDir, id+".img"),
+		Parameters:    parameters,
+	}
+	if source != nil {
+		s := *source
+		vol.Source = &s
+	}
+	if ephemeral != nil {
+		e := *ephemeral
+		vol.Ephemeral = &e
+	}
+	if sourcePath != "" {
+		err = copyImageFile(sourcePath, vol.Path, capacityBytes)
+	} else {
+		err = createImageFile(vol.Path, capacityBytes)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	b.volumes[id] = vol
+	if err := b.saveIndex(); err != nil {
+		delete(b.volumes, id)
+		os.Remove(vol.Path)
+		return nil, err
+	}
+	glog.V(4).Infof("hostpath backend: 创建 volume %s(%s), 容量 %d", id, name, capacityBytes)
+	return copyVolume(vol), nil
+}
+
+func (b *hostPathBackend) DeleteVolume(volumeID string) error {
+	unlock, err := b.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	vol, ok := b.volumes[volumeID]
+	if !ok {
+		return nil
+	}
+	if err := os.Remove(vol.Path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("删除 volume %s 的数据失败: %v", volumeID, err)
+	}
+
+	delete(b.volumes, volumeID)
+	if err := b.saveIndex(); err != nil {
+		return err
+	}
+	glog.V(4).Infof("hostpath backend: 删除 volume %s", volumeID)
+	return nil
+}
+
+func (b *hostPathBackend) GetVolumeByID(volumeID string) (*Volume, error) {
+	unlock, err := b.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	vol, ok := b.volumes[volumeID]
+	if !ok {
+		return nil, ErrVolumeNotFound
+	}
+	return copyVolume(vol), nil
+}
+
+func (b *hostPathBackend) GetVolumeByName(name string) (*Volume, error) {
+	unlock, err := b.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	vol := b.volumeByName(name)
+	if vol == nil {
+		return nil, ErrVolumeNotFound
+	}
+	return copyVolume(vol), nil
+}
+
+func (b *hostPathBackend) ListVolumes() ([]*Volume, error) {
+	unlock, err := b.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	vols := make([]*Volume, 0, len(b.volumes))
+	for _, vol := range b.volumes {
+		vols = append(vols, copyVolume(vol))
+	}
+	sort.Slice(vols, func(i, j int) bool { return vols[i].ID < vols[j].ID })
+	return vols, nil
+}
+
+// 镜像文件是稀疏文件,直接 truncate 到新的大小即可,文件系统由 csi-node 扩容
+func (b *hostPathBackend) ExpandVolume(volumeID string, capacityBytes int64) (*Volume, error) {
+	unlock, err := b.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	vol, ok := b.volumes[volumeID]
+	if !ok {
+		return nil, ErrVolumeNotFound
+	}
+	if capacityBytes < vol.CapacityBytes {
+		return nil, ErrVolumeShrink
+	}
+	if capacityBytes == vol.CapacityBytes {
+		return copyVolume(vol), nil
+	}
+
+	if err := os.Truncate(vol.Path, capacityBytes); err != nil {
+		return nil, fmt.Errorf("扩容镜像文件 %s 失败: %v", vol.Path, err)
+	}
+	old := vol.CapacityBytes
+	vol.CapacityBytes = capacityBytes
+	if err := b.saveIndex(); err != nil {
+		vol.CapacityBytes = old
+		return nil, err
+	}
+	glog.V(4).Infof("hostpath backend: volume %s 从 %d 扩容到 %d", volumeID, old, capacityBytes)
+	return copyVolume(vol), nil
+}
+
+func (b *hostPathBackend) PublishVolume(volumeID, nodeID string) error {
+	unlock, err := b.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	vol, ok := b.volumes[volumeID]
+	if !ok {
+		return ErrVolumeNotFound
+	}
+	for _, n := range vol.PublishedNodes {
+		if n == nodeID {
+			return nil
+		}
+	}
+
+	vol.PublishedNodes = append(vol.PublishedNodes, nodeID)
+	if err := b.saveIndex(); err != nil {
+		vol.PublishedNodes = vol.PublishedNodes[:len(vol.PublishedNodes)-1]
+		return err
+	}
+	return nil
+}
+
+func (b *hostPathBackend) UnpublishVolume(volumeID, nodeID string) error {
+	unlock, err := b.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	vol, ok := b.volumes[volumeID]
+	if !ok {
+		return nil
+	}
+	for i, n := range vol.PublishedNodes {
+		if n == nodeID {
+			old := vol.PublishedNodes
+			vol.PublishedNodes = append(append([]string{}, old[:i]...), old[i+1:]...)
+			if err := b.saveIndex(); err != nil {
+				vol.PublishedNodes = old
+				return err
+			}
+			return nil
+		}
+	}
+	return nil
+}
+
+// 镜像文件丢失或者大小和记录的容量不一致都认为 volume 不正常
+func (b *hostPathBackend) CheckVolume(vol *Volume) error {
+	fi, err := os.Stat(vol.Path)
+	if err != nil {
+		return fmt.Errorf("volume 镜像文件 %s 不可用: %v", vol.Path, err)
+	}
+	if fi.Size() < vol.CapacityBytes {
+		return fmt.Errorf("volume 镜像文件 %s 大小 %d 小于容量 %d", vol.Path, fi.Size(), vol.CapacityBytes)
+	}
+	return nil
+}
+
+// root 所在文件系统的剩余空间
+func (b *hostPathBackend) GetCapacity() (int64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(b.root, &st); err != nil {
+		return 0, fmt.Errorf("statfs %s 失败: %v", b.root, err)
+	}
+	return int64(st.Bavail) * int64(st.Bsize), nil
+}
+
+// 调用方需要持有锁
+func (b *hostPathBackend) volumeByName(name string) *Volume {
+	for _, vol := range b.volumes {
+		if vol.Name == name {
+			return vol
+		}
+	}
+	return nil
+}
+
+// 生成 volume 和快照之间都唯一的 id,调用方需要持有锁
+func (b *hostPathBackend) newID() (string, error) {
+	for {
+		buf := make([]byte, 16)
+		if _, err := rand.Read(buf); err != nil {
+			return "", fmt.Errorf("生成 id 失败: %v", err)
+		}
+		id := hex.EncodeToString(buf)
+		_, volExists := b.volumes[id]
+		_, snapExists := b.snapshots[id]
+		if !volExists && !snapExists {
+			return id, nil
+		}
+	}
+}
+
+func (b *hostPathBackend) indexPath() string {
+	return filepath.Join(b.root, volumeIndexFile)
+}
+
+// 调用方需要持有锁
+func (b *hostPathBackend) loadIndex() error {
+	b.volumes = map[string]*Volume{}
+	b.snapshots = map[string]*Snapshot{}
+	data, err := os.ReadFile(b.indexPath())
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("读取 volume 索引失败: %v", err)
+	}
+
+	var index volumeIndex
+	if err := json.Unmarshal(data, &index); err != nil {
+		return fmt.Errorf("解析 volume 索引失败: %v", err)
+	}
+	for _, vol := range index.Volumes {
+		b.volumes[vol.ID] = vol
+	}
+	for _, snap := range index.Snapshots {
+		b.snapshots[snap.ID] = snap
+	}
+	return nil
+}
+
+// 先写临时文件再 rename,保证索引文件不会写一半,调用方需要持有锁
+func (b *hostPathBackend) saveIndex() error {
+	index := volumeIndex{Volumes: make([]*Volume, 0, len(b.volumes))}
+	for _, vol := range b.volumes {
+		index.Volumes = append(index.Volumes, vol)
+	}
+	sort.Slice(index.Volumes, func(i, j int) bool { return index.Volumes[i].ID < index.Volumes[j].ID })
+	for _, snap := range b.snapshots {
+		index.Snapshots = append(index.Snapshots, snap)
+	}
+	sort.Slice(index.Snapshots, func(i, j int) bool { return index.Snapshots[i].ID < index.Snapshots[j].ID })
+
+	data, err := json.MarshalIndent(&index, "", "  ")
+	if err != nil {
+		return fmt.Errorf("序列化 volume 索引失败: %v", err)
+	}
+	tmp := b.indexPath() + ".tmp"
+	if err := os.WriteFile(tmp, data, 0640); err != nil {
+		return fmt.Errorf("写入 volume 索引失败: %v", err)
+	}
+	if err := os.Rename(tmp, b.indexPath()); err != nil {
+		return fmt.Errorf("写入 volume 索引失败: %v", err)
+	}
+	return nil
+}
+
+// 创建指定大小的稀疏镜像文件
+func createImageFile(path string, size int64) error {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0640)
+	if err != nil {
+		return fmt.Errorf("创建镜像文件 %s 失败: %v", path, err)
+	}
+	defer f.Close()
+	if err := f.Truncate(size); err != nil {
+		os.Remove(path)
+		return fmt.Errorf("设置镜像文件 %s 大小失败: %v", path, err)
+	}
+	return nil
+}
+
+// 拷贝镜像文件,跳过全零的块保持稀疏,最后把目标文件设置成 size 大小
+func copyImageFile(src, dst string, size int64) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("打开镜像文件 %s 失败: %v", src, err)
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0640)
+	if err != nil {
+		return fmt.Errorf("创建镜像文件 %s 失败: %v", dst, err)
+	}
+	if err := copySparse(in, out); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return fmt.Errorf("拷贝镜像文件 %s 到 %s 失败: %v", src, dst, err)
+	}
+	if err := out.Truncate(size); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return fmt.Errorf("设置镜像文件 %s 大小失败: %v", dst, err)
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(dst)
+		return fmt.Errorf("写入镜像文件 %s 失败: %v", dst, err)
+	}
+	return nil
+}
+
+func copySparse(in io.Reader, out *os.File) error {
+	buf := make([]byte, 64*KiB)
+	for {
+		n, err := io.ReadFull(in, buf)
+		if n > 0 {
+			if isZero(buf[:n]) {
+				if _, err := out.Seek(int64(n), io.SeekCurrent); err != nil {
+					return err
+				}
+			} else if _, err := out.Write(buf[:n]); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func isZero(buf []byte) bool {
+	for _, c := range buf {
+		if c != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func sameVolumeSource(a, b *VolumeSource) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+func copyVolume(vol *Volume) *Volume {
+	c := *vol
+	if vol.Source != nil {
+		s := *vol.Source
+		c.Source = &s
+	}
+	c.PublishedNodes = append([]string(nil), vol.PublishedNodes...)
+	if vol.Ephemeral != nil {
+		e := *vol.Ephemeral
+		c.Ephemeral = &e
+	}
+	if vol.Parameters != nil {
+		c.Parameters = make(map[string]string, len(vol.Parameters))
+		for k, v := range vol.Parameters {
+			c.Parameters[k] = v
+		}
+	}
+	return &c
+}
@@ -0,0 +1,167 @@
+package mycsi
+
+import (
+	"bytes"
+	"os"
+	"testing"
+)
+
+func TestHostPathBackendCreateVolumeIdempotent(t *testing.T) {
+	root := t.TempDir()
+	b, err := NewHostPathBackend(root)
+	if err != nil {
+		t.Fatalf("NewHostPathBackend: %v", err)
+	}
+
+	vol, err := b.CreateVolume("pvc-1", 4*MiB, nil, nil)
+	if err != nil {
+		t.Fatalf("CreateVolume: %v", err)
+	}
+	again, err := b.CreateVolume("pvc-1", 4*MiB, nil, nil)
+	if err != nil || again.ID != vol.ID {
+		t.Fatalf("expected idempotent CreateVolume to return %s, got %+v, %v", vol.ID, again, err)
+	}
+	if _, err := b.CreateVolume("pvc-1", 8*MiB, nil, nil); err != ErrVolumeExists {
+		t.Fatalf("expected ErrVolumeExists, got %v", err)
+	}
+	other, err := b.CreateVolume("pvc-2", 4*MiB, nil, nil)
+	if err != nil || other.ID == vol.ID {
+		t.Fatalf("expected a new unique volume, got %+v, %v", other, err)
+	}
+	if fi, err := os.Stat(vol.Path); err != nil || fi.Size() != 4*MiB {
+		t.Fatalf("expected image file of %d bytes, got %v, %v", 4*MiB, fi, err)
+	}
+
+	// 重新加载索引
+	reloaded, err := NewHostPathBackend(root)
+	if err != nil {
+		t.Fatalf("NewHostPathBackend: %v", err)
+	}
+	if got, err := reloaded.GetVolumeByName("pvc-1"); err != nil || got.ID != vol.ID {
+		t.Fatalf("expected persisted volume %s, got %+v, %v", vol.ID, got, err)
+	}
+
+	if err := reloaded.DeleteVolume(vol.ID); err != nil {
+		t.Fatalf("DeleteVolume: %v", err)
+	}
+	if err := reloaded.DeleteVolume(vol.ID); err != nil {
+		t.Fatalf("DeleteVolume of a deleted volume: %v", err)
+	}
+	if _, err := os.Stat(vol.Path); !os.IsNotExist(err) {
+		t.Fatalf("expected image file to be removed, got %v", err)
+	}
+}
+
+func TestHostPathBackendCreateEphemeralVolume(t *testing.T) {
+	root := t.TempDir()
+	b, err := NewHostPathBackend(root)
+	if err != nil {
+		t.Fatalf("NewHostPathBackend: %v", err)
+	}
+	ephemeral := &EphemeralVolume{PodVolumeID: "csi-inline-1", NodeID: "node-1", TargetPath: "/target"}
+	vol, err := b.CreateEphemeralVolume("ephemeral-csi-inline-1", 4*MiB, nil, ephemeral)
+	if err != nil {
+		t.Fatalf("CreateEphemeralVolume: %v", err)
+	}
+	again, err := b.CreateEphemeralVolume("ephemeral-csi-inline-1", 4*MiB, nil, ephemeral)
+	if err != nil || again.ID != vol.ID {
+		t.Fatalf("expected idempotent CreateEphemeralVolume to return %s, got %+v, %v", vol.ID, again, err)
+	}
+
+	// 标记和 volume 一起写入索引
+	reloaded, err := NewHostPathBackend(root)
+	if err != nil {
+		t.Fatalf("NewHostPathBackend: %v", err)
+	}
+	got, err := reloaded.GetVolumeByID(vol.ID)
+	if err != nil || got.Ephemeral == nil || *got.Ephemeral != *ephemeral {
+		t.Fatalf("expected persisted ephemeral marker %+v, got %+v, %v", ephemeral, got, err)
+	}
+}
+
+func TestHostPathBackendRestoreSnapshot(t *testing.T) {
+	b, err := NewHostPathBackend(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewHostPathBackend: %v", err)
+	}
+	vol, err := b.CreateVolume("pvc-1", 4*MiB, nil, nil)
+	if err != nil {
+		t.Fatalf("CreateVolume: %v", err)
+	}
+	data := []byte("hello snapshot")
+	f, err := os.OpenFile(vol.Path, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteAt(data, 2*MiB); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	snap, err := b.CreateSnapshot("snap-1", vol.ID)
+	if err != nil {
+		t.Fatalf("CreateSnapshot: %v", err)
+	}
+	if _, err := b.CreateSnapshot("snap-1", "other"); err != ErrSnapshotExists {
+		t.Fatalf("expected ErrSnapshotExists, got %v", err)
+	}
+
+	source := &VolumeSource{SnapshotID: snap.ID}
+	if _, err := b.CreateVolume("pvc-small", 2*MiB, nil, source); err != ErrVolumeTooSmall {
+		t.Fatalf("expected ErrVolumeTooSmall, got %v", err)
+	}
+	restored, err := b.CreateVolume("pvc-restored", 8*MiB, nil, source)
+	if err != nil {
+		t.Fatalf("CreateVolume from snapshot: %v", err)
+	}
+	content, err := os.ReadFile(restored.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if int64(len(content)) != 8*MiB || !bytes.Equal(content[2*MiB:2*MiB+int64(len(data))], data) {
+		t.Fatalf("restored volume does not contain snapshot data")
+	}
+
+	if err := b.DeleteSnapshot(snap.ID); err != nil {
+		t.Fatalf("DeleteSnapshot: %v", err)
+	}
+	if _, err := b.GetSnapshotByID(snap.ID); err != ErrSnapshotNotFound {
+		t.Fatalf("expected ErrSnapshotNotFound, got %v", err)
+	}
+}
+
+func TestHostPathBackendCloneVolume(t *testing.T) {
+	b, err := NewHostPathBackend(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewHostPathBackend: %v", err)
+	}
+	vol, err := b.CreateVolume("pvc-1", 4*MiB, nil, nil)
+	if err != nil {
+		t.Fatalf("CreateVolume: %v", err)
+	}
+	if err := os.WriteFile(vol.Path, []byte("hello clone"), 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	source := &VolumeSource{VolumeID: vol.ID}
+	if _, err := b.CreateVolume("pvc-small", 2*MiB, nil, source); err != ErrVolumeTooSmall {
+		t.Fatalf("expected ErrVolumeTooSmall, got %v", err)
+	}
+	if _, err := b.CreateVolume("pvc-missing", 4*MiB, nil, &VolumeSource{VolumeID: "missing"}); err != ErrVolumeNotFound {
+		t.Fatalf("expected ErrVolumeNotFound, got %v", err)
+	}
+	clone, err := b.CreateVolume("pvc-clone", 4*MiB, nil, source)
+	if err != nil {
+		t.Fatalf("CreateVolume from volume: %v", err)
+	}
+	content, err := os.ReadFile(clone.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(content, []byte("hello clone")) {
+		t.Fatalf("cloned volume does not contain source data")
+	}
+	if _, err := b.CreateVolume("pvc-clone", 4*MiB, nil, nil); err != ErrVolumeExists {
+		t.Fatalf("expected ErrVolumeExists for a different content source, got %v", err)
+	}
+}
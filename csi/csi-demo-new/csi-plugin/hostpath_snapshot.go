@@ -0,0 +1,118 @@
+package mycsi
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// 快照就是源 volume 镜像文件的一份稀疏拷贝,存放在 root/snapshots 目录下
+
+func (b *hostPathBackend) CreateSnapshot(name, sourceVolumeID string) (*Snapshot, error) {
+	unlock, err := b.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	// 同名快照已经存在,幂等
+	for _, snap := range b.snapshots {
+		if snap.Name == name {
+			if snap.SourceVolumeID != sourceVolumeID {
+				return nil, ErrSnapshotExists
+			}
+			return copySnapshot(snap), nil
+		}
+	}
+
+	vol, ok := b.volumes[sourceVolumeID]
+	if !ok {
+		return nil, ErrVolumeNotFound
+	}
+
+	id, err := b.newID()
+	if err != nil {
+		return nil, err
+	}
+	snap := &Snapshot{
+		ID:             id,
+		Name:           name,
+		SourceVolumeID: sourceVolumeID,
+		SizeBytes:      vol.CapacityBytes,
+		CreationTime:   time.Now(),
+		Path:           filepath.Join(b.root, snapshotDir, id+".img"),
+	}
+	if err := copyImageFile(vol.Path, snap.Path, snap.SizeBytes); err != nil {
+		return nil, err
+	}
+
+	b.snapshots[id] = snap
+	if err := b.saveIndex(); err != nil {
+		delete(b.snapshots, id)
+		os.Remove(snap.Path)
+		return nil, err
+	}
+	glog.V(4).Infof("hostpath backend: 为 volume %s 创建快照 %s(%s)", sourceVolumeID, id, name)
+	return copySnapshot(snap), nil
+}
+
+func (b *hostPathBackend) DeleteSnapshot(snapshotID string) error {
+	unlock, err := b.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	snap, ok := b.snapshots[snapshotID]
+	if !ok {
+		return nil
+	}
+	if err := os.Remove(snap.Path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("删除快照 %s 的数据失败: %v", snapshotID, err)
+	}
+
+	delete(b.snapshots, snapshotID)
+	if err := b.saveIndex(); err != nil {
+		return err
+	}
+	glog.V(4).Infof("hostpath backend: 删除快照 %s", snapshotID)
+	return nil
+}
+
+func (b *hostPathBackend) GetSnapshotByID(snapshotID string) (*Snapshot, error) {
+	unlock, err := b.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	snap, ok := b.snapshots[snapshotID]
+	if !ok {
+		return nil, ErrSnapshotNotFound
+	}
+	return copySnapshot(snap), nil
+}
+
+func (b *hostPathBackend) ListSnapshots() ([]*Snapshot, error) {
+	unlock, err := b.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	snaps := make([]*Snapshot, 0, len(b.snapshots))
+	for _, snap := range b.snapshots {
+		snaps = append(snaps, copySnapshot(snap))
+	}
+	sort.Slice(snaps, func(i, j int) bool { return snaps[i].ID < snaps[j].ID })
+	return snaps, nil
+}
+
+func copySnapshot(snap *Snapshot) *Snapshot {
+	c := *snap
+	return &c
+}
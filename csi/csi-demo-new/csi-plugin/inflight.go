@@ -0,0 +1,54 @@
+package mycsi
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/golang/glog"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// InFlight 记录正在进行中的操作,key 是 volume id、volume 名称或者挂载路径
+// 同一个 key 上已经有操作时直接返回 Aborted,由调用方(external-provisioner、kubelet)重试
+type InFlight struct {
+	mutex sync.Mutex
+	keys  map[string]struct{}
+}
+
+// NewInFlight 实例化一个 InFlight
+func NewInFlight() *InFlight {
+	return &InFlight{
+		keys: map[string]struct{}{},
+	}
+}
+
+// Acquire 占用所有 key,任意一个 key 已经被占用时不占用任何 key 并返回 Aborted
+// 请求已经取消或者超时时返回对应的错误,成功时返回释放 key 的函数
+func (f *InFlight) Acquire(ctx context.Context, keys ...string) (func(), error) {
+	if err := ctx.Err(); err != nil {
+		return nil, status.FromContextError(err).Err()
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	for _, key := range keys {
+		if _, ok := f.keys[key]; ok {
+			glog.V(4).Infof("操作 %s 正在进行中", key)
+			return nil, status.Error(codes.Aborted, fmt.Sprintf("an operation for %s is already in progress", key))
+		}
+	}
+	for _, key := range keys {
+		f.keys[key] = struct{}{}
+	}
+
+	return func() {
+		f.mutex.Lock()
+		defer f.mutex.Unlock()
+		for _, key := range keys {
+			delete(f.keys, key)
+		}
+	}, nil
+}
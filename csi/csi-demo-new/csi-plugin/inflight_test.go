@@ -0,0 +1,147 @@
+package mycsi
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestInFlightAcquire(t *testing.T) {
+	f := NewInFlight()
+	ctx := context.Background()
+
+	release, err := f.Acquire(ctx, "vol-1", "/target")
+	if err != nil {
+		t.Fatalf("Acquire: %v", err)
+	}
+	if _, err := f.Acquire(ctx, "vol-2", "/target"); status.Code(err) != codes.Aborted {
+		t.Fatalf("expected Aborted for a busy path, got %v", err)
+	}
+	// 失败时不能占用其他 key
+	if r, err := f.Acquire(ctx, "vol-2"); err != nil {
+		t.Fatalf("expected vol-2 to be free, got %v", err)
+	} else {
+		r()
+	}
+	release()
+	if r, err := f.Acquire(ctx, "vol-1", "/target"); err != nil {
+		t.Fatalf("expected keys to be released, got %v", err)
+	} else {
+		r()
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := f.Acquire(cancelled, "vol-1"); status.Code(err) != codes.Canceled {
+		t.Fatalf("expected Canceled, got %v", err)
+	}
+}
+
+func TestNodeStageVolumeConcurrent(t *testing.T) {
+	driver, mounter := newTestDriver(t)
+	ctx := context.Background()
+	vol, err := driver.backend.CreateVolume("pvc-1", 10*MiB, nil, nil)
+	if err != nil {
+		t.Fatalf("CreateVolume: %v", err)
+	}
+	staging := filepath.Join(t.TempDir(), "staging")
+	req := &csi.NodeStageVolumeRequest{
+		VolumeId:          vol.ID,
+		StagingTargetPath: staging,
+		VolumeCapability:  mountCapability("ext4"),
+	}
+
+	// 第一个 NodeStageVolume 阻塞在 Mount 中
+	entered := make(chan struct{})
+	unblock := make(chan struct{})
+	mounter.MountHook = func(string) {
+		close(entered)
+		<-unblock
+	}
+	done := make(chan error)
+	go func() {
+		_, err := driver.ns.NodeStageVolume(ctx, req)
+		done <- err
+	}()
+	<-entered
+
+	if _, err := driver.ns.NodeStageVolume(ctx, req); status.Code(err) != codes.Aborted {
+		t.Errorf("expected Aborted for a concurrent stage, got %v", err)
+	}
+	if _, err := driver.ns.NodeUnstageVolume(ctx, &csi.NodeUnstageVolumeRequest{VolumeId: vol.ID, StagingTargetPath: staging}); status.Code(err) != codes.Aborted {
+		t.Errorf("expected Aborted for a concurrent unstage, got %v", err)
+	}
+
+	close(unblock)
+	if err := <-done; err != nil {
+		t.Fatalf("NodeStageVolume: %v", err)
+	}
+	mounter.MountHook = nil
+	// 重试是幂等的
+	if _, err := driver.ns.NodeStageVolume(ctx, req); err != nil {
+		t.Fatalf("NodeStageVolume retry: %v", err)
+	}
+}
+
+func TestCreateVolumeConcurrent(t *testing.T) {
+	driver, _ := newTestDriver(t)
+	ctx := context.Background()
+	req := &csi.CreateVolumeRequest{
+		Name:               "pvc-1",
+		VolumeCapabilities: []*csi.VolumeCapability{mountCapability("ext4")},
+		CapacityRange:      &csi.CapacityRange{RequiredBytes: MiB},
+	}
+
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	ids := map[string]bool{}
+	errs := make(chan error, 20)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := driver.cs.CreateVolume(ctx, req)
+			if status.Code(err) == codes.Aborted {
+				return
+			}
+			if err != nil {
+				errs <- err
+				return
+			}
+			mutex.Lock()
+			ids[resp.Volume.VolumeId] = true
+			mutex.Unlock()
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Errorf("CreateVolume: %v", err)
+	}
+	if len(ids) != 1 {
+		t.Errorf("expected exactly one volume id, got %v", ids)
+	}
+	if vols, err := driver.backend.ListVolumes(); err != nil || len(vols) != 1 {
+		t.Errorf("expected one volume in the backend, got %d", len(vols))
+	}
+
+	// 不同的 volume 互不影响
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			r := *req
+			r.Name = fmt.Sprintf("pvc-other-%d", i)
+			if _, err := driver.cs.CreateVolume(ctx, &r); err != nil {
+				t.Errorf("CreateVolume %s: %v", r.Name, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,141 @@
+package mycsi
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+
+	"github.com/golang/glog"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// storageclass 参数,为 true 时 volume 在节点上通过 dm-crypt/LUKS 加密
+	encryptedParameterKey = "encrypted"
+	// node-stage secret 中 LUKS 密码的 key
+	encryptionPassphraseKey = "encryptionPassphrase"
+	// blkid 识别出的 LUKS 设备类型
+	luksFsType = "crypto_LUKS"
+	// /dev/mapper 下映射设备的名称前缀
+	luksMapperPrefix = "luks-"
+)
+
+// ErrLuksPassphrase 密码和 LUKS 设备不匹配
+var ErrLuksPassphrase = errors.New("LUKS 密码不匹配")
+
+// 解析 storageclass 参数中的 encrypted,没有设置时不加密
+func isEncrypted(parameters map[string]string) (bool, error) {
+	value, ok := parameters[encryptedParameterKey]
+	if !ok || value == "" {
+		return false, nil
+	}
+	encrypted, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("参数 %s=%q 无效, 必须是 true 或者 false", encryptedParameterKey, value)
+	}
+	return encrypted, nil
+}
+
+func luksMapperName(volumeID string) string {
+	return luksMapperPrefix + volumeID
+}
+
+func luksMapperPath(name string) string {
+	return filepath.Join("/dev/mapper", name)
+}
+
+// 打开加密 volume 的 LUKS 映射,返回映射后的设备,第一次使用时先创建 LUKS 加密头
+func (ns *NodeServer) openEncryptedVolume(vol *Volume, secrets map[string]string) (string, error) {
+	passphrase := secrets[encryptionPassphraseKey]
+	if passphrase == "" {
+		return "", status.Error(codes.InvalidArgument, fmt.Sprintf("volume %s 是加密的, node-stage secret 中缺少 %s", vol.ID, encryptionPassphraseKey))
+	}
+
+	format, err := ns.mounter.GetDiskFormat(vol.Path)
+	if err != nil {
+		return "", status.Error(codes.Internal, err.Error())
+	}
+	switch format {
+	case "":
+		glog.V(4).Infof("volume %s 创建 LUKS 加密头", vol.ID)
+		if err := ns.mounter.LuksFormat(vol.Path, passphrase); err != nil {
+			return "", status.Error(codes.Internal, err.Error())
+		}
+	case luksFsType:
+	default:
+		return "", status.Error(codes.FailedPrecondition, fmt.Sprintf("volume %s 已经格式化成 %s, 不是 LUKS 设备", vol.ID, format))
+	}
+
+	name := luksMapperName(vol.ID)
+	err = ns.mounter.LuksOpen(vol.Path, name, passphrase)
+	if err == ErrLuksPassphrase {
+		return "", status.Error(codes.InvalidArgument, fmt.Sprintf("node-stage secret 中的 %s 与 volume %s 不匹配", encryptionPassphraseKey, vol.ID))
+	}
+	if err != nil {
+		return "", status.Error(codes.Internal, err.Error())
+	}
+	return luksMapperPath(name), nil
+}
+
+// 密码通过 stdin 传给 cryptsetup,不出现在进程参数中
+func (m *execMounter) LuksFormat(device, passphrase string) error {
+	args := []string{"-q", "luksFormat", "--type", "luks2", "--key-file", "-", device}
+	if out, err := executeCmdWithInput("cryptsetup", args, passphrase); err != nil {
+		return fmt.Errorf("cryptsetup luksFormat %s 失败: %v, output: %s", device, err, string(out))
+	}
+	return nil
+}
+
+// 镜像文件由 cryptsetup 自动关联 loop 设备,关闭映射时自动解除
+func (m *execMounter) LuksOpen(device, name, passphrase string) error {
+	args := []string{"luksOpen", "--key-file", "-", device, name}
+	if _, err := os.Stat(luksMapperPath(name)); err == nil {
+		args = []string{"luksOpen", "--test-passphrase", "--key-file", "-", device}
+	}
+	out, err := executeCmdWithInput("cryptsetup", args, passphrase)
+	// cryptsetup 密码错误时退出码为 2
+	if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() == 2 {
+		return ErrLuksPassphrase
+	}
+	if err != nil {
+		return fmt.Errorf("cryptsetup luksOpen %s 失败: %v, output: %s", device, err, string(out))
+	}
+	return nil
+}
+
+func (m *execMounter) LuksClose(name string) error {
+	if _, err := os.Stat(luksMapperPath(name)); os.IsNotExist(err) {
+		return nil
+	}
+	if out, err := executeCmd("cryptsetup", []string{"luksClose", name}); err != nil {
+		return fmt.Errorf("cryptsetup luksClose %s 失败: %v, output: %s", name, err, string(out))
+	}
+	return nil
+}
+
+// 加密 volume 扩容时依次刷新 cryptsetup 关联的 loop 设备和 LUKS 映射的大小,之后才能扩容文件系统
+func (ns *NodeServer) resizeEncryptedVolume(vol *Volume, secrets map[string]string) error {
+	if err := ns.refreshBlockVolume(vol); err != nil {
+		return err
+	}
+	if err := ns.mounter.LuksResize(luksMapperName(vol.ID), secrets[encryptionPassphraseKey]); err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	return nil
+}
+
+// LUKS2 的密钥保存在内核 keyring 中时 resize 需要密码,通过 node-expand secret 提供
+func (m *execMounter) LuksResize(name, passphrase string) error {
+	args := []string{"resize", name}
+	if passphrase != "" {
+		args = []string{"resize", "--key-file", "-", name}
+	}
+	if out, err := executeCmdWithInput("cryptsetup", args, passphrase); err != nil {
+		return fmt.Errorf("cryptsetup resize %s 失败: %v, output: %s", name, err, string(out))
+	}
+	return nil
+}
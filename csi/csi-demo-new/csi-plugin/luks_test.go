@@ -0,0 +1,139 @@
+package mycsi
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestNodeStageEncryptedVolume(t *testing.T) {
+	driver, mounter := newTestDriver(t)
+	ctx := context.Background()
+	vol, err := driver.backend.CreateVolume("pvc-1", 10*MiB, map[string]string{encryptedParameterKey: "true"}, nil)
+	if err != nil {
+		t.Fatalf("CreateVolume: %v", err)
+	}
+	staging := filepath.Join(t.TempDir(), "staging")
+	req := &csi.NodeStageVolumeRequest{
+		VolumeId:          vol.ID,
+		StagingTargetPath: staging,
+		VolumeCapability:  mountCapability("ext4"),
+	}
+
+	if _, err := driver.ns.NodeStageVolume(ctx, req); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("expected InvalidArgument for missing secret, got %v", err)
+	}
+
+	req.Secrets = map[string]string{encryptionPassphraseKey: "secret"}
+	if _, err := driver.ns.NodeStageVolume(ctx, req); err != nil {
+		t.Fatalf("NodeStageVolume: %v", err)
+	}
+	device := luksMapperPath(luksMapperName(vol.ID))
+	if mounter.Formats[vol.Path] != luksFsType || mounter.Formats[device] != "ext4" {
+		t.Errorf("expected LUKS image with ext4 on %s, got %v", device, mounter.Formats)
+	}
+	mp, ok := mounter.GetMountPoint(staging)
+	if !ok || mp.Source != device || len(mp.Options) != 0 {
+		t.Errorf("unexpected staging mount point: %+v, %v", mp, ok)
+	}
+
+	if _, err := driver.ns.NodeUnstageVolume(ctx, &csi.NodeUnstageVolumeRequest{VolumeId: vol.ID, StagingTargetPath: staging}); err != nil {
+		t.Fatalf("NodeUnstageVolume: %v", err)
+	}
+	if len(mounter.LuksMappings) != 0 {
+		t.Errorf("expected LUKS mapping to be closed, got %v", mounter.LuksMappings)
+	}
+
+	// 已有的加密头不会重新创建,密码不一致时报错
+	req.Secrets = map[string]string{encryptionPassphraseKey: "wrong"}
+	if _, err := driver.ns.NodeStageVolume(ctx, req); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("expected InvalidArgument for mismatched secret, got %v", err)
+	}
+	want := []string{"luksFormat", "luksOpen", "format", "mount", "umount", "luksClose"}
+	if len(mounter.Log) != len(want) {
+		t.Fatalf("expected operations %v, got %v", want, mounter.Log)
+	}
+	for i := range want {
+		if mounter.Log[i] != want[i] {
+			t.Fatalf("expected operations %v, got %v", want, mounter.Log)
+		}
+	}
+}
+
+func TestCreateEncryptedVolumeParameters(t *testing.T) {
+	driver, _ := newTestDriver(t)
+	ctx := context.Background()
+	req := &csi.CreateVolumeRequest{
+		Name:               "pvc-1",
+		VolumeCapabilities: []*csi.VolumeCapability{mountCapability("ext4")},
+		Parameters:         map[string]string{encryptedParameterKey: "maybe"},
+	}
+	if _, err := driver.cs.CreateVolume(ctx, req); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("expected InvalidArgument for an invalid encrypted parameter, got %v", err)
+	}
+
+	req.Parameters[encryptedParameterKey] = "true"
+	req.VolumeCapabilities = []*csi.VolumeCapability{blockCapability()}
+	if _, err := driver.cs.CreateVolume(ctx, req); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("expected InvalidArgument for an encrypted block volume, got %v", err)
+	}
+
+	req.VolumeCapabilities = []*csi.VolumeCapability{mountCapability("ext4")}
+	resp, err := driver.cs.CreateVolume(ctx, req)
+	if err != nil {
+		t.Fatalf("CreateVolume: %v", err)
+	}
+	if resp.Volume.VolumeContext[encryptedParameterKey] != "true" {
+		t.Errorf("expected encrypted parameter in volume context, got %v", resp.Volume.VolumeContext)
+	}
+}
+
+func TestNodeExpandEncryptedVolume(t *testing.T) {
+	driver, mounter := newTestDriver(t)
+	ctx := context.Background()
+	vol, err := driver.backend.CreateVolume("pvc-1", 10*MiB, map[string]string{encryptedParameterKey: "true"}, nil)
+	if err != nil {
+		t.Fatalf("CreateVolume: %v", err)
+	}
+	staging := filepath.Join(t.TempDir(), "staging")
+	secrets := map[string]string{encryptionPassphraseKey: "secret"}
+	if _, err := driver.ns.NodeStageVolume(ctx, &csi.NodeStageVolumeRequest{
+		VolumeId:          vol.ID,
+		StagingTargetPath: staging,
+		VolumeCapability:  mountCapability("ext4"),
+		Secrets:           secrets,
+	}); err != nil {
+		t.Fatalf("NodeStageVolume: %v", err)
+	}
+
+	req := &csi.NodeExpandVolumeRequest{
+		VolumeId:          vol.ID,
+		VolumePath:        filepath.Join(t.TempDir(), "target"),
+		StagingTargetPath: staging,
+		CapacityRange:     &csi.CapacityRange{RequiredBytes: 10 * MiB},
+		Secrets:           secrets,
+	}
+	if _, err := driver.ns.NodeExpandVolume(ctx, req); status.Code(err) != codes.NotFound {
+		t.Fatalf("expected NotFound without a loop device, got %v", err)
+	}
+
+	// cryptsetup 打开镜像文件时自动关联的 loop 设备
+	mounter.LoopDevices["/dev/loop0"] = vol.Path
+	mounter.Log = nil
+	if _, err := driver.ns.NodeExpandVolume(ctx, req); err != nil {
+		t.Fatalf("NodeExpandVolume: %v", err)
+	}
+	want := []string{"refresh", "luksResize", "resize"}
+	if len(mounter.Log) != len(want) {
+		t.Fatalf("expected operations %v, got %v", want, mounter.Log)
+	}
+	for i := range want {
+		if mounter.Log[i] != want[i] {
+			t.Fatalf("expected operations %v, got %v", want, mounter.Log)
+		}
+	}
+}
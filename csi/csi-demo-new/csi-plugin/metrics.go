@@ -0,0 +1,79 @@
+package mycsi
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+const metricsNamespace = "csi"
+
+// grpcMetrics 统计每个 grpc 方法的耗时、错误数以及正在处理的请求数
+type grpcMetrics struct {
+	registry *prometheus.Registry
+	latency  *prometheus.HistogramVec
+	errors   *prometheus.CounterVec
+	inFlight *prometheus.GaugeVec
+}
+
+// 实例化 grpc metrics,driver 名称作为固定的 label
+func newGRPCMetrics(driverName string) *grpcMetrics {
+	labels := prometheus.Labels{"driver_name": driverName}
+	m := &grpcMetrics{
+		registry: prometheus.NewRegistry(),
+		latency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace:   metricsNamespace,
+			Name:        "grpc_request_duration_seconds",
+			Help:        "grpc 请求的耗时",
+			ConstLabels: labels,
+			// 挂载、格式化等操作可能需要几十秒
+			Buckets: []float64{.01, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60, 120},
+		}, []string{"method"}),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   metricsNamespace,
+			Name:        "grpc_errors_total",
+			Help:        "按 grpc 错误码统计的失败请求数",
+			ConstLabels: labels,
+		}, []string{"method", "code"}),
+		inFlight: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace:   metricsNamespace,
+			Name:        "grpc_requests_in_flight",
+			Help:        "正在处理的 grpc 请求数",
+			ConstLabels: labels,
+		}, []string{"method"}),
+	}
+	m.registry.MustRegister(
+		m.latency,
+		m.errors,
+		m.inFlight,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return m
+}
+
+// 统计 metrics 的拦截器,和 logGRPC 一起注册
+func (m *grpcMetrics) interceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	inFlight := m.inFlight.WithLabelValues(info.FullMethod)
+	inFlight.Inc()
+	defer inFlight.Dec()
+
+	start := time.Now()
+	resp, err := handler(ctx, req)
+	m.latency.WithLabelValues(info.FullMethod).Observe(time.Since(start).Seconds())
+	if err != nil {
+		m.errors.WithLabelValues(info.FullMethod, status.Code(err).String()).Inc()
+	}
+	return resp, err
+}
+
+// metrics 的 http handler
+func (m *grpcMetrics) handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
@@ -0,0 +1,43 @@
+package mycsi
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestGRPCMetricsInterceptor(t *testing.T) {
+	m := newGRPCMetrics("test.csi.driver")
+	info := &grpc.UnaryServerInfo{FullMethod: "/csi.v1.Node/NodeStageVolume"}
+
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		if got := testutil.ToFloat64(m.inFlight.WithLabelValues(info.FullMethod)); got != 1 {
+			t.Errorf("expected 1 request in flight, got %v", got)
+		}
+		return nil, status.Error(codes.NotFound, "missing")
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := m.interceptor(context.Background(), nil, info, handler); status.Code(err) != codes.NotFound {
+			t.Fatalf("expected handler error to be returned, got %v", err)
+		}
+	}
+	if _, err := m.interceptor(context.Background(), nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return nil, nil
+	}); err != nil {
+		t.Fatalf("interceptor: %v", err)
+	}
+
+	if got := testutil.ToFloat64(m.errors.WithLabelValues(info.FullMethod, codes.NotFound.String())); got != 2 {
+		t.Errorf("expected 2 NotFound errors, got %v", got)
+	}
+	if got := testutil.ToFloat64(m.inFlight.WithLabelValues(info.FullMethod)); got != 0 {
+		t.Errorf("expected no requests in flight, got %v", got)
+	}
+	if got := testutil.CollectAndCount(m.latency); got != 1 {
+		t.Errorf("expected one latency histogram, got %d", got)
+	}
+}
@@ -0,0 +1,241 @@
+package mycsi
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/golang/glog"
+)
+
+// Mounter csi-node 对文件系统的操作,方便单元测试时替换成 fake
+type Mounter interface {
+	// 挂载 source 到 target,fsType 为空时由 mount 自行判断
+	Mount(source, target, fsType string, options []string) error
+	// 卸载 target
+	Unmount(target string) error
+	// path 是否是一个挂载点
+	IsMountPoint(path string) (bool, error)
+	// 获取设备或者镜像文件上的文件系统类型,没有格式化返回空字符串
+	GetDiskFormat(device string) (string, error)
+	// 将设备或者镜像文件格式化成 fsType 文件系统
+	Format(device, fsType string) error
+	// 获取挂载点 path 对应的设备
+	GetMountDevice(path string) (string, error)
+	// 在线扩容 device 上挂载在 mountPath 的文件系统
+	ResizeFs(device, mountPath string) error
+	// 为镜像文件关联一个 loop 设备,返回设备路径
+	AttachLoopDevice(image string) (string, error)
+	// 查找镜像文件关联的 loop 设备,没有关联返回空字符串
+	FindLoopDevice(image string) (string, error)
+	// 解除 loop 设备的关联
+	DetachLoopDevice(device string) error
+	// 镜像文件扩容后刷新 loop 设备的大小
+	RefreshLoopDevice(device string) error
+	// 在设备或者镜像文件上创建 LUKS 加密头
+	LuksFormat(device, passphrase string) error
+	// 打开 LUKS 设备映射到 /dev/mapper/name,映射已经存在时只校验密码,密码错误返回 ErrLuksPassphrase
+	LuksOpen(device, name, passphrase string) error
+	// 关闭 /dev/mapper/name 映射,映射不存在时什么也不做
+	LuksClose(name string) error
+	// 底层设备扩容后把 /dev/mapper/name 映射扩展到设备的大小
+	LuksResize(name, passphrase string) error
+}
+
+// NewMounter 实例化一个通过执行系统命令操作文件系统的 Mounter
+func NewMounter() Mounter {
+	return &execMounter{}
+}
+
+type execMounter struct{}
+
+func (m *execMounter) Mount(source, target, fsType string, options []string) error {
+	args := []string{}
+	if fsType != "" {
+		args = append(args, "-t", fsType)
+	}
+	if len(options) > 0 {
+		args = append(args, "-o", strings.Join(options, ","))
+	}
+	args = append(args, source, target)
+	glog.V(4).Infof("mount %v", args)
+	if out, err := executeCmd("mount", args); err != nil {
+		return fmt.Errorf("mount %s 到 %s 失败: %v, output: %s", source, target, err, string(out))
+	}
+	return nil
+}
+
+func (m *execMounter) Unmount(target string) error {
+	glog.V(4).Infof("umount %s", target)
+	if out, err := executeCmd("umount", []string{target}); err != nil {
+		return fmt.Errorf("umount %s 失败: %v, output: %s", target, err, string(out))
+	}
+	return nil
+}
+
+// 通过 /proc/self/mountinfo 判断,bind mount 也能识别
+func (m *execMounter) IsMountPoint(path string) (bool, error) {
+	_, found, err := findMountInfo(path)
+	return found, err
+}
+
+func (m *execMounter) GetMountDevice(path string) (string, error) {
+	device, found, err := findMountInfo(path)
+	if err != nil {
+		return "", err
+	}
+	if !found {
+		return "", fmt.Errorf("%s 没有挂载", path)
+	}
+	return device, nil
+}
+
+// loop 设备需要先刷新大小,然后根据文件系统类型扩容
+func (m *execMounter) ResizeFs(device, mountPath string) error {
+	if strings.HasPrefix(device, "/dev/loop") {
+		if err := m.RefreshLoopDevice(device); err != nil {
+			return err
+		}
+	}
+
+	fsType, err := m.GetDiskFormat(device)
+	if err != nil {
+		return err
+	}
+	var cmd string
+	var args []string
+	switch fsType {
+	case "ext2", "ext3", "ext4":
+		cmd, args = "resize2fs", []string{device}
+	case "xfs":
+		cmd, args = "xfs_growfs", []string{mountPath}
+	default:
+		return fmt.Errorf("不支持扩容 %s 上的 %q 文件系统", device, fsType)
+	}
+	glog.V(4).Infof("%s %v", cmd, args)
+	if out, err := executeCmd(cmd, args); err != nil {
+		return fmt.Errorf("%s %s 失败: %v, output: %s", cmd, device, err, string(out))
+	}
+	return nil
+}
+
+func (m *execMounter) AttachLoopDevice(image string) (string, error) {
+	out, err := executeCmd("losetup", []string{"-f", "--show", image})
+	if err != nil {
+		return "", fmt.Errorf("losetup -f --show %s 失败: %v, output: %s", image, err, string(out))
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// losetup -j 的输出形如 "/dev/loop0: [2049]:1234 (/path/to/image)"
+func (m *execMounter) FindLoopDevice(image string) (string, error) {
+	out, err := executeCmd("losetup", []string{"-j", image})
+	if err != nil {
+		return "", fmt.Errorf("losetup -j %s 失败: %v, output: %s", image, err, string(out))
+	}
+	line := strings.TrimSpace(string(out))
+	if line == "" {
+		return "", nil
+	}
+	if i := strings.Index(line, ":"); i > 0 {
+		return line[:i], nil
+	}
+	return "", fmt.Errorf("无法解析 losetup 的输出: %s", line)
+}
+
+func (m *execMounter) DetachLoopDevice(device string) error {
+	if out, err := executeCmd("losetup", []string{"-d", device}); err != nil {
+		return fmt.Errorf("losetup -d %s 失败: %v, output: %s", device, err, string(out))
+	}
+	return nil
+}
+
+func (m *execMounter) RefreshLoopDevice(device string) error {
+	if out, err := executeCmd("losetup", []string{"-c", device}); err != nil {
+		return fmt.Errorf("losetup -c %s 失败: %v, output: %s", device, err, string(out))
+	}
+	return nil
+}
+
+// 在 /proc/self/mountinfo 中查找挂载点 path,返回挂载的设备
+func findMountInfo(path string) (string, bool, error) {
+	path, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", false, err
+	}
+
+	f, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return "", false, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	device, found := "", false
+	for scanner.Scan() {
+		// 第 5 个字段是挂载点, " - " 之后依次是文件系统类型和设备
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 5 || unescapeMountPath(fields[4]) != path {
+			continue
+		}
+		found = true
+		for i := 5; i+2 < len(fields); i++ {
+			if fields[i] == "-" {
+				device = unescapeMountPath(fields[i+2])
+				break
+			}
+		}
+		// 同一个挂载点挂载多次时以最后一次为准
+	}
+	return device, found, scanner.Err()
+}
+
+func (m *execMounter) GetDiskFormat(device string) (string, error) {
+	out, err := executeCmd("blkid", []string{"-p", "-s", "TYPE", "-o", "value", device})
+	if err != nil {
+		// blkid 找不到文件系统时退出码为 2
+		if len(out) == 0 {
+			return "", nil
+		}
+		return "", fmt.Errorf("blkid %s 失败: %v", device, err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+func (m *execMounter) Format(device, fsType string) error {
+	args := []string{}
+	switch fsType {
+	case "ext2", "ext3", "ext4":
+		args = append(args, "-F", "-m0")
+	case "xfs":
+		args = append(args, "-f")
+	}
+	args = append(args, device)
+	glog.V(4).Infof("mkfs.%s %v", fsType, args)
+	if out, err := executeCmd("mkfs."+fsType, args); err != nil {
+		return fmt.Errorf("mkfs.%s %s 失败: %v, output: %s", fsType, device, err, string(out))
+	}
+	return nil
+}
+
+// mountinfo 中的空格等字符是八进制转义的
+func unescapeMountPath(path string) string {
+	if !strings.Contains(path, `\`) {
+		return path
+	}
+	var b strings.Builder
+	for i := 0; i < len(path); i++ {
+		if path[i] == '\\' && i+3 < len(path) {
+			if c, err := strconv.ParseUint(path[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(c))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(path[i])
+	}
+	return b.String()
+}
@@ -5,6 +5,7 @@ import (
 	"net/url"
 	"os"
 	"sync"
+	"time"
 
 	"github.com/golang/glog"
 	"google.golang.org/grpc"
@@ -23,25 +24,30 @@ type NonBlockingGRPCServer interface {
 	Stop()
 	// 强制关闭
 	ForceStop()
+	// 优雅关闭,等待正在处理的调用结束,超过 timeout 强制关闭,最后删除 unix socket
+	Shutdown(timeout time.Duration)
 }
 
-// 实例化一个 grpc server interface
-func NewNonBlockingGRPCServer() NonBlockingGRPCServer {
-	return &nonBlockingGRPCServer{} // 结构体(指针) 该结构实现接口
+// 实例化一个 grpc server interface,interceptors 在 logGRPC 之后依次调用
+func NewNonBlockingGRPCServer(interceptors ...grpc.UnaryServerInterceptor) NonBlockingGRPCServer {
+	return &nonBlockingGRPCServer{interceptors: interceptors} // 结构体(指针) 该结构实现接口
 }
 
 // 非阻塞的 server 对象结构体定义
 type nonBlockingGRPCServer struct {
-	wg     sync.WaitGroup
-	server *grpc.Server
+	wg           sync.WaitGroup
+	server       *grpc.Server
+	interceptors []grpc.UnaryServerInterceptor
+	// unix socket 的路径,tcp 时为空
+	socket string
 }
 
+// 监听和注册服务是同步的,Start 返回后就可以调用 Stop,只有 serve 在后台运行
 func (s *nonBlockingGRPCServer) Start(endpoint string, ids csi.IdentityServer, cs csi.ControllerServer, ns csi.NodeServer) {
-	s.wg.Add(1)
-
-	go s.serve(endpoint, ids, cs, ns)
+	listener := s.listen(endpoint, ids, cs, ns)
 
-	return
+	s.wg.Add(1)
+	go s.serve(listener)
 }
 
 func (s *nonBlockingGRPCServer) Wait() {
@@ -57,12 +63,38 @@ func (s *nonBlockingGRPCServer) ForceStop() {
 	s.server.Stop()
 }
 
-// serve 提供服务, gin 等等的server也是这么设计
-func (s *nonBlockingGRPCServer) serve(endpoint string, ids csi.IdentityServer, cs csi.ControllerServer, ns csi.NodeServer) {
+func (s *nonBlockingGRPCServer) Shutdown(timeout time.Duration) {
+	// serve 在停止监听时就返回了,Wait 还需要等待正在处理的调用结束
+	s.wg.Add(1)
+	defer s.wg.Done()
+
+	done := make(chan struct{})
+	go func() {
+		s.Stop()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		glog.Warningf("%v 内没有处理完所有的调用, 强制关闭", timeout)
+		s.ForceStop()
+		<-done
+	}
+
+	if s.socket != "" {
+		if err := os.Remove(s.socket); err != nil && !os.IsNotExist(err) {
+			glog.Errorf("删除 %s 失败: %v", s.socket, err)
+		}
+	}
+	glog.Infof("grpc server 已关闭")
+}
+
+// listen 建立监听并注册服务
+func (s *nonBlockingGRPCServer) listen(endpoint string, ids csi.IdentityServer, cs csi.ControllerServer, ns csi.NodeServer) net.Listener {
 	// 配置选项
 	opts := []grpc.ServerOption{
 		// 统一拦截器
-		grpc.UnaryInterceptor(logGRPC),
+		grpc.ChainUnaryInterceptor(append([]grpc.UnaryServerInterceptor{logGRPC}, s.interceptors...)...),
 	}
 	// 解析成 url 结构,有无主机名都可以,主要是路径解析
 	// 注意解析不明确,不一定返回错误
@@ -79,6 +111,7 @@ func (s *nonBlockingGRPCServer) serve(endpoint string, ids csi.IdentityServer, c
 		if err := os.Remove(addr); err != nil && !os.IsNotExist(err) {
 			glog.Fatalf("删除 %s 失败, error: %s", addr, err.Error())
 		}
+		s.socket = addr
 		// tcp 即可
 	} else if u.Scheme == "tcp" {
 		addr = u.Host
@@ -108,10 +141,17 @@ func (s *nonBlockingGRPCServer) serve(endpoint string, ids csi.IdentityServer, c
 	if ns != nil {
 		csi.RegisterNodeServer(server, ns)
 	}
+	return listener
+}
+
+// serve 提供服务, gin 等等的server也是这么设计
+func (s *nonBlockingGRPCServer) serve(listener net.Listener) {
+	defer s.wg.Done()
 
 	glog.V(4).Infof("正在监听这个地址连接提供的服务: %#v", listener.Addr())
 	// 根据 listen 的地址 serve  (listen-->serve,根据地址做个listener,根据listenr做serve)
-	if err := server.Serve(listener); err != nil {
+	// 调用 Stop 或者 GracefulStop 之后返回 nil
+	if err := s.server.Serve(listener); err != nil {
 		glog.Fatalf("服务 serve 失败: %v", err)
 	}
 }
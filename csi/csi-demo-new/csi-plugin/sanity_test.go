@@ -0,0 +1,396 @@
+package mycsi
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+// sanityHarness 在临时 unix socket 上运行完整的 csi driver,通过真实的 grpc client 调用
+// 使用 fake mounter 和临时目录下的 hostpath 后端,不需要集群和 root 权限
+type sanityHarness struct {
+	driver     *MyCSIDriver
+	mounter    *FakeMounter
+	identity   csi.IdentityClient
+	controller csi.ControllerClient
+	node       csi.NodeClient
+	// staging 和 target 目录的根目录
+	dir string
+}
+
+func newSanityHarness(t *testing.T) *sanityHarness {
+	t.Helper()
+	driver, mounter := newTestDriver(t)
+
+	// unix socket 路径长度有限制,不放在测试名称生成的临时目录下
+	socketDir, err := os.MkdirTemp("", "csi-sanity")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(socketDir) })
+	endpoint := "unix://" + filepath.Join(socketDir, "csi.sock")
+
+	s := NewNonBlockingGRPCServer()
+	s.Start(endpoint, driver.ids, driver.cs, driver.ns)
+	conn, err := grpc.Dial(endpoint, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		s.ForceStop()
+		t.Fatalf("Dial: %v", err)
+	}
+	t.Cleanup(func() {
+		conn.Close()
+		s.Shutdown(time.Second)
+		s.Wait()
+	})
+
+	return &sanityHarness{
+		driver:     driver,
+		mounter:    mounter,
+		identity:   csi.NewIdentityClient(conn),
+		controller: csi.NewControllerClient(conn),
+		node:       csi.NewNodeClient(conn),
+		dir:        t.TempDir(),
+	}
+}
+
+func sanityContext(t *testing.T) context.Context {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	t.Cleanup(cancel)
+	return ctx
+}
+
+func expectCode(t *testing.T, err error, code codes.Code, what string) {
+	t.Helper()
+	if status.Code(err) != code {
+		t.Errorf("%s: expected %v, got %v", what, code, err)
+	}
+}
+
+func (h *sanityHarness) createVolume(t *testing.T, ctx context.Context, name string, size int64) *csi.Volume {
+	t.Helper()
+	resp, err := h.controller.CreateVolume(ctx, &csi.CreateVolumeRequest{
+		Name:               name,
+		CapacityRange:      &csi.CapacityRange{RequiredBytes: size},
+		VolumeCapabilities: []*csi.VolumeCapability{mountCapability("ext4")},
+	})
+	if err != nil {
+		t.Fatalf("CreateVolume %s: %v", name, err)
+	}
+	return resp.Volume
+}
+
+func TestSanityIdentity(t *testing.T) {
+	h := newSanityHarness(t)
+	ctx := sanityContext(t)
+
+	info, err := h.identity.GetPluginInfo(ctx, &csi.GetPluginInfoRequest{})
+	if err != nil {
+		t.Fatalf("GetPluginInfo: %v", err)
+	}
+	if info.Name != "test.csi.driver" || info.VendorVersion == "" {
+		t.Errorf("unexpected plugin info: %v", info)
+	}
+
+	probe, err := h.identity.Probe(ctx, &csi.ProbeRequest{})
+	if err != nil {
+		t.Fatalf("Probe: %v", err)
+	}
+	if probe.Ready != nil && !probe.Ready.Value {
+		t.Errorf("expected plugin to be ready")
+	}
+
+	caps, err := h.identity.GetPluginCapabilities(ctx, &csi.GetPluginCapabilitiesRequest{})
+	if err != nil {
+		t.Fatalf("GetPluginCapabilities: %v", err)
+	}
+	found := false
+	for _, c := range caps.Capabilities {
+		if c.GetService().GetType() == csi.PluginCapability_Service_CONTROLLER_SERVICE {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("expected CONTROLLER_SERVICE capability, got %v", caps.Capabilities)
+	}
+}
+
+func TestSanityCapabilitiesAndInfo(t *testing.T) {
+	h := newSanityHarness(t)
+	ctx := sanityContext(t)
+
+	cs, err := h.controller.ControllerGetCapabilities(ctx, &csi.ControllerGetCapabilitiesRequest{})
+	if err != nil || len(cs.Capabilities) == 0 {
+		t.Fatalf("ControllerGetCapabilities: %v, %v", cs, err)
+	}
+	ns, err := h.node.NodeGetCapabilities(ctx, &csi.NodeGetCapabilitiesRequest{})
+	if err != nil || len(ns.Capabilities) == 0 {
+		t.Fatalf("NodeGetCapabilities: %v, %v", ns, err)
+	}
+	info, err := h.node.NodeGetInfo(ctx, &csi.NodeGetInfoRequest{})
+	if err != nil {
+		t.Fatalf("NodeGetInfo: %v", err)
+	}
+	if info.NodeId != "node-1" {
+		t.Errorf("expected node id node-1, got %q", info.NodeId)
+	}
+}
+
+func TestSanityCreateDeleteVolume(t *testing.T) {
+	h := newSanityHarness(t)
+	ctx := sanityContext(t)
+
+	_, err := h.controller.CreateVolume(ctx, &csi.CreateVolumeRequest{
+		VolumeCapabilities: []*csi.VolumeCapability{mountCapability("ext4")},
+	})
+	expectCode(t, err, codes.InvalidArgument, "CreateVolume without name")
+	_, err = h.controller.CreateVolume(ctx, &csi.CreateVolumeRequest{Name: "pvc-1"})
+	expectCode(t, err, codes.InvalidArgument, "CreateVolume without capabilities")
+
+	// 同名同参数的请求返回同一个 volume
+	vol := h.createVolume(t, ctx, "pvc-1", MiB)
+	again := h.createVolume(t, ctx, "pvc-1", MiB)
+	if again.VolumeId != vol.VolumeId || vol.CapacityBytes < MiB {
+		t.Errorf("expected idempotent CreateVolume, got %v and %v", vol, again)
+	}
+	_, err = h.controller.CreateVolume(ctx, &csi.CreateVolumeRequest{
+		Name:               "pvc-1",
+		CapacityRange:      &csi.CapacityRange{RequiredBytes: 2 * MiB},
+		VolumeCapabilities: []*csi.VolumeCapability{mountCapability("ext4")},
+	})
+	expectCode(t, err, codes.AlreadyExists, "CreateVolume with a different size")
+
+	list, err := h.controller.ListVolumes(ctx, &csi.ListVolumesRequest{})
+	if err != nil || len(list.Entries) != 1 || list.Entries[0].Volume.VolumeId != vol.VolumeId {
+		t.Errorf("unexpected ListVolumes: %v, %v", list, err)
+	}
+
+	_, err = h.controller.ValidateVolumeCapabilities(ctx, &csi.ValidateVolumeCapabilitiesRequest{
+		VolumeId:           "missing",
+		VolumeCapabilities: []*csi.VolumeCapability{mountCapability("ext4")},
+	})
+	expectCode(t, err, codes.NotFound, "ValidateVolumeCapabilities of a missing volume")
+
+	_, err = h.controller.DeleteVolume(ctx, &csi.DeleteVolumeRequest{})
+	expectCode(t, err, codes.InvalidArgument, "DeleteVolume without volume id")
+	for i := 0; i < 2; i++ {
+		if _, err := h.controller.DeleteVolume(ctx, &csi.DeleteVolumeRequest{VolumeId: vol.VolumeId}); err != nil {
+			t.Fatalf("DeleteVolume #%d: %v", i, err)
+		}
+	}
+	if _, err := h.controller.DeleteVolume(ctx, &csi.DeleteVolumeRequest{VolumeId: "missing"}); err != nil {
+		t.Errorf("DeleteVolume of a missing volume: %v", err)
+	}
+}
+
+func TestSanityControllerPublish(t *testing.T) {
+	h := newSanityHarness(t)
+	ctx := sanityContext(t)
+	vol := h.createVolume(t, ctx, "pvc-1", MiB)
+
+	_, err := h.controller.ControllerPublishVolume(ctx, &csi.ControllerPublishVolumeRequest{VolumeId: vol.VolumeId})
+	expectCode(t, err, codes.InvalidArgument, "ControllerPublishVolume without node id")
+	_, err = h.controller.ControllerPublishVolume(ctx, &csi.ControllerPublishVolumeRequest{NodeId: "node-1"})
+	expectCode(t, err, codes.InvalidArgument, "ControllerPublishVolume without volume id")
+	_, err = h.controller.ControllerPublishVolume(ctx, &csi.ControllerPublishVolumeRequest{VolumeId: "missing", NodeId: "node-1"})
+	expectCode(t, err, codes.NotFound, "ControllerPublishVolume of a missing volume")
+
+	req := &csi.ControllerPublishVolumeRequest{
+		VolumeId:         vol.VolumeId,
+		NodeId:           "node-1",
+		VolumeCapability: mountCapability("ext4"),
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := h.controller.ControllerPublishVolume(ctx, req); err != nil {
+			t.Fatalf("ControllerPublishVolume #%d: %v", i, err)
+		}
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := h.controller.ControllerUnpublishVolume(ctx, &csi.ControllerUnpublishVolumeRequest{VolumeId: vol.VolumeId, NodeId: "node-1"}); err != nil {
+			t.Fatalf("ControllerUnpublishVolume #%d: %v", i, err)
+		}
+	}
+}
+
+func TestSanityNodeArguments(t *testing.T) {
+	h := newSanityHarness(t)
+	ctx := sanityContext(t)
+	staging := filepath.Join(h.dir, "staging")
+	target := filepath.Join(h.dir, "target")
+
+	_, err := h.node.NodeStageVolume(ctx, &csi.NodeStageVolumeRequest{StagingTargetPath: staging, VolumeCapability: mountCapability("ext4")})
+	expectCode(t, err, codes.InvalidArgument, "NodeStageVolume without volume id")
+	_, err = h.node.NodeStageVolume(ctx, &csi.NodeStageVolumeRequest{VolumeId: "vol", VolumeCapability: mountCapability("ext4")})
+	expectCode(t, err, codes.InvalidArgument, "NodeStageVolume without staging path")
+	_, err = h.node.NodeStageVolume(ctx, &csi.NodeStageVolumeRequest{VolumeId: "vol", StagingTargetPath: staging})
+	expectCode(t, err, codes.InvalidArgument, "NodeStageVolume without capability")
+	_, err = h.node.NodeStageVolume(ctx, &csi.NodeStageVolumeRequest{VolumeId: "missing", StagingTargetPath: staging, VolumeCapability: mountCapability("ext4")})
+	expectCode(t, err, codes.NotFound, "NodeStageVolume of a missing volume")
+
+	_, err = h.node.NodePublishVolume(ctx, &csi.NodePublishVolumeRequest{StagingTargetPath: staging, TargetPath: target, VolumeCapability: mountCapability("ext4")})
+	expectCode(t, err, codes.InvalidArgument, "NodePublishVolume without volume id")
+	_, err = h.node.NodePublishVolume(ctx, &csi.NodePublishVolumeRequest{VolumeId: "vol", StagingTargetPath: staging, VolumeCapability: mountCapability("ext4")})
+	expectCode(t, err, codes.InvalidArgument, "NodePublishVolume without target path")
+	_, err = h.node.NodePublishVolume(ctx, &csi.NodePublishVolumeRequest{VolumeId: "vol", StagingTargetPath: staging, TargetPath: target})
+	expectCode(t, err, codes.InvalidArgument, "NodePublishVolume without capability")
+
+	_, err = h.node.NodeUnpublishVolume(ctx, &csi.NodeUnpublishVolumeRequest{TargetPath: target})
+	expectCode(t, err, codes.InvalidArgument, "NodeUnpublishVolume without volume id")
+	_, err = h.node.NodeUnpublishVolume(ctx, &csi.NodeUnpublishVolumeRequest{VolumeId: "vol"})
+	expectCode(t, err, codes.InvalidArgument, "NodeUnpublishVolume without target path")
+	_, err = h.node.NodeUnstageVolume(ctx, &csi.NodeUnstageVolumeRequest{StagingTargetPath: staging})
+	expectCode(t, err, codes.InvalidArgument, "NodeUnstageVolume without volume id")
+	_, err = h.node.NodeUnstageVolume(ctx, &csi.NodeUnstageVolumeRequest{VolumeId: "vol"})
+	expectCode(t, err, codes.InvalidArgument, "NodeUnstageVolume without staging path")
+
+	_, err = h.node.NodeGetVolumeStats(ctx, &csi.NodeGetVolumeStatsRequest{VolumeId: "missing", VolumePath: h.dir})
+	expectCode(t, err, codes.NotFound, "NodeGetVolumeStats of a missing volume")
+}
+
+// 完整的生命周期,每一步都调用两次验证幂等
+func TestSanityVolumeLifecycle(t *testing.T) {
+	h := newSanityHarness(t)
+	ctx := sanityContext(t)
+	staging := filepath.Join(h.dir, "staging")
+	target := filepath.Join(h.dir, "target")
+
+	vol := h.createVolume(t, ctx, "pvc-1", 4*MiB)
+	twice := func(what string, call func() error) {
+		t.Helper()
+		for i := 0; i < 2; i++ {
+			if err := call(); err != nil {
+				t.Fatalf("%s #%d: %v", what, i, err)
+			}
+		}
+	}
+
+	twice("ControllerPublishVolume", func() error {
+		_, err := h.controller.ControllerPublishVolume(ctx, &csi.ControllerPublishVolumeRequest{
+			VolumeId: vol.VolumeId, NodeId: "node-1", VolumeCapability: mountCapability("ext4"),
+		})
+		return err
+	})
+	twice("NodeStageVolume", func() error {
+		_, err := h.node.NodeStageVolume(ctx, &csi.NodeStageVolumeRequest{
+			VolumeId: vol.VolumeId, StagingTargetPath: staging, VolumeCapability: mountCapability("ext4"),
+		})
+		return err
+	})
+	twice("NodePublishVolume", func() error {
+		_, err := h.node.NodePublishVolume(ctx, &csi.NodePublishVolumeRequest{
+			VolumeId: vol.VolumeId, StagingTargetPath: staging, TargetPath: target, VolumeCapability: mountCapability("ext4"),
+		})
+		return err
+	})
+	if _, ok := h.mounter.GetMountPoint(target); !ok {
+		t.Fatalf("expected %s to be mounted", target)
+	}
+
+	stats, err := h.node.NodeGetVolumeStats(ctx, &csi.NodeGetVolumeStatsRequest{VolumeId: vol.VolumeId, VolumePath: target})
+	if err != nil || stats.VolumeCondition.GetAbnormal() {
+		t.Errorf("unexpected NodeGetVolumeStats: %v, %v", stats, err)
+	}
+	got, err := h.controller.ControllerGetVolume(ctx, &csi.ControllerGetVolumeRequest{VolumeId: vol.VolumeId})
+	if err != nil || len(got.Status.PublishedNodeIds) != 1 {
+		t.Errorf("unexpected ControllerGetVolume: %v, %v", got, err)
+	}
+
+	expand, err := h.controller.ControllerExpandVolume(ctx, &csi.ControllerExpandVolumeRequest{
+		VolumeId: vol.VolumeId, CapacityRange: &csi.CapacityRange{RequiredBytes: 8 * MiB},
+	})
+	if err != nil || !expand.NodeExpansionRequired {
+		t.Fatalf("ControllerExpandVolume: %v, %v", expand, err)
+	}
+	if _, err := h.node.NodeExpandVolume(ctx, &csi.NodeExpandVolumeRequest{
+		VolumeId: vol.VolumeId, VolumePath: target, StagingTargetPath: staging,
+		CapacityRange: &csi.CapacityRange{RequiredBytes: 8 * MiB},
+	}); err != nil {
+		t.Fatalf("NodeExpandVolume: %v", err)
+	}
+
+	twice("NodeUnpublishVolume", func() error {
+		_, err := h.node.NodeUnpublishVolume(ctx, &csi.NodeUnpublishVolumeRequest{VolumeId: vol.VolumeId, TargetPath: target})
+		return err
+	})
+	twice("NodeUnstageVolume", func() error {
+		_, err := h.node.NodeUnstageVolume(ctx, &csi.NodeUnstageVolumeRequest{VolumeId: vol.VolumeId, StagingTargetPath: staging})
+		return err
+	})
+	twice("ControllerUnpublishVolume", func() error {
+		_, err := h.controller.ControllerUnpublishVolume(ctx, &csi.ControllerUnpublishVolumeRequest{VolumeId: vol.VolumeId, NodeId: "node-1"})
+		return err
+	})
+	twice("DeleteVolume", func() error {
+		_, err := h.controller.DeleteVolume(ctx, &csi.DeleteVolumeRequest{VolumeId: vol.VolumeId})
+		return err
+	})
+
+	if len(h.mounter.MountPoints) != 0 {
+		t.Errorf("expected no mount points left, got %v", h.mounter.MountPoints)
+	}
+	for _, p := range []string{staging, target} {
+		if _, err := os.Stat(p); !os.IsNotExist(err) {
+			t.Errorf("expected %s to be removed, got %v", p, err)
+		}
+	}
+}
+
+func TestSanitySnapshots(t *testing.T) {
+	h := newSanityHarness(t)
+	ctx := sanityContext(t)
+	vol := h.createVolume(t, ctx, "pvc-1", MiB)
+
+	_, err := h.controller.CreateSnapshot(ctx, &csi.CreateSnapshotRequest{SourceVolumeId: vol.VolumeId})
+	expectCode(t, err, codes.InvalidArgument, "CreateSnapshot without name")
+	_, err = h.controller.CreateSnapshot(ctx, &csi.CreateSnapshotRequest{Name: "snap-1"})
+	expectCode(t, err, codes.InvalidArgument, "CreateSnapshot without source volume")
+	_, err = h.controller.CreateSnapshot(ctx, &csi.CreateSnapshotRequest{Name: "snap-missing", SourceVolumeId: "missing"})
+	expectCode(t, err, codes.NotFound, "CreateSnapshot of a missing volume")
+
+	req := &csi.CreateSnapshotRequest{Name: "snap-1", SourceVolumeId: vol.VolumeId}
+	snap, err := h.controller.CreateSnapshot(ctx, req)
+	if err != nil {
+		t.Fatalf("CreateSnapshot: %v", err)
+	}
+	again, err := h.controller.CreateSnapshot(ctx, req)
+	if err != nil || again.Snapshot.SnapshotId != snap.Snapshot.SnapshotId || !snap.Snapshot.ReadyToUse {
+		t.Errorf("expected idempotent CreateSnapshot, got %v and %v, %v", snap, again, err)
+	}
+	other := h.createVolume(t, ctx, "pvc-2", MiB)
+	_, err = h.controller.CreateSnapshot(ctx, &csi.CreateSnapshotRequest{Name: "snap-1", SourceVolumeId: other.VolumeId})
+	expectCode(t, err, codes.AlreadyExists, "CreateSnapshot with a different source")
+
+	list, err := h.controller.ListSnapshots(ctx, &csi.ListSnapshotsRequest{SnapshotId: snap.Snapshot.SnapshotId})
+	if err != nil || len(list.Entries) != 1 {
+		t.Errorf("unexpected ListSnapshots: %v, %v", list, err)
+	}
+
+	restored, err := h.controller.CreateVolume(ctx, &csi.CreateVolumeRequest{
+		Name:               "pvc-restored",
+		CapacityRange:      &csi.CapacityRange{RequiredBytes: MiB},
+		VolumeCapabilities: []*csi.VolumeCapability{mountCapability("ext4")},
+		VolumeContentSource: &csi.VolumeContentSource{
+			Type: &csi.VolumeContentSource_Snapshot{
+				Snapshot: &csi.VolumeContentSource_SnapshotSource{SnapshotId: snap.Snapshot.SnapshotId},
+			},
+		},
+	})
+	if err != nil || restored.Volume.ContentSource.GetSnapshot().GetSnapshotId() != snap.Snapshot.SnapshotId {
+		t.Errorf("unexpected CreateVolume from snapshot: %v, %v", restored, err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err := h.controller.DeleteSnapshot(ctx, &csi.DeleteSnapshotRequest{SnapshotId: snap.Snapshot.SnapshotId}); err != nil {
+			t.Fatalf("DeleteSnapshot #%d: %v", i, err)
+		}
+	}
+	_, err = h.controller.DeleteSnapshot(ctx, &csi.DeleteSnapshotRequest{})
+	expectCode(t, err, codes.InvalidArgument, "DeleteSnapshot without snapshot id")
+}
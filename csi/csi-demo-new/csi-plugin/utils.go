@@ -2,12 +2,19 @@ package mycsi
 
 import (
 	"bytes"
+	"fmt"
 	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
 
 	csi "github.com/container-storage-interface/spec/lib/go/csi"
 	"github.com/golang/glog"
+	"github.com/kubernetes-csi/csi-lib-utils/protosanitizer"
 	"golang.org/x/net/context"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 func executeCmd(command string, args []string) ([]byte, error) {
@@ -24,18 +31,109 @@ func executeCmd(command string, args []string) ([]byte, error) {
 	return stdOut, err
 }
 
+// 执行命令并通过 stdin 传入 input,用于传递密码等不能出现在命令行参数中的内容
+func executeCmdWithInput(command string, args []string, input string) ([]byte, error) {
+	glog.V(5).Infof("csi_util executeCmdWithInput")
+
+	cmd := exec.Command(command, args...)
+	var stdout bytes.Buffer
+
+	cmd.Stdin = strings.NewReader(input)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stdout
+	err := cmd.Run()
+	return stdout.Bytes(), err
+}
+
+// 解析容量,支持 1024、1Ki、1Mi、1Gi、1Ti 以及 1K、1M、1G、1T
+func parseSize(s string) (int64, error) {
+	units := []struct {
+		suffix string
+		size   int64
+	}{
+		{"Ki", KiB}, {"Mi", MiB}, {"Gi", GiB}, {"Ti", TiB},
+		{"K", 1000}, {"M", 1000 * 1000}, {"G", 1000 * 1000 * 1000}, {"T", 1000 * 1000 * 1000 * 1000},
+	}
+	multiplier := int64(1)
+	for _, u := range units {
+		if strings.HasSuffix(s, u.suffix) {
+			s, multiplier = strings.TrimSuffix(s, u.suffix), u.size
+			break
+		}
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	if n <= 0 {
+		return 0, fmt.Errorf("容量必须大于 0")
+	}
+	return n * multiplier, nil
+}
+
+// 文件系统的容量和 inode 使用情况
+type fsStats struct {
+	totalBytes     int64
+	availableBytes int64
+	usedBytes      int64
+	totalInodes    int64
+	freeInodes     int64
+	usedInodes     int64
+}
+
+func getFsStats(path string) (*fsStats, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return nil, fmt.Errorf("statfs %s 失败: %v", path, err)
+	}
+	bsize := int64(st.Bsize)
+	return &fsStats{
+		totalBytes:     int64(st.Blocks) * bsize,
+		availableBytes: int64(st.Bavail) * bsize,
+		usedBytes:      (int64(st.Blocks) - int64(st.Bfree)) * bsize,
+		totalInodes:    int64(st.Files),
+		freeInodes:     int64(st.Ffree),
+		usedInodes:     int64(st.Files) - int64(st.Ffree),
+	}, nil
+}
+
 func logGRPC(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
 	glog.V(3).Infof("GRPC call: %s", info.FullMethod)
-	glog.V(5).Infof("GRPC request: %+v", req)
+	glog.V(5).Infof("GRPC request: %s", protosanitizer.StripSecrets(req))
 	resp, err := handler(ctx, req)
 	if err != nil {
 		glog.Errorf("GRPC error: %v", err)
 	} else {
-		glog.V(5).Infof("GRPC response: %+v", resp)
+		glog.V(5).Infof("GRPC response: %s", protosanitizer.StripSecrets(resp))
 	}
 	return resp, err
 }
 
+// 分页,token 是起始下标,返回本页的 [start, end) 以及下一页的 token
+// token 无效时按照 csi 规范返回 Aborted
+func paginate(token string, maxEntries int32, total int) (int, int, string, error) {
+	if maxEntries < 0 {
+		return 0, 0, "", status.Error(codes.InvalidArgument, "max_entries 不能为负数")
+	}
+	start := 0
+	if token != "" {
+		i, err := strconv.Atoi(token)
+		if err != nil || i < 0 || i > total {
+			return 0, 0, "", status.Error(codes.Aborted, fmt.Sprintf("无效的 starting_token: %s", token))
+		}
+		start = i
+	}
+	end := total
+	if maxEntries > 0 && start+int(maxEntries) < total {
+		end = start + int(maxEntries)
+	}
+	nextToken := ""
+	if end < total {
+		nextToken = strconv.Itoa(end)
+	}
+	return start, end, nextToken, nil
+}
+
 func NewVolumeCapabilityAccessMode(mode csi.VolumeCapability_AccessMode_Mode) *csi.VolumeCapability_AccessMode {
 	return &csi.VolumeCapability_AccessMode{Mode: mode}
 }
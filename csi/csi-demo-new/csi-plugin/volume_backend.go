@@ -0,0 +1,106 @@
+package mycsi
+
+import (
+	"errors"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+)
+
+var (
+	// volume 不存在
+	ErrVolumeNotFound = errors.New("volume not found")
+	// 同名 volume 已存在,但是容量不够或者数据源不一致
+	ErrVolumeExists = errors.New("volume already exists with different capacity or content source")
+	// 快照不存在
+	ErrSnapshotNotFound = errors.New("snapshot not found")
+	// 同名快照已存在,但是源 volume 不一致
+	ErrSnapshotExists = errors.New("snapshot already exists with different source volume")
+	// 新 volume 的容量小于数据源的大小
+	ErrVolumeTooSmall = errors.New("volume capacity is smaller than content source")
+	// 不支持缩小 volume 的容量
+	ErrVolumeShrink = errors.New("volume capacity cannot be reduced")
+)
+
+// Volume 后端存储中的一个 volume,会被持久化到 volume 索引中
+type Volume struct {
+	ID            string            `json:"id"`
+	Name          string            `json:"name"`
+	CapacityBytes int64             `json:"capacityBytes"`
+	Path          string            `json:"path"`
+	Parameters    map[string]string `json:"parameters,omitempty"`
+	// 创建 volume 时的数据源,没有则为空
+	Source *VolumeSource `json:"source,omitempty"`
+	// ControllerPublishVolume 发布到的节点
+	PublishedNodes []string `json:"publishedNodes,omitempty"`
+	// inline ephemeral volume 的信息,普通 volume 为空
+	Ephemeral *EphemeralVolume `json:"ephemeral,omitempty"`
+}
+
+// EphemeralVolume pod 中声明的 inline ephemeral volume,由 csi-node 在 NodePublishVolume 时创建
+type EphemeralVolume struct {
+	// kubelet 传过来的 volume id
+	PodVolumeID string `json:"podVolumeID"`
+	// 创建 volume 的节点
+	NodeID string `json:"nodeID"`
+	// 发布到的 pod 目录
+	TargetPath string `json:"targetPath"`
+}
+
+// VolumeSource 创建 volume 时的数据源
+type VolumeSource struct {
+	// 从快照恢复
+	SnapshotID string `json:"snapshotID,omitempty"`
+	// 克隆已有的 volume
+	VolumeID string `json:"volumeID,omitempty"`
+}
+
+// Snapshot volume 的快照,是源 volume 镜像文件的一份拷贝
+type Snapshot struct {
+	ID             string    `json:"id"`
+	Name           string    `json:"name"`
+	SourceVolumeID string    `json:"sourceVolumeID"`
+	SizeBytes      int64     `json:"sizeBytes"`
+	CreationTime   time.Time `json:"creationTime"`
+	Path           string    `json:"path"`
+}
+
+// VolumeBackend 可插拔的 volume 后端存储
+// csi-controller 通过它创建删除 volume,csi-node 通过它找到 volume 的数据位置
+type VolumeBackend interface {
+	// 后端存储支持的访问模式
+	AccessModes() []csi.VolumeCapability_AccessMode_Mode
+
+	// 创建 volume,同名 volume 已存在并且容量不小于 capacityBytes 时直接返回已有的 volume(幂等)
+	// source 不为空时用数据源的数据填充新 volume
+	CreateVolume(name string, capacityBytes int64, parameters map[string]string, source *VolumeSource) (*Volume, error)
+	// 创建 inline ephemeral volume,和 volume 一起记录 ephemeral 标记,幂等同 CreateVolume
+	CreateEphemeralVolume(name string, capacityBytes int64, parameters map[string]string, ephemeral *EphemeralVolume) (*Volume, error)
+	// 删除 volume 以及数据,volume 不存在时不返回错误(幂等)
+	DeleteVolume(volumeID string) error
+	// 根据 id 获取 volume,不存在返回 ErrVolumeNotFound
+	GetVolumeByID(volumeID string) (*Volume, error)
+	// 根据名称获取 volume,不存在返回 ErrVolumeNotFound
+	GetVolumeByName(name string) (*Volume, error)
+	// 所有 volume,按 id 排序
+	ListVolumes() ([]*Volume, error)
+	// 扩容 volume 到 capacityBytes,小于当前容量返回 ErrVolumeShrink
+	ExpandVolume(volumeID string, capacityBytes int64) (*Volume, error)
+	// 记录 volume 发布到了 nodeID 节点,重复记录不返回错误
+	PublishVolume(volumeID, nodeID string) error
+	// 删除 volume 发布到 nodeID 节点的记录,没有记录不返回错误
+	UnpublishVolume(volumeID, nodeID string) error
+	// 检查 volume 的数据是否正常,正常返回 nil
+	CheckVolume(vol *Volume) error
+	// 后端存储剩余的可用容量
+	GetCapacity() (int64, error)
+
+	// 为 volume 创建快照,同名快照已存在并且源 volume 一致时直接返回已有的快照(幂等)
+	CreateSnapshot(name, sourceVolumeID string) (*Snapshot, error)
+	// 删除快照以及数据,快照不存在时不返回错误(幂等)
+	DeleteSnapshot(snapshotID string) error
+	// 根据 id 获取快照,不存在返回 ErrSnapshotNotFound
+	GetSnapshotByID(snapshotID string) (*Snapshot, error)
+	// 所有快照,按 id 排序
+	ListSnapshots() ([]*Snapshot, error)
+}
@@ -5,13 +5,21 @@ go 1.18
 require (
 	github.com/container-storage-interface/spec v1.7.0
 	github.com/golang/glog v1.0.0
-	github.com/nightlyone/lockfile v1.0.0
+	github.com/golang/protobuf v1.5.2
+	github.com/kubernetes-csi/csi-lib-utils v0.11.0
+	github.com/prometheus/client_golang v1.14.0
 	golang.org/x/net v0.4.0
 	google.golang.org/grpc v1.52.0
 )
 
 require (
-	github.com/golang/protobuf v1.5.2 // indirect
+	github.com/beorn7/perks v1.0.1 // indirect
+	github.com/cespare/xxhash/v2 v2.1.2 // indirect
+	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/matttproud/golang_protobuf_extensions v1.0.2-0.20181231171920-c182affec369 // indirect
+	github.com/prometheus/client_model v0.3.0 // indirect
+	github.com/prometheus/common v0.37.0 // indirect
+	github.com/prometheus/procfs v0.8.0 // indirect
 	golang.org/x/sys v0.3.0 // indirect
 	golang.org/x/text v0.5.0 // indirect
 	google.golang.org/genproto v0.0.0-20221118155620-16455021b5e6 // indirect
@@ -0,0 +1,54 @@
+package scheme
+
+import (
+	"fmt"
+	"reflect"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"sigs.k8s.io/yaml"
+
+	"schedulePlugin/apis/config"
+	"schedulePlugin/apis/config/v1beta1"
+)
+
+// Scheme 注册了插件参数的内部版本和 v1beta1 版本
+var Scheme = runtime.NewScheme()
+
+func init() {
+	utilruntime.Must(config.AddToScheme(Scheme))
+	utilruntime.Must(v1beta1.AddToScheme(Scheme))
+}
+
+// DecodeInto 把 scheduler 传给插件的参数解码成内部版本 into
+// 参数按 v1beta1 严格解码,未知字段报错,然后填充默认值并转换成内部版本
+// object 为 nil 表示没有配置参数,全部使用默认值
+func DecodeInto(object runtime.Object, into runtime.Object) error {
+	gvks, _, err := Scheme.ObjectKinds(into)
+	if err != nil {
+		return err
+	}
+	versioned, err := Scheme.New(v1beta1.SchemeGroupVersion.WithKind(gvks[0].Kind))
+	if err != nil {
+		return err
+	}
+
+	switch obj := object.(type) {
+	case nil:
+	case *runtime.Unknown:
+		if len(obj.Raw) > 0 {
+			if err := yaml.UnmarshalStrict(obj.Raw, versioned); err != nil {
+				return fmt.Errorf("decoding %s: %v", gvks[0].Kind, err)
+			}
+		}
+	default:
+		// 直接传入 v1beta1 版本的参数,测试时使用
+		if reflect.TypeOf(object) != reflect.TypeOf(versioned) {
+			return fmt.Errorf("want args of type %T, got %T", versioned, object)
+		}
+		versioned = object.DeepCopyObject()
+	}
+
+	Scheme.Default(versioned)
+	return Scheme.Convert(versioned, into, nil)
+}
@@ -0,0 +1,64 @@
+package scheme
+
+import (
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/runtime"
+
+	"schedulePlugin/apis/config"
+	"schedulePlugin/apis/config/v1beta1"
+)
+
+func TestDecodeInto(t *testing.T) {
+	tests := []struct {
+		name    string
+		object  runtime.Object
+		want    config.SampleArgs
+		wantErr string
+	}{
+		{
+			name:   "no args",
+			object: nil,
+			want:   config.SampleArgs{CPUWeight: 1, MemoryWeight: 1, MetricsStalenessSeconds: 300, CPUUtilizationThreshold: 100, MemoryUtilizationThreshold: 100, PreemptionVictimOrder: config.VictimOrderYoungestFirst},
+		},
+		{
+			name:   "only memory weight",
+			object: &runtime.Unknown{Raw: []byte(`{"memoryWeight": 2, "cpuUtilizationThreshold": 90}`), ContentType: runtime.ContentTypeJSON},
+			want:   config.SampleArgs{CPUWeight: 0, MemoryWeight: 2, MetricsStalenessSeconds: 300, CPUUtilizationThreshold: 90, MemoryUtilizationThreshold: 100, PreemptionVictimOrder: config.VictimOrderYoungestFirst},
+		},
+		{
+			name:   "versioned args",
+			object: &v1beta1.SampleArgs{MetricsStalenessSeconds: func(v int64) *int64 { return &v }(60)},
+			want:   config.SampleArgs{CPUWeight: 1, MemoryWeight: 1, MetricsStalenessSeconds: 60, CPUUtilizationThreshold: 100, MemoryUtilizationThreshold: 100, PreemptionVictimOrder: config.VictimOrderYoungestFirst},
+		},
+		{
+			name:    "unknown field",
+			object:  &runtime.Unknown{Raw: []byte(`{"thanksTo": "Kubernetes"}`), ContentType: runtime.ContentTypeJSON},
+			wantErr: `unknown field "thanksTo"`,
+		},
+		{
+			name:    "wrong type",
+			object:  &v1beta1.CoschedulingArgs{},
+			wantErr: "want args of type *v1beta1.SampleArgs",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := &config.SampleArgs{}
+			err := DecodeInto(tt.object, got)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expected error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("DecodeInto: %v", err)
+			}
+			if *got != tt.want {
+				t.Errorf("expected %+v, got %+v", tt.want, *got)
+			}
+		})
+	}
+}
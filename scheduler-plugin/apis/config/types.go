@@ -0,0 +1,121 @@
+package config
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// 插件参数的内部版本,插件只使用这里的类型
+// 配置文件中的参数是 v1beta1 版本,经过默认值填充和转换之后得到内部版本
+
+// GroupName 插件参数的 group
+const GroupName = "kubescheduler.config.sample.io"
+
+// SchemeGroupVersion 内部版本
+var SchemeGroupVersion = schema.GroupVersion{Group: GroupName, Version: runtime.APIVersionInternal}
+
+var (
+	SchemeBuilder = runtime.NewSchemeBuilder(addKnownTypes)
+	AddToScheme   = SchemeBuilder.AddToScheme
+)
+
+func addKnownTypes(scheme *runtime.Scheme) error {
+	scheme.AddKnownTypes(SchemeGroupVersion,
+		&SampleArgs{},
+		&CoschedulingArgs{},
+		&ElasticQuotaArgs{},
+		&LabelTopologySpreadArgs{},
+	)
+	return nil
+}
+
+// SampleArgs sample-plugin 的参数
+type SampleArgs struct {
+	// score 时 cpu 和内存利用率的权重
+	CPUWeight    int64
+	MemoryWeight int64
+	// 节点利用率数据超过这个时间没有更新就视为过期,打中间分数
+	MetricsStalenessSeconds int64
+	// 利用率百分比达到阈值的节点打最低分
+	CPUUtilizationThreshold    int64
+	MemoryUtilizationThreshold int64
+	// 设备 agent 在 kubelet 之外维护的扩展资源,插件用本地账本记录已经 reserve 的数量,为空不启用
+	ReservedResourceName string
+	// PostFilter 抢占时同一个节点上 victim 的顺序
+	PreemptionVictimOrder VictimOrder
+	// 只打印选出的节点和 victim,不驱逐也不提名节点
+	PreemptionDryRun bool
+}
+
+// VictimOrder 抢占时同一个节点上选择 victim 的顺序
+// victim 通过 Eviction 子资源驱逐,apiserver 拒绝违反 PodDisruptionBudget 的驱逐,顺序只影响选中哪些 victim
+type VictimOrder string
+
+const (
+	// VictimOrderYoungestFirst 优先级低的先驱逐,优先级相同时启动晚的先驱逐
+	VictimOrderYoungestFirst VictimOrder = "YoungestFirst"
+	// VictimOrderFewestPDBViolations 不违反 PodDisruptionBudget 的先驱逐,其次同 YoungestFirst
+	VictimOrderFewestPDBViolations VictimOrder = "FewestPDBViolations"
+)
+
+// CoschedulingArgs Coscheduling 插件的参数
+type CoschedulingArgs struct {
+	// PodGroup 没有设置 scheduleTimeoutSeconds 时 Permit 的等待时间
+	PermitWaitingTimeSeconds int64
+	// 访问 PodGroup 的 kubeconfig,为空使用 in-cluster 配置
+	KubeConfigPath string
+	KubeMaster     string
+}
+
+// ElasticQuotaArgs ElasticQuota 插件的参数
+type ElasticQuotaArgs struct {
+	// 访问 ElasticQuota 的 kubeconfig,为空使用 in-cluster 配置
+	KubeConfigPath string
+	KubeMaster     string
+}
+
+// LabelTopologySpreadArgs LabelTopologySpread 插件的参数
+type LabelTopologySpreadArgs struct {
+	// 节点上表示拓扑域的 label,例如 rack
+	TopologyKey string
+	// 统计哪些 pod,为空时统计和待调度 pod 属于同一个 PodGroup 的 pod
+	LabelSelector *metav1.LabelSelector
+}
+
+// GetObjectKind 内部版本没有 TypeMeta
+func (in *SampleArgs) GetObjectKind() schema.ObjectKind { return schema.EmptyObjectKind }
+
+// DeepCopyObject 实现 runtime.Object
+func (in *SampleArgs) DeepCopyObject() runtime.Object {
+	out := *in
+	return &out
+}
+
+// GetObjectKind 内部版本没有 TypeMeta
+func (in *CoschedulingArgs) GetObjectKind() schema.ObjectKind { return schema.EmptyObjectKind }
+
+// DeepCopyObject 实现 runtime.Object
+func (in *CoschedulingArgs) DeepCopyObject() runtime.Object {
+	out := *in
+	return &out
+}
+
+// GetObjectKind 内部版本没有 TypeMeta
+func (in *ElasticQuotaArgs) GetObjectKind() schema.ObjectKind { return schema.EmptyObjectKind }
+
+// DeepCopyObject 实现 runtime.Object
+func (in *ElasticQuotaArgs) DeepCopyObject() runtime.Object {
+	out := *in
+	return &out
+}
+
+// GetObjectKind 内部版本没有 TypeMeta
+func (in *LabelTopologySpreadArgs) GetObjectKind() schema.ObjectKind { return schema.EmptyObjectKind }
+
+// DeepCopyObject 实现 runtime.Object
+func (in *LabelTopologySpreadArgs) DeepCopyObject() runtime.Object {
+	out := *in
+	out.LabelSelector = in.LabelSelector.DeepCopy()
+	return &out
+}
@@ -0,0 +1,74 @@
+package v1beta1
+
+import (
+	"k8s.io/apimachinery/pkg/conversion"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	"schedulePlugin/apis/config"
+)
+
+// v1beta1 到内部版本的转换,插件参数只需要这个方向
+// 调用之前已经填充了默认值,指针不会为 nil
+
+func addConversionFuncs(scheme *runtime.Scheme) error {
+	if err := scheme.AddConversionFunc((*SampleArgs)(nil), (*config.SampleArgs)(nil), func(a, b interface{}, scope conversion.Scope) error {
+		return Convert_v1beta1_SampleArgs_To_config_SampleArgs(a.(*SampleArgs), b.(*config.SampleArgs), scope)
+	}); err != nil {
+		return err
+	}
+	if err := scheme.AddConversionFunc((*CoschedulingArgs)(nil), (*config.CoschedulingArgs)(nil), func(a, b interface{}, scope conversion.Scope) error {
+		return Convert_v1beta1_CoschedulingArgs_To_config_CoschedulingArgs(a.(*CoschedulingArgs), b.(*config.CoschedulingArgs), scope)
+	}); err != nil {
+		return err
+	}
+	if err := scheme.AddConversionFunc((*ElasticQuotaArgs)(nil), (*config.ElasticQuotaArgs)(nil), func(a, b interface{}, scope conversion.Scope) error {
+		return Convert_v1beta1_ElasticQuotaArgs_To_config_ElasticQuotaArgs(a.(*ElasticQuotaArgs), b.(*config.ElasticQuotaArgs), scope)
+	}); err != nil {
+		return err
+	}
+	return scheme.AddConversionFunc((*LabelTopologySpreadArgs)(nil), (*config.LabelTopologySpreadArgs)(nil), func(a, b interface{}, scope conversion.Scope) error {
+		return Convert_v1beta1_LabelTopologySpreadArgs_To_config_LabelTopologySpreadArgs(a.(*LabelTopologySpreadArgs), b.(*config.LabelTopologySpreadArgs), scope)
+	})
+}
+
+func int64Value(in *int64) int64 {
+	if in == nil {
+		return 0
+	}
+	return *in
+}
+
+// Convert_v1beta1_SampleArgs_To_config_SampleArgs 转换 SampleArgs
+func Convert_v1beta1_SampleArgs_To_config_SampleArgs(in *SampleArgs, out *config.SampleArgs, s conversion.Scope) error {
+	out.CPUWeight = int64Value(in.CPUWeight)
+	out.MemoryWeight = int64Value(in.MemoryWeight)
+	out.MetricsStalenessSeconds = int64Value(in.MetricsStalenessSeconds)
+	out.CPUUtilizationThreshold = int64Value(in.CPUUtilizationThreshold)
+	out.MemoryUtilizationThreshold = int64Value(in.MemoryUtilizationThreshold)
+	out.ReservedResourceName = in.ReservedResourceName
+	out.PreemptionVictimOrder = config.VictimOrder(in.PreemptionVictimOrder)
+	out.PreemptionDryRun = in.PreemptionDryRun
+	return nil
+}
+
+// Convert_v1beta1_CoschedulingArgs_To_config_CoschedulingArgs 转换 CoschedulingArgs
+func Convert_v1beta1_CoschedulingArgs_To_config_CoschedulingArgs(in *CoschedulingArgs, out *config.CoschedulingArgs, s conversion.Scope) error {
+	out.PermitWaitingTimeSeconds = int64Value(in.PermitWaitingTimeSeconds)
+	out.KubeConfigPath = in.KubeConfigPath
+	out.KubeMaster = in.KubeMaster
+	return nil
+}
+
+// Convert_v1beta1_ElasticQuotaArgs_To_config_ElasticQuotaArgs 转换 ElasticQuotaArgs
+func Convert_v1beta1_ElasticQuotaArgs_To_config_ElasticQuotaArgs(in *ElasticQuotaArgs, out *config.ElasticQuotaArgs, s conversion.Scope) error {
+	out.KubeConfigPath = in.KubeConfigPath
+	out.KubeMaster = in.KubeMaster
+	return nil
+}
+
+// Convert_v1beta1_LabelTopologySpreadArgs_To_config_LabelTopologySpreadArgs 转换 LabelTopologySpreadArgs
+func Convert_v1beta1_LabelTopologySpreadArgs_To_config_LabelTopologySpreadArgs(in *LabelTopologySpreadArgs, out *config.LabelTopologySpreadArgs, s conversion.Scope) error {
+	out.TopologyKey = in.TopologyKey
+	out.LabelSelector = in.LabelSelector.DeepCopy()
+	return nil
+}
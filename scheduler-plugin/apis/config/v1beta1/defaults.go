@@ -0,0 +1,70 @@
+package v1beta1
+
+import (
+	"k8s.io/apimachinery/pkg/runtime"
+
+	"schedulePlugin/apis/config"
+)
+
+const (
+	DefaultCPUWeight                int64 = 1
+	DefaultMemoryWeight             int64 = 1
+	DefaultMetricsStalenessSeconds  int64 = 300
+	DefaultUtilizationThreshold     int64 = 100
+	DefaultPermitWaitingTimeSeconds int64 = 60
+
+	DefaultTopologyKey = "rack"
+
+	DefaultPreemptionVictimOrder = string(config.VictimOrderYoungestFirst)
+)
+
+func addDefaultingFuncs(scheme *runtime.Scheme) error {
+	scheme.AddTypeDefaultingFunc(&SampleArgs{}, func(obj interface{}) { SetDefaults_SampleArgs(obj.(*SampleArgs)) })
+	scheme.AddTypeDefaultingFunc(&CoschedulingArgs{}, func(obj interface{}) { SetDefaults_CoschedulingArgs(obj.(*CoschedulingArgs)) })
+	scheme.AddTypeDefaultingFunc(&LabelTopologySpreadArgs{}, func(obj interface{}) { SetDefaults_LabelTopologySpreadArgs(obj.(*LabelTopologySpreadArgs)) })
+	return nil
+}
+
+func int64Ptr(v int64) *int64 {
+	return &v
+}
+
+// SetDefaults_SampleArgs 填充 SampleArgs 的默认值
+// 只设置了一个权重时另一个权重为 0,只按这一种资源打分
+func SetDefaults_SampleArgs(obj *SampleArgs) {
+	if obj.CPUWeight == nil && obj.MemoryWeight == nil {
+		obj.CPUWeight, obj.MemoryWeight = int64Ptr(DefaultCPUWeight), int64Ptr(DefaultMemoryWeight)
+	}
+	if obj.CPUWeight == nil {
+		obj.CPUWeight = int64Ptr(0)
+	}
+	if obj.MemoryWeight == nil {
+		obj.MemoryWeight = int64Ptr(0)
+	}
+	if obj.MetricsStalenessSeconds == nil {
+		obj.MetricsStalenessSeconds = int64Ptr(DefaultMetricsStalenessSeconds)
+	}
+	if obj.CPUUtilizationThreshold == nil {
+		obj.CPUUtilizationThreshold = int64Ptr(DefaultUtilizationThreshold)
+	}
+	if obj.MemoryUtilizationThreshold == nil {
+		obj.MemoryUtilizationThreshold = int64Ptr(DefaultUtilizationThreshold)
+	}
+	if obj.PreemptionVictimOrder == "" {
+		obj.PreemptionVictimOrder = DefaultPreemptionVictimOrder
+	}
+}
+
+// SetDefaults_CoschedulingArgs 填充 CoschedulingArgs 的默认值
+func SetDefaults_CoschedulingArgs(obj *CoschedulingArgs) {
+	if obj.PermitWaitingTimeSeconds == nil {
+		obj.PermitWaitingTimeSeconds = int64Ptr(DefaultPermitWaitingTimeSeconds)
+	}
+}
+
+// SetDefaults_LabelTopologySpreadArgs 填充 LabelTopologySpreadArgs 的默认值
+func SetDefaults_LabelTopologySpreadArgs(obj *LabelTopologySpreadArgs) {
+	if obj.TopologyKey == "" {
+		obj.TopologyKey = DefaultTopologyKey
+	}
+}
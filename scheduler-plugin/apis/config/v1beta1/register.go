@@ -0,0 +1,26 @@
+package v1beta1
+
+import (
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	"schedulePlugin/apis/config"
+)
+
+// SchemeGroupVersion 配置文件中插件参数的版本
+var SchemeGroupVersion = schema.GroupVersion{Group: config.GroupName, Version: "v1beta1"}
+
+var (
+	SchemeBuilder = runtime.NewSchemeBuilder(addKnownTypes, addDefaultingFuncs, addConversionFuncs)
+	AddToScheme   = SchemeBuilder.AddToScheme
+)
+
+func addKnownTypes(scheme *runtime.Scheme) error {
+	scheme.AddKnownTypes(SchemeGroupVersion,
+		&SampleArgs{},
+		&CoschedulingArgs{},
+		&ElasticQuotaArgs{},
+		&LabelTopologySpreadArgs{},
+	)
+	return nil
+}
@@ -0,0 +1,98 @@
+package v1beta1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// 配置文件中 pluginConfig.args 的版本
+// 没有设置的字段为 nil,由 defaults.go 填充默认值
+
+// SampleArgs sample-plugin 的参数
+type SampleArgs struct {
+	metav1.TypeMeta `json:",inline"`
+
+	// score 时 cpu 和内存利用率的权重,默认都是 1,不能同时为 0
+	CPUWeight    *int64 `json:"cpuWeight,omitempty"`
+	MemoryWeight *int64 `json:"memoryWeight,omitempty"`
+	// 节点利用率数据超过这个时间没有更新就视为过期,默认 300
+	MetricsStalenessSeconds *int64 `json:"metricsStalenessSeconds,omitempty"`
+	// 利用率百分比达到阈值的节点打最低分,默认 100
+	CPUUtilizationThreshold    *int64 `json:"cpuUtilizationThreshold,omitempty"`
+	MemoryUtilizationThreshold *int64 `json:"memoryUtilizationThreshold,omitempty"`
+	// 设备 agent 在 kubelet 之外维护的扩展资源,例如 example.com/device,为空不启用
+	ReservedResourceName string `json:"reservedResourceName,omitempty"`
+	// PostFilter 抢占时同一个节点上 victim 的顺序,YoungestFirst 或者 FewestPDBViolations,默认 YoungestFirst
+	PreemptionVictimOrder string `json:"preemptionVictimOrder,omitempty"`
+	// 只打印选出的节点和 victim,不驱逐也不提名节点,用于调整参数
+	PreemptionDryRun bool `json:"preemptionDryRun,omitempty"`
+}
+
+// CoschedulingArgs Coscheduling 插件的参数
+type CoschedulingArgs struct {
+	metav1.TypeMeta `json:",inline"`
+
+	// PodGroup 没有设置 scheduleTimeoutSeconds 时 Permit 的等待时间,默认 60
+	PermitWaitingTimeSeconds *int64 `json:"permitWaitingTimeSeconds,omitempty"`
+	// 访问 PodGroup 的 kubeconfig,为空使用 in-cluster 配置
+	KubeConfigPath string `json:"kubeConfigPath,omitempty"`
+	KubeMaster     string `json:"kubeMaster,omitempty"`
+}
+
+// ElasticQuotaArgs ElasticQuota 插件的参数
+type ElasticQuotaArgs struct {
+	metav1.TypeMeta `json:",inline"`
+
+	// 访问 ElasticQuota 的 kubeconfig,为空使用 in-cluster 配置
+	KubeConfigPath string `json:"kubeConfigPath,omitempty"`
+	KubeMaster     string `json:"kubeMaster,omitempty"`
+}
+
+// LabelTopologySpreadArgs LabelTopologySpread 插件的参数
+type LabelTopologySpreadArgs struct {
+	metav1.TypeMeta `json:",inline"`
+
+	// 节点上表示拓扑域的 label,默认 rack
+	TopologyKey string `json:"topologyKey,omitempty"`
+	// 统计哪些 pod,为空时统计和待调度 pod 属于同一个 PodGroup 的 pod
+	LabelSelector *metav1.LabelSelector `json:"labelSelector,omitempty"`
+}
+
+func copyInt64(in *int64) *int64 {
+	if in == nil {
+		return nil
+	}
+	out := *in
+	return &out
+}
+
+// DeepCopyObject 实现 runtime.Object
+func (in *SampleArgs) DeepCopyObject() runtime.Object {
+	out := *in
+	out.CPUWeight = copyInt64(in.CPUWeight)
+	out.MemoryWeight = copyInt64(in.MemoryWeight)
+	out.MetricsStalenessSeconds = copyInt64(in.MetricsStalenessSeconds)
+	out.CPUUtilizationThreshold = copyInt64(in.CPUUtilizationThreshold)
+	out.MemoryUtilizationThreshold = copyInt64(in.MemoryUtilizationThreshold)
+	return &out
+}
+
+// DeepCopyObject 实现 runtime.Object
+func (in *CoschedulingArgs) DeepCopyObject() runtime.Object {
+	out := *in
+	out.PermitWaitingTimeSeconds = copyInt64(in.PermitWaitingTimeSeconds)
+	return &out
+}
+
+// DeepCopyObject 实现 runtime.Object
+func (in *ElasticQuotaArgs) DeepCopyObject() runtime.Object {
+	out := *in
+	return &out
+}
+
+// DeepCopyObject 实现 runtime.Object
+func (in *LabelTopologySpreadArgs) DeepCopyObject() runtime.Object {
+	out := *in
+	out.LabelSelector = in.LabelSelector.DeepCopy()
+	return &out
+}
@@ -0,0 +1,85 @@
+package validation
+
+import (
+	"net/url"
+
+	v1 "k8s.io/api/core/v1"
+	metav1validation "k8s.io/apimachinery/pkg/apis/meta/v1/validation"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	v1helper "k8s.io/kubernetes/pkg/apis/core/v1/helper"
+
+	"schedulePlugin/apis/config"
+)
+
+// 校验插件参数,错误信息带有字段路径,例如 "cpuWeight: Invalid value: -1: must not be negative"
+
+// ValidateSampleArgs 校验 sample-plugin 的参数
+func ValidateSampleArgs(args *config.SampleArgs) error {
+	var allErrs field.ErrorList
+	if args.CPUWeight < 0 {
+		allErrs = append(allErrs, field.Invalid(field.NewPath("cpuWeight"), args.CPUWeight, "must not be negative"))
+	}
+	if args.MemoryWeight < 0 {
+		allErrs = append(allErrs, field.Invalid(field.NewPath("memoryWeight"), args.MemoryWeight, "must not be negative"))
+	}
+	if args.CPUWeight == 0 && args.MemoryWeight == 0 {
+		allErrs = append(allErrs, field.Invalid(field.NewPath("cpuWeight"), args.CPUWeight, "cpuWeight and memoryWeight must not both be zero"))
+	}
+	if args.MetricsStalenessSeconds <= 0 {
+		allErrs = append(allErrs, field.Invalid(field.NewPath("metricsStalenessSeconds"), args.MetricsStalenessSeconds, "must be greater than zero"))
+	}
+	allErrs = append(allErrs, validatePercentage(field.NewPath("cpuUtilizationThreshold"), args.CPUUtilizationThreshold)...)
+	allErrs = append(allErrs, validatePercentage(field.NewPath("memoryUtilizationThreshold"), args.MemoryUtilizationThreshold)...)
+	if name := args.ReservedResourceName; name != "" && !v1helper.IsExtendedResourceName(v1.ResourceName(name)) {
+		allErrs = append(allErrs, field.Invalid(field.NewPath("reservedResourceName"), name, "must be an extended resource name"))
+	}
+	switch args.PreemptionVictimOrder {
+	case config.VictimOrderYoungestFirst, config.VictimOrderFewestPDBViolations:
+	default:
+		allErrs = append(allErrs, field.NotSupported(field.NewPath("preemptionVictimOrder"), args.PreemptionVictimOrder,
+			[]string{string(config.VictimOrderYoungestFirst), string(config.VictimOrderFewestPDBViolations)}))
+	}
+	return allErrs.ToAggregate()
+}
+
+// ValidateCoschedulingArgs 校验 Coscheduling 插件的参数
+func ValidateCoschedulingArgs(args *config.CoschedulingArgs) error {
+	var allErrs field.ErrorList
+	if args.PermitWaitingTimeSeconds <= 0 {
+		allErrs = append(allErrs, field.Invalid(field.NewPath("permitWaitingTimeSeconds"), args.PermitWaitingTimeSeconds, "must be greater than zero"))
+	}
+	return allErrs.ToAggregate()
+}
+
+// ValidateElasticQuotaArgs 校验 ElasticQuota 插件的参数
+func ValidateElasticQuotaArgs(args *config.ElasticQuotaArgs) error {
+	var allErrs field.ErrorList
+	if args.KubeMaster != "" {
+		if u, err := url.Parse(args.KubeMaster); err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+			allErrs = append(allErrs, field.Invalid(field.NewPath("kubeMaster"), args.KubeMaster, "must be an http or https URL"))
+		}
+	}
+	return allErrs.ToAggregate()
+}
+
+// ValidateLabelTopologySpreadArgs 校验 LabelTopologySpread 插件的参数
+func ValidateLabelTopologySpreadArgs(args *config.LabelTopologySpreadArgs) error {
+	var allErrs field.ErrorList
+	topologyKeyPath := field.NewPath("topologyKey")
+	for _, msg := range validation.IsQualifiedName(args.TopologyKey) {
+		allErrs = append(allErrs, field.Invalid(topologyKeyPath, args.TopologyKey, msg))
+	}
+	if args.LabelSelector != nil {
+		allErrs = append(allErrs, metav1validation.ValidateLabelSelector(args.LabelSelector, field.NewPath("labelSelector"))...)
+	}
+	return allErrs.ToAggregate()
+}
+
+// 阈值是百分比,0 没有意义
+func validatePercentage(path *field.Path, value int64) field.ErrorList {
+	if value <= 0 || value > 100 {
+		return field.ErrorList{field.Invalid(path, value, "must be in the range (0, 100]")}
+	}
+	return nil
+}
@@ -0,0 +1,159 @@
+package v1alpha1
+
+import (
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const (
+	// GroupName crd 的 group
+	GroupName = "scheduling.sample.io"
+	// PodGroupLabel pod 通过这个 label 声明属于哪个 PodGroup,值是同一个 namespace 下的 PodGroup 名称
+	PodGroupLabel = "pod-group." + GroupName
+)
+
+// SchemeGroupVersion group version
+var SchemeGroupVersion = schema.GroupVersion{Group: GroupName, Version: "v1alpha1"}
+
+// PodGroupResource PodGroup 的 GroupVersionResource,动态客户端使用
+var PodGroupResource = SchemeGroupVersion.WithResource("podgroups")
+
+// ElasticQuotaResource ElasticQuota 的 GroupVersionResource,动态客户端使用
+var ElasticQuotaResource = SchemeGroupVersion.WithResource("elasticquotas")
+
+// PodGroup 一组需要同时调度的 pod,要么至少 minMember 个 pod 同时调度成功,要么都不调度
+type PodGroup struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec PodGroupSpec `json:"spec,omitempty"`
+}
+
+// PodGroupSpec PodGroup 的期望
+type PodGroupSpec struct {
+	// 至少需要同时调度的成员数量
+	MinMember int32 `json:"minMember"`
+	// 成员在 Permit 阶段等待其他成员的超时时间,为空使用插件配置的默认值
+	ScheduleTimeoutSeconds *int32 `json:"scheduleTimeoutSeconds,omitempty"`
+}
+
+// PodGroupList PodGroup 列表
+type PodGroupList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+
+	Items []PodGroup `json:"items"`
+}
+
+// DeepCopyInto 深拷贝到 out
+func (in *PodGroup) DeepCopyInto(out *PodGroup) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	out.Spec = in.Spec
+	if in.Spec.ScheduleTimeoutSeconds != nil {
+		t := *in.Spec.ScheduleTimeoutSeconds
+		out.Spec.ScheduleTimeoutSeconds = &t
+	}
+}
+
+// DeepCopy 深拷贝
+func (in *PodGroup) DeepCopy() *PodGroup {
+	if in == nil {
+		return nil
+	}
+	out := new(PodGroup)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject 实现 runtime.Object
+func (in *PodGroup) DeepCopyObject() runtime.Object {
+	return in.DeepCopy()
+}
+
+// DeepCopyObject 实现 runtime.Object
+func (in *PodGroupList) DeepCopyObject() runtime.Object {
+	if in == nil {
+		return nil
+	}
+	out := new(PodGroupList)
+	*out = *in
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		out.Items = make([]PodGroup, len(in.Items))
+		for i := range in.Items {
+			in.Items[i].DeepCopyInto(&out.Items[i])
+		}
+	}
+	return out
+}
+
+// ElasticQuota namespace 的弹性配额
+// namespace 的用量不超过 min 时一定可以调度,超过 min 时可以借用其他 namespace 没有用完的 min,但是不能超过 max
+// 被借用的 namespace 需要资源时会抢占借用的 pod
+type ElasticQuota struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec ElasticQuotaSpec `json:"spec,omitempty"`
+}
+
+// ElasticQuotaSpec ElasticQuota 的期望
+type ElasticQuotaSpec struct {
+	// 保证的资源量
+	Min v1.ResourceList `json:"min,omitempty"`
+	// 最多可以使用的资源量,没有设置的资源不限制
+	Max v1.ResourceList `json:"max,omitempty"`
+}
+
+// ElasticQuotaList ElasticQuota 列表
+type ElasticQuotaList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+
+	Items []ElasticQuota `json:"items"`
+}
+
+// DeepCopyInto 深拷贝到 out
+func (in *ElasticQuota) DeepCopyInto(out *ElasticQuota) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	out.Spec.Min = in.Spec.Min.DeepCopy()
+	out.Spec.Max = in.Spec.Max.DeepCopy()
+}
+
+// DeepCopy 深拷贝
+func (in *ElasticQuota) DeepCopy() *ElasticQuota {
+	if in == nil {
+		return nil
+	}
+	out := new(ElasticQuota)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject 实现 runtime.Object
+func (in *ElasticQuota) DeepCopyObject() runtime.Object {
+	return in.DeepCopy()
+}
+
+// DeepCopyObject 实现 runtime.Object
+func (in *ElasticQuotaList) DeepCopyObject() runtime.Object {
+	if in == nil {
+		return nil
+	}
+	out := new(ElasticQuotaList)
+	*out = *in
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		out.Items = make([]ElasticQuota, len(in.Items))
+		for i := range in.Items {
+			in.Items[i].DeepCopyInto(&out.Items[i])
+		}
+	}
+	return out
+}
@@ -0,0 +1,266 @@
+package coscheduling
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/klog/v2"
+	podutil "k8s.io/kubernetes/pkg/api/v1/pod"
+	frameworkruntime "k8s.io/kubernetes/pkg/scheduler/framework/runtime"
+	framework "k8s.io/kubernetes/pkg/scheduler/framework/v1alpha1"
+
+	pluginconfig "schedulePlugin/apis/config"
+	configscheme "schedulePlugin/apis/config/scheme"
+	"schedulePlugin/apis/config/validation"
+	"schedulePlugin/apis/scheduling/v1alpha1"
+)
+
+// Name 定义插件名称
+const Name = "Coscheduling"
+
+// gang scheduling
+// 同一个 PodGroup 的 pod 要么至少 minMember 个同时调度成功,要么都不调度
+// queueSort 让同一个 PodGroup 的 pod 在队列中相邻
+// preFilter 在 PodGroup 的 pod 数量不够 minMember 时直接拒绝
+// permit 让 pod 等待,直到 minMember 个成员都 assume 之后一起放行,超时后 unreserve 拒绝所有等待的成员
+var _ framework.QueueSortPlugin = &Coscheduling{}
+var _ framework.PreFilterPlugin = &Coscheduling{}
+var _ framework.PermitPlugin = &Coscheduling{}
+var _ framework.ReservePlugin = &Coscheduling{}
+
+// Coscheduling 插件
+type Coscheduling struct {
+	args      *pluginconfig.CoschedulingArgs
+	handle    framework.FrameworkHandle
+	podLister corelisters.PodLister
+	pgLister  PodGroupLister
+}
+
+func (cs *Coscheduling) Name() string {
+	return Name
+}
+
+// 初始化插件
+func New(object runtime.Object, f framework.FrameworkHandle) (framework.Plugin, error) {
+	args, err := getCoschedulingArgs(object)
+	if err != nil {
+		return nil, err
+	}
+	// PodGroup 是 crd,scheduler 自带的 clientset 读不到,用动态客户端
+	restConfig, err := clientcmd.BuildConfigFromFlags(args.KubeMaster, args.KubeConfigPath)
+	if err != nil {
+		return nil, err
+	}
+	client, err := dynamic.NewForConfig(restConfig)
+	if err != nil {
+		return nil, err
+	}
+	pgLister, err := NewPodGroupLister(client, wait.NeverStop)
+	if err != nil {
+		return nil, err
+	}
+	return newCoscheduling(args, f, pgLister)
+}
+
+// NewWithClient 返回通过给定的动态客户端读取 PodGroup 的插件工厂,忽略参数中的 kubeconfig,离线模拟时使用
+func NewWithClient(client dynamic.Interface, stopCh <-chan struct{}) frameworkruntime.PluginFactory {
+	return func(object runtime.Object, f framework.FrameworkHandle) (framework.Plugin, error) {
+		args, err := getCoschedulingArgs(object)
+		if err != nil {
+			return nil, err
+		}
+		pgLister, err := NewPodGroupLister(client, stopCh)
+		if err != nil {
+			return nil, err
+		}
+		return newCoscheduling(args, f, pgLister)
+	}
+}
+
+func getCoschedulingArgs(object runtime.Object) (*pluginconfig.CoschedulingArgs, error) {
+	args := &pluginconfig.CoschedulingArgs{}
+	if err := configscheme.DecodeInto(object, args); err != nil {
+		return nil, err
+	}
+	if err := validation.ValidateCoschedulingArgs(args); err != nil {
+		return nil, fmt.Errorf("invalid %s args: %v", Name, err)
+	}
+	return args, nil
+}
+
+func newCoscheduling(args *pluginconfig.CoschedulingArgs, f framework.FrameworkHandle, pgLister PodGroupLister) (*Coscheduling, error) {
+	if klog.V(2).Enabled() {
+		klog.InfoS("Successfully get plugin config args", "plugin", Name, "args", args)
+	}
+	return &Coscheduling{
+		args:      args,
+		handle:    f,
+		podLister: f.SharedInformerFactory().Core().V1().Pods().Lister(),
+		pgLister:  pgLister,
+	}, nil
+}
+
+// pod 所属的 PodGroup 名称,不属于任何 PodGroup 返回空字符串
+func podGroupName(pod *v1.Pod) string {
+	return pod.Labels[v1alpha1.PodGroupLabel]
+}
+
+// 优先级高的在前,优先级相同时按 PodGroup 的创建时间排序,同一个 PodGroup 的 pod 排在一起
+func (cs *Coscheduling) Less(podInfo1, podInfo2 *framework.QueuedPodInfo) bool {
+	prio1, prio2 := podutil.GetPodPriority(podInfo1.Pod), podutil.GetPodPriority(podInfo2.Pod)
+	if prio1 != prio2 {
+		return prio1 > prio2
+	}
+	t1, t2 := cs.queueTimestamp(podInfo1), cs.queueTimestamp(podInfo2)
+	if !t1.Equal(t2) {
+		return t1.Before(t2)
+	}
+	return queueKey(podInfo1.Pod) < queueKey(podInfo2.Pod)
+}
+
+// PodGroup 的成员使用 PodGroup 的创建时间,其他 pod 使用第一次入队的时间
+func (cs *Coscheduling) queueTimestamp(podInfo *framework.QueuedPodInfo) time.Time {
+	if name := podGroupName(podInfo.Pod); name != "" {
+		if pg, err := cs.pgLister.Get(podInfo.Pod.Namespace, name); err == nil {
+			return pg.CreationTimestamp.Time
+		}
+	}
+	return podInfo.InitialAttemptTimestamp
+}
+
+func queueKey(pod *v1.Pod) string {
+	if name := podGroupName(pod); name != "" {
+		return pod.Namespace + "/" + name
+	}
+	return pod.Namespace + "/" + pod.Name
+}
+
+// 获取 pod 所属的 PodGroup,不存在时返回 UnschedulableAndUnresolvable
+func (cs *Coscheduling) getPodGroup(pod *v1.Pod, name string) (*v1alpha1.PodGroup, *framework.Status) {
+	pg, err := cs.pgLister.Get(pod.Namespace, name)
+	if errors.IsNotFound(err) {
+		return nil, framework.NewStatus(framework.UnschedulableAndUnresolvable, fmt.Sprintf("PodGroup %s/%s not found", pod.Namespace, name))
+	}
+	if err != nil {
+		return nil, framework.NewStatus(framework.Error, err.Error())
+	}
+	return pg, nil
+}
+
+func (cs *Coscheduling) PreFilter(ctx context.Context, state *framework.CycleState, pod *v1.Pod) *framework.Status {
+	name := podGroupName(pod)
+	if name == "" {
+		return nil
+	}
+	pg, status := cs.getPodGroup(pod, name)
+	if status != nil {
+		return status
+	}
+
+	// PodGroup 的 pod 还没有全部创建出来,调度了也凑不够 minMember
+	// 已经结束的 pod 不会再运行,不算作成员
+	selector := labels.SelectorFromSet(labels.Set{v1alpha1.PodGroupLabel: name})
+	pods, err := cs.podLister.Pods(pod.Namespace).List(selector)
+	if err != nil {
+		return framework.NewStatus(framework.Error, err.Error())
+	}
+	var members int32
+	for _, p := range pods {
+		if p.Status.Phase != v1.PodSucceeded && p.Status.Phase != v1.PodFailed {
+			members++
+		}
+	}
+	if members < pg.Spec.MinMember {
+		return framework.NewStatus(framework.Unschedulable,
+			fmt.Sprintf("PodGroup %s/%s has %d pods, less than minMember %d", pod.Namespace, name, members, pg.Spec.MinMember))
+	}
+	return nil
+}
+
+func (cs *Coscheduling) PreFilterExtensions() framework.PreFilterExtensions {
+	return nil
+}
+
+func (cs *Coscheduling) Permit(ctx context.Context, state *framework.CycleState, pod *v1.Pod, nodeName string) (*framework.Status, time.Duration) {
+	name := podGroupName(pod)
+	if name == "" {
+		return nil, 0
+	}
+	pg, status := cs.getPodGroup(pod, name)
+	if status != nil {
+		return status, 0
+	}
+
+	// 已经 assume 或者绑定的成员加上当前的 pod
+	assigned := cs.countAssignedPods(pod, name) + 1
+	if assigned < pg.Spec.MinMember {
+		if klog.V(3).Enabled() {
+			klog.InfoS("Pod is waiting for the rest of its PodGroup", "pod", pod.Name, "podGroup", name, "assigned", assigned, "minMember", pg.Spec.MinMember)
+		}
+		return framework.NewStatus(framework.Wait, ""), cs.waitingTime(pg)
+	}
+
+	// 凑够了 minMember,放行所有等待中的成员
+	cs.handle.IterateOverWaitingPods(func(waitingPod framework.WaitingPod) {
+		if p := waitingPod.GetPod(); p.Namespace == pod.Namespace && podGroupName(p) == name {
+			waitingPod.Allow(Name)
+		}
+	})
+	if klog.V(3).Enabled() {
+		klog.InfoS("PodGroup reached minMember, allow waiting pods", "podGroup", name, "minMember", pg.Spec.MinMember)
+	}
+	return nil, 0
+}
+
+// 统计调度快照中同一个 PodGroup 已经 assume 或者绑定到节点上的 pod
+func (cs *Coscheduling) countAssignedPods(pod *v1.Pod, name string) int32 {
+	nodeInfos, err := cs.handle.SnapshotSharedLister().NodeInfos().List()
+	if err != nil {
+		klog.ErrorS(err, "Failed to list nodes from snapshot")
+		return 0
+	}
+	var count int32
+	for _, nodeInfo := range nodeInfos {
+		for _, podInfo := range nodeInfo.Pods {
+			p := podInfo.Pod
+			if p.UID != pod.UID && p.Namespace == pod.Namespace && podGroupName(p) == name {
+				count++
+			}
+		}
+	}
+	return count
+}
+
+func (cs *Coscheduling) waitingTime(pg *v1alpha1.PodGroup) time.Duration {
+	if pg.Spec.ScheduleTimeoutSeconds != nil {
+		return time.Duration(*pg.Spec.ScheduleTimeoutSeconds) * time.Second
+	}
+	return time.Duration(cs.args.PermitWaitingTimeSeconds) * time.Second
+}
+
+func (cs *Coscheduling) Reserve(ctx context.Context, state *framework.CycleState, pod *v1.Pod, nodeName string) *framework.Status {
+	return nil
+}
+
+// 成员 Permit 超时或者被拒绝时会调用 Unreserve,这时拒绝同一个 PodGroup 所有等待中的成员
+func (cs *Coscheduling) Unreserve(ctx context.Context, state *framework.CycleState, pod *v1.Pod, nodeName string) {
+	name := podGroupName(pod)
+	if name == "" {
+		return
+	}
+	cs.handle.IterateOverWaitingPods(func(waitingPod framework.WaitingPod) {
+		if p := waitingPod.GetPod(); p.Namespace == pod.Namespace && podGroupName(p) == name {
+			klog.InfoS("Reject waiting pod of the PodGroup", "pod", p.Name, "podGroup", name, "unreservedPod", pod.Name)
+			waitingPod.Reject(fmt.Sprintf("PodGroup %s/%s rejected because pod %s was unreserved", pod.Namespace, name, pod.Name))
+		}
+	})
+}
@@ -0,0 +1,214 @@
+package coscheduling
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/informers"
+	clientsetfake "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/kubernetes/pkg/scheduler/apis/config"
+	frameworkruntime "k8s.io/kubernetes/pkg/scheduler/framework/runtime"
+	framework "k8s.io/kubernetes/pkg/scheduler/framework/v1alpha1"
+
+	pluginconfig "schedulePlugin/apis/config"
+	configv1beta1 "schedulePlugin/apis/config/v1beta1"
+	"schedulePlugin/apis/scheduling/v1alpha1"
+	"schedulePlugin/fake"
+)
+
+func makePodGroup(name string, minMember int32, created time.Time) *v1alpha1.PodGroup {
+	return &v1alpha1.PodGroup{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", CreationTimestamp: metav1.NewTime(created)},
+		Spec:       v1alpha1.PodGroupSpec{MinMember: minMember},
+	}
+}
+
+func makePod(name, group string) *v1.Pod {
+	pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", UID: types.UID(name)}}
+	if group != "" {
+		pod.Labels = map[string]string{v1alpha1.PodGroupLabel: group}
+	}
+	return pod
+}
+
+// testFramework 运行 Coscheduling 插件的 framework,pod 从 fake clientset 的 informer 中读取
+type testFramework struct {
+	framework.Framework
+	plugin   *Coscheduling
+	snapshot *fake.SharedLister
+}
+
+func newTestFramework(t *testing.T, pgLister PodGroupLister, pods []*v1.Pod) *testFramework {
+	t.Helper()
+	var objs []runtime.Object
+	for _, pod := range pods {
+		objs = append(objs, pod)
+	}
+	informerFactory := informers.NewSharedInformerFactory(clientsetfake.NewSimpleClientset(objs...), 0)
+	snapshot := fake.NewSharedLister([]*v1.Node{{ObjectMeta: metav1.ObjectMeta{Name: "node-1"}}}, nil)
+
+	tf := &testFramework{snapshot: snapshot}
+	registry := frameworkruntime.Registry{
+		Name: func(_ runtime.Object, f framework.FrameworkHandle) (framework.Plugin, error) {
+			p, err := newCoscheduling(&pluginconfig.CoschedulingArgs{PermitWaitingTimeSeconds: configv1beta1.DefaultPermitWaitingTimeSeconds}, f, pgLister)
+			tf.plugin = p
+			return p, err
+		},
+	}
+	plugins := &config.Plugins{
+		QueueSort: &config.PluginSet{Enabled: []config.Plugin{{Name: Name}}},
+		PreFilter: &config.PluginSet{Enabled: []config.Plugin{{Name: Name}}},
+		Reserve:   &config.PluginSet{Enabled: []config.Plugin{{Name: Name}}},
+		Permit:    &config.PluginSet{Enabled: []config.Plugin{{Name: Name}}},
+	}
+	fwk, err := fake.NewFramework(registry, plugins,
+		frameworkruntime.WithInformerFactory(informerFactory),
+		frameworkruntime.WithSnapshotSharedLister(snapshot))
+	if err != nil {
+		t.Fatalf("NewFramework: %v", err)
+	}
+	tf.Framework = fwk
+
+	stopCh := make(chan struct{})
+	t.Cleanup(func() { close(stopCh) })
+	informerFactory.Start(stopCh)
+	informerFactory.WaitForCacheSync(stopCh)
+	return tf
+}
+
+// 模拟 Permit 之前的 assume,pod 出现在调度快照中
+func (tf *testFramework) assume(pod *v1.Pod) {
+	assumed := pod.DeepCopy()
+	assumed.Spec.NodeName = "node-1"
+	tf.snapshot.AddPod(assumed)
+}
+
+func TestLess(t *testing.T) {
+	now := time.Now()
+	pgLister := NewFakePodGroupLister(makePodGroup("early", 2, now.Add(-time.Hour)), makePodGroup("late", 2, now))
+	tf := newTestFramework(t, pgLister, nil)
+
+	queued := func(pod *v1.Pod, ts time.Time) *framework.QueuedPodInfo {
+		return &framework.QueuedPodInfo{Pod: pod, InitialAttemptTimestamp: ts}
+	}
+	high := makePod("high", "late")
+	priority := int32(100)
+	high.Spec.Priority = &priority
+
+	tests := []struct {
+		name string
+		a, b *framework.QueuedPodInfo
+		want bool
+	}{
+		{"higher priority first", queued(high, now), queued(makePod("p", "early"), now), true},
+		{"earlier PodGroup first", queued(makePod("a", "early"), now), queued(makePod("b", "late"), now.Add(-2*time.Hour)), true},
+		{"pod without group by first attempt", queued(makePod("a", ""), now.Add(-2*time.Hour)), queued(makePod("b", "early"), now), true},
+		{"ties are broken by group key", queued(makePod("a", ""), now), queued(makePod("b", "late"), now), true},
+	}
+	for _, tt := range tests {
+		if got := tf.plugin.Less(tt.a, tt.b); got != tt.want {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestPreFilter(t *testing.T) {
+	pgLister := NewFakePodGroupLister(makePodGroup("pg", 3, time.Now()))
+	// 已经结束的成员不算
+	done := makePod("done", "pg")
+	done.Status.Phase = v1.PodSucceeded
+	pods := []*v1.Pod{makePod("p1", "pg"), makePod("p2", "pg"), done}
+	tf := newTestFramework(t, pgLister, pods)
+	ctx := context.Background()
+
+	if st := tf.RunPreFilterPlugins(ctx, framework.NewCycleState(), makePod("alone", "")); !st.IsSuccess() {
+		t.Errorf("expected pod without group to pass, got %v", st)
+	}
+	if st := tf.RunPreFilterPlugins(ctx, framework.NewCycleState(), makePod("orphan", "missing")); st.Code() != framework.UnschedulableAndUnresolvable {
+		t.Errorf("expected UnschedulableAndUnresolvable for a missing PodGroup, got %v", st)
+	}
+	if st := tf.RunPreFilterPlugins(ctx, framework.NewCycleState(), pods[0]); st.Code() != framework.Unschedulable {
+		t.Errorf("expected Unschedulable with 2 of 3 members, got %v", st)
+	}
+
+	pgLister.Add(makePodGroup("pg", 2, time.Now()))
+	if st := tf.RunPreFilterPlugins(ctx, framework.NewCycleState(), pods[0]); !st.IsSuccess() {
+		t.Errorf("expected success with enough members, got %v", st)
+	}
+}
+
+func TestPermitAllowsWholeGroup(t *testing.T) {
+	pgLister := NewFakePodGroupLister(makePodGroup("pg", 2, time.Now()))
+	p1, p2 := makePod("p1", "pg"), makePod("p2", "pg")
+	tf := newTestFramework(t, pgLister, []*v1.Pod{p1, p2})
+	ctx := context.Background()
+
+	if st := tf.RunPermitPlugins(ctx, framework.NewCycleState(), p1, "node-1"); st.Code() != framework.Wait {
+		t.Fatalf("expected first member to wait, got %v", st)
+	}
+	if tf.GetWaitingPod(p1.UID) == nil {
+		t.Fatalf("expected p1 to be waiting")
+	}
+	tf.assume(p1)
+
+	if st := tf.RunPermitPlugins(ctx, framework.NewCycleState(), p2, "node-1"); !st.IsSuccess() {
+		t.Fatalf("expected last member to be allowed, got %v", st)
+	}
+	if st := tf.WaitOnPermit(ctx, p1); !st.IsSuccess() {
+		t.Errorf("expected waiting member to be allowed, got %v", st)
+	}
+}
+
+func TestUnreserveRejectsWholeGroup(t *testing.T) {
+	pgLister := NewFakePodGroupLister(makePodGroup("pg", 3, time.Now()))
+	p1, p2, other := makePod("p1", "pg"), makePod("p2", "pg"), makePod("other", "other")
+	pgLister.Add(makePodGroup("other", 2, time.Now()))
+	tf := newTestFramework(t, pgLister, []*v1.Pod{p1, p2, other})
+	ctx := context.Background()
+
+	for _, pod := range []*v1.Pod{p1, p2, other} {
+		if st := tf.RunPermitPlugins(ctx, framework.NewCycleState(), pod, "node-1"); st.Code() != framework.Wait {
+			t.Fatalf("expected %s to wait, got %v", pod.Name, st)
+		}
+		tf.assume(pod)
+	}
+
+	// p1 超时后 framework 调用 Unreserve,同组等待中的 pod 全部被拒绝
+	tf.RunReservePluginsUnreserve(ctx, framework.NewCycleState(), p1, "node-1")
+	for _, pod := range []*v1.Pod{p1, p2} {
+		st := tf.WaitOnPermit(ctx, pod)
+		if st.Code() != framework.Unschedulable || !strings.Contains(st.Message(), "unreserved") {
+			t.Errorf("expected %s to be rejected, got %v", pod.Name, st)
+		}
+	}
+	if tf.GetWaitingPod(other.UID) == nil {
+		t.Errorf("expected pod of another group to keep waiting")
+	}
+	tf.RejectWaitingPod(other.UID)
+}
+
+func TestPermitTimeout(t *testing.T) {
+	pg := makePodGroup("pg", 2, time.Now())
+	timeout := int32(1)
+	pg.Spec.ScheduleTimeoutSeconds = &timeout
+	p1 := makePod("p1", "pg")
+	tf := newTestFramework(t, NewFakePodGroupLister(pg), []*v1.Pod{p1, makePod("p2", "pg")})
+	ctx := context.Background()
+
+	if st := tf.RunPermitPlugins(ctx, framework.NewCycleState(), p1, "node-1"); st.Code() != framework.Wait {
+		t.Fatalf("expected p1 to wait, got %v", st)
+	}
+	start := time.Now()
+	if st := tf.WaitOnPermit(ctx, p1); st.Code() != framework.Unschedulable {
+		t.Errorf("expected p1 to be rejected after the timeout, got %v", st)
+	}
+	if elapsed := time.Since(start); elapsed < 500*time.Millisecond {
+		t.Errorf("expected p1 to wait for the PodGroup timeout, waited %v", elapsed)
+	}
+}
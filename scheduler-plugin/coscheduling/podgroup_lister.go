@@ -0,0 +1,121 @@
+package coscheduling
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/tools/cache"
+
+	"schedulePlugin/apis/scheduling/v1alpha1"
+)
+
+// 等待 PodGroup 缓存同步的时间,超时一般是 crd 没有安装
+const podGroupCacheSyncTimeout = 30 * time.Second
+
+// PodGroupLister 读取 PodGroup,不存在时返回 NotFound 错误
+type PodGroupLister interface {
+	Get(namespace, name string) (*v1alpha1.PodGroup, error)
+}
+
+// podGroupLister 通过动态客户端的 informer 缓存读取 PodGroup
+// queue sort 每次比较都会读取 PodGroup,转换后的对象按 resourceVersion 缓存,PodGroup 没有变化时不再转换
+type podGroupLister struct {
+	lister cache.GenericLister
+
+	mutex sync.RWMutex
+	// namespace/name -> 转换后的 PodGroup
+	converted map[string]*v1alpha1.PodGroup
+}
+
+// NewPodGroupLister 启动 PodGroup 的 informer 并等待缓存同步
+func NewPodGroupLister(client dynamic.Interface, stopCh <-chan struct{}) (PodGroupLister, error) {
+	factory := dynamicinformer.NewDynamicSharedInformerFactory(client, 0)
+	informer := factory.ForResource(v1alpha1.PodGroupResource)
+	factory.Start(stopCh)
+
+	synced := make(chan struct{})
+	go func() {
+		defer close(synced)
+		cache.WaitForCacheSync(stopCh, informer.Informer().HasSynced)
+	}()
+	select {
+	case <-synced:
+	case <-time.After(podGroupCacheSyncTimeout):
+		return nil, fmt.Errorf("timed out waiting for %s cache to sync, is the PodGroup CRD installed?", v1alpha1.PodGroupResource)
+	}
+	return &podGroupLister{lister: informer.Lister(), converted: map[string]*v1alpha1.PodGroup{}}, nil
+}
+
+// 返回的 PodGroup 是共享的,调用方不能修改
+func (l *podGroupLister) Get(namespace, name string) (*v1alpha1.PodGroup, error) {
+	key := namespace + "/" + name
+	obj, err := l.lister.ByNamespace(namespace).Get(name)
+	if errors.IsNotFound(err) {
+		l.mutex.Lock()
+		delete(l.converted, key)
+		l.mutex.Unlock()
+	}
+	if err != nil {
+		return nil, err
+	}
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return nil, fmt.Errorf("unexpected object type %T for PodGroup %s/%s", obj, namespace, name)
+	}
+
+	resourceVersion := u.GetResourceVersion()
+	l.mutex.RLock()
+	pg, ok := l.converted[key]
+	l.mutex.RUnlock()
+	if ok && resourceVersion != "" && pg.ResourceVersion == resourceVersion {
+		return pg, nil
+	}
+
+	pg = &v1alpha1.PodGroup{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(u.UnstructuredContent(), pg); err != nil {
+		return nil, err
+	}
+	l.mutex.Lock()
+	l.converted[key] = pg
+	l.mutex.Unlock()
+	return pg, nil
+}
+
+// FakePodGroupLister 在内存中保存 PodGroup,用于单元测试
+type FakePodGroupLister struct {
+	mutex sync.RWMutex
+	// namespace/name -> PodGroup
+	PodGroups map[string]*v1alpha1.PodGroup
+}
+
+// NewFakePodGroupLister 实例化一个 fake PodGroupLister
+func NewFakePodGroupLister(pgs ...*v1alpha1.PodGroup) *FakePodGroupLister {
+	l := &FakePodGroupLister{PodGroups: map[string]*v1alpha1.PodGroup{}}
+	for _, pg := range pgs {
+		l.Add(pg)
+	}
+	return l
+}
+
+// Add 添加或者更新一个 PodGroup
+func (l *FakePodGroupLister) Add(pg *v1alpha1.PodGroup) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.PodGroups[pg.Namespace+"/"+pg.Name] = pg
+}
+
+func (l *FakePodGroupLister) Get(namespace, name string) (*v1alpha1.PodGroup, error) {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+	pg, ok := l.PodGroups[namespace+"/"+name]
+	if !ok {
+		return nil, errors.NewNotFound(v1alpha1.PodGroupResource.GroupResource(), name)
+	}
+	return pg, nil
+}
@@ -0,0 +1,70 @@
+package coscheduling
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+
+	"schedulePlugin/apis/scheduling/v1alpha1"
+)
+
+func makeUnstructuredPodGroup(t *testing.T, minMember int32, resourceVersion string) *unstructured.Unstructured {
+	t.Helper()
+	pg := makePodGroup("pg", minMember, metav1.Now().Time)
+	pg.TypeMeta = metav1.TypeMeta{APIVersion: v1alpha1.SchemeGroupVersion.String(), Kind: "PodGroup"}
+	pg.ResourceVersion = resourceVersion
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(pg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &unstructured.Unstructured{Object: content}
+}
+
+// resourceVersion 不变时复用转换后的 PodGroup
+func TestPodGroupListerCachesConversion(t *testing.T) {
+	client := dynamicfake.NewSimpleDynamicClient(runtime.NewScheme(), makeUnstructuredPodGroup(t, 2, "1"))
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	lister, err := NewPodGroupLister(client, stopCh)
+	if err != nil {
+		t.Fatalf("NewPodGroupLister: %v", err)
+	}
+
+	first, err := lister.Get("default", "pg")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if again, err := lister.Get("default", "pg"); err != nil || again != first {
+		t.Errorf("expected the cached PodGroup, got %p, %v", again, err)
+	}
+
+	resource := client.Resource(v1alpha1.PodGroupResource).Namespace("default")
+	if _, err := resource.Update(context.Background(), makeUnstructuredPodGroup(t, 3, "2"), metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	err = wait.PollImmediate(10*time.Millisecond, wait.ForeverTestTimeout, func() (bool, error) {
+		pg, err := lister.Get("default", "pg")
+		return err == nil && pg.Spec.MinMember == 3, nil
+	})
+	if err != nil {
+		t.Errorf("expected the updated PodGroup: %v", err)
+	}
+
+	if err := resource.Delete(context.Background(), "pg", metav1.DeleteOptions{}); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	err = wait.PollImmediate(10*time.Millisecond, wait.ForeverTestTimeout, func() (bool, error) {
+		_, err := lister.Get("default", "pg")
+		return errors.IsNotFound(err), nil
+	})
+	if err != nil {
+		t.Errorf("expected NotFound after the PodGroup is deleted: %v", err)
+	}
+}
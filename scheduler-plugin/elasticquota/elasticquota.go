@@ -0,0 +1,389 @@
+package elasticquota
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/klog/v2"
+	frameworkruntime "k8s.io/kubernetes/pkg/scheduler/framework/runtime"
+	framework "k8s.io/kubernetes/pkg/scheduler/framework/v1alpha1"
+
+	pluginconfig "schedulePlugin/apis/config"
+	configscheme "schedulePlugin/apis/config/scheme"
+	"schedulePlugin/apis/config/validation"
+	"schedulePlugin/apis/scheduling/v1alpha1"
+	"schedulePlugin/preemption"
+)
+
+const (
+	// Name 定义插件名称
+	Name = "ElasticQuota"
+	// 等待 ElasticQuota 缓存同步的时间,超时一般是 crd 没有安装
+	quotaCacheSyncTimeout = 30 * time.Second
+)
+
+// 弹性配额
+// 每个 namespace 的 ElasticQuota 有 min 和 max
+// preFilter: 用量超过 max 拒绝;超过 min 时只有所有 namespace 的 min 加起来还有剩余才能借用
+// postFilter: 没有超过 min 的 pod 调度不上时,抢占正在借用资源的 namespace 的 pod
+// reserve: 调度成功的 pod 立即计入用量,不用等 informer 的事件
+// 用量通过 FrameworkHandle 的 pod informer 维护,启动时 informer 的全量 add 事件重建用量
+var _ framework.PreFilterPlugin = &ElasticQuota{}
+var _ framework.PostFilterPlugin = &ElasticQuota{}
+var _ framework.ReservePlugin = &ElasticQuota{}
+
+// ElasticQuota 插件
+type ElasticQuota struct {
+	handle framework.FrameworkHandle
+
+	mutex sync.RWMutex
+	// namespace -> 配额和用量
+	quotas map[string]*quotaInfo
+}
+
+func (eq *ElasticQuota) Name() string {
+	return Name
+}
+
+// 初始化插件
+func New(object runtime.Object, f framework.FrameworkHandle) (framework.Plugin, error) {
+	args, err := getElasticQuotaArgs(object)
+	if err != nil {
+		return nil, err
+	}
+	// ElasticQuota 是 crd,和 PodGroup 一样用动态客户端
+	restConfig, err := clientcmd.BuildConfigFromFlags(args.KubeMaster, args.KubeConfigPath)
+	if err != nil {
+		return nil, err
+	}
+	client, err := dynamic.NewForConfig(restConfig)
+	if err != nil {
+		return nil, err
+	}
+	return newElasticQuota(f, client, wait.NeverStop)
+}
+
+// NewWithClient 返回通过给定的动态客户端读取 ElasticQuota 的插件工厂,忽略参数中的 kubeconfig,离线模拟时使用
+func NewWithClient(client dynamic.Interface, stopCh <-chan struct{}) frameworkruntime.PluginFactory {
+	return func(object runtime.Object, f framework.FrameworkHandle) (framework.Plugin, error) {
+		if _, err := getElasticQuotaArgs(object); err != nil {
+			return nil, err
+		}
+		return newElasticQuota(f, client, stopCh)
+	}
+}
+
+func getElasticQuotaArgs(object runtime.Object) (*pluginconfig.ElasticQuotaArgs, error) {
+	args := &pluginconfig.ElasticQuotaArgs{}
+	if err := configscheme.DecodeInto(object, args); err != nil {
+		return nil, err
+	}
+	if err := validation.ValidateElasticQuotaArgs(args); err != nil {
+		return nil, fmt.Errorf("invalid %s args: %v", Name, err)
+	}
+	return args, nil
+}
+
+func newElasticQuota(f framework.FrameworkHandle, client dynamic.Interface, stopCh <-chan struct{}) (*ElasticQuota, error) {
+	eq := &ElasticQuota{
+		handle: f,
+		quotas: map[string]*quotaInfo{},
+	}
+
+	// scheduler 在插件初始化之后才启动 informer,这里注册的 handler 会收到所有已有 pod 的 add 事件
+	f.SharedInformerFactory().Core().V1().Pods().Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    eq.onPodAdd,
+		UpdateFunc: eq.onPodUpdate,
+		DeleteFunc: eq.onPodDelete,
+	})
+
+	factory := dynamicinformer.NewDynamicSharedInformerFactory(client, 0)
+	informer := factory.ForResource(v1alpha1.ElasticQuotaResource).Informer()
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    eq.onQuotaAdd,
+		UpdateFunc: func(_, newObj interface{}) { eq.onQuotaAdd(newObj) },
+		DeleteFunc: eq.onQuotaDelete,
+	})
+	factory.Start(stopCh)
+
+	synced := make(chan struct{})
+	go func() {
+		defer close(synced)
+		cache.WaitForCacheSync(stopCh, informer.HasSynced)
+	}()
+	select {
+	case <-synced:
+	case <-time.After(quotaCacheSyncTimeout):
+		return nil, fmt.Errorf("timed out waiting for %s cache to sync, is the ElasticQuota CRD installed?", v1alpha1.ElasticQuotaResource)
+	}
+	return eq, nil
+}
+
+// 调用方持有锁
+func (eq *ElasticQuota) quotaInfo(namespace string) *quotaInfo {
+	q, ok := eq.quotas[namespace]
+	if !ok {
+		q = newQuotaInfo()
+		eq.quotas[namespace] = q
+	}
+	return q
+}
+
+// 已经调度到节点上并且没有结束的 pod 才占用配额
+func countsTowardsQuota(pod *v1.Pod) bool {
+	return pod.Spec.NodeName != "" && !isTerminated(pod)
+}
+
+func isTerminated(pod *v1.Pod) bool {
+	return pod.Status.Phase == v1.PodSucceeded || pod.Status.Phase == v1.PodFailed
+}
+
+func (eq *ElasticQuota) onPodAdd(obj interface{}) {
+	pod, ok := obj.(*v1.Pod)
+	if !ok || !countsTowardsQuota(pod) {
+		return
+	}
+	eq.mutex.Lock()
+	defer eq.mutex.Unlock()
+	eq.quotaInfo(pod.Namespace).addPod(pod)
+}
+
+func (eq *ElasticQuota) onPodUpdate(_, newObj interface{}) {
+	pod, ok := newObj.(*v1.Pod)
+	if !ok {
+		return
+	}
+	eq.mutex.Lock()
+	defer eq.mutex.Unlock()
+	// 还没有 bind 的 pod 可能已经 Reserve 了用量,在 Permit 等待或者 bind 时也会有更新事件,
+	// 只有结束的 pod 才移除用量,Reserve 之后调度失败由 Unreserve 移除
+	switch {
+	case isTerminated(pod):
+		eq.quotaInfo(pod.Namespace).removePod(pod.UID)
+	case countsTowardsQuota(pod):
+		eq.quotaInfo(pod.Namespace).addPod(pod)
+	}
+}
+
+func (eq *ElasticQuota) onPodDelete(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	pod, ok := obj.(*v1.Pod)
+	if !ok {
+		return
+	}
+	eq.mutex.Lock()
+	defer eq.mutex.Unlock()
+	eq.quotaInfo(pod.Namespace).removePod(pod.UID)
+}
+
+func (eq *ElasticQuota) onQuotaAdd(obj interface{}) {
+	quota, err := toElasticQuota(obj)
+	if err != nil {
+		klog.ErrorS(err, "Failed to convert ElasticQuota")
+		return
+	}
+	eq.mutex.Lock()
+	defer eq.mutex.Unlock()
+	q := eq.quotaInfo(quota.Namespace)
+	q.hasQuota = true
+	q.min = quota.Spec.Min
+	q.max = quota.Spec.Max
+}
+
+func (eq *ElasticQuota) onQuotaDelete(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	quota, err := toElasticQuota(obj)
+	if err != nil {
+		klog.ErrorS(err, "Failed to convert ElasticQuota")
+		return
+	}
+	eq.mutex.Lock()
+	defer eq.mutex.Unlock()
+	q := eq.quotaInfo(quota.Namespace)
+	q.hasQuota = false
+	q.min, q.max = nil, nil
+}
+
+func toElasticQuota(obj interface{}) (*v1alpha1.ElasticQuota, error) {
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return nil, fmt.Errorf("unexpected object type %T for ElasticQuota", obj)
+	}
+	quota := &v1alpha1.ElasticQuota{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(u.UnstructuredContent(), quota); err != nil {
+		return nil, err
+	}
+	return quota, nil
+}
+
+// 用量超过 max 拒绝
+// 用量超过 min 时借用其他 namespace 的资源,所有有配额的 namespace 的用量加起来不能超过 min 的总和
+func (eq *ElasticQuota) PreFilter(ctx context.Context, state *framework.CycleState, pod *v1.Pod) *framework.Status {
+	eq.mutex.RLock()
+	defer eq.mutex.RUnlock()
+
+	q, ok := eq.quotas[pod.Namespace]
+	if !ok || !q.hasQuota {
+		return framework.NewStatus(framework.Success, "")
+	}
+	requests := podRequests(pod)
+	if name, ok := exceeded(q.used, requests, q.max); !ok {
+		return framework.NewStatus(framework.Unschedulable,
+			fmt.Sprintf("namespace %s 的 %s 用量将超过 ElasticQuota 的 max", pod.Namespace, name))
+	}
+	if fitsWithin(q.used, requests, q.min) {
+		return framework.NewStatus(framework.Success, "")
+	}
+
+	totalUsed, totalMin := v1.ResourceList{}, v1.ResourceList{}
+	for _, info := range eq.quotas {
+		if !info.hasQuota {
+			continue
+		}
+		addResourceList(totalUsed, info.used)
+		addResourceList(totalMin, info.min)
+	}
+	if name, ok := exceeded(totalUsed, requests, totalMin); !ok {
+		return framework.NewStatus(framework.Unschedulable,
+			fmt.Sprintf("namespace %s 的 %s 超过 min,其他 ElasticQuota 没有可以借用的 %s", pod.Namespace, name, name))
+	}
+	return framework.NewStatus(framework.Success, "")
+}
+
+// PreFilterExtensions 用量和节点无关,不需要
+func (eq *ElasticQuota) PreFilterExtensions() framework.PreFilterExtensions {
+	return nil
+}
+
+// 没有超过 min 的 pod 调度失败时,从借用资源的 namespace 中选择 victim
+// 每个节点上按优先级从低到高、启动时间从新到旧移除 victim,直到 pod 可以调度,选择 victim 最少的节点
+// 通过 Eviction 驱逐 victim,PodDisruptionBudget 不允许驱逐第一个 victim 时这次抢占失败,已经驱逐了一部分时仍然提名节点
+func (eq *ElasticQuota) PostFilter(ctx context.Context, state *framework.CycleState, pod *v1.Pod, filteredNodeStatusMap framework.NodeToStatusMap) (*framework.PostFilterResult, *framework.Status) {
+	eq.mutex.RLock()
+	q, ok := eq.quotas[pod.Namespace]
+	reclaim := ok && q.hasQuota && fitsWithin(q.used, podRequests(pod), q.min)
+	// 模拟时需要修改用量,复制一份
+	used, mins := map[string]v1.ResourceList{}, map[string]v1.ResourceList{}
+	for namespace, info := range eq.quotas {
+		if namespace == pod.Namespace || !info.borrowing() {
+			continue
+		}
+		used[namespace] = info.used.DeepCopy()
+		mins[namespace] = info.min
+	}
+	eq.mutex.RUnlock()
+
+	if !reclaim {
+		return nil, framework.NewStatus(framework.Unschedulable, fmt.Sprintf("pod %s/%s 超过了 namespace 的 min,不能抢占", pod.Namespace, pod.Name))
+	}
+	if len(used) == 0 {
+		return nil, framework.NewStatus(framework.Unschedulable, "没有借用资源的 namespace")
+	}
+
+	nodeInfos, err := eq.handle.SnapshotSharedLister().NodeInfos().List()
+	if err != nil {
+		return nil, framework.NewStatus(framework.Error, err.Error())
+	}
+	var nominatedNode string
+	var victims []*v1.Pod
+	for _, nodeInfo := range nodeInfos {
+		nodeName := nodeInfo.Node().Name
+		if status, ok := filteredNodeStatusMap[nodeName]; ok && status.Code() == framework.UnschedulableAndUnresolvable {
+			continue
+		}
+		nodeVictims, err := eq.selectVictimsOnNode(ctx, state, pod, nodeInfo, used, mins)
+		if err != nil {
+			return nil, framework.NewStatus(framework.Error, err.Error())
+		}
+		if nodeVictims == nil {
+			continue
+		}
+		if nominatedNode == "" || len(nodeVictims) < len(victims) {
+			nominatedNode, victims = nodeName, nodeVictims
+		}
+	}
+	if nominatedNode == "" {
+		return nil, framework.NewStatus(framework.Unschedulable, "抢占借用的资源后也没有可以调度的节点")
+	}
+
+	result, status := preemption.EvictAll(ctx, eq.handle, Name, pod, nominatedNode, victims)
+	if status.IsSuccess() {
+		klog.V(3).InfoS("Preempted borrowing pods", "pod", klog.KObj(pod), "node", nominatedNode, "victims", len(victims))
+	}
+	return result, status
+}
+
+// 在 clone 的 nodeInfo 和 CycleState 上模拟移除 victim,pod 不能调度返回 nil
+// 移除 victim 后 namespace 不再超过 min 时,这个 namespace 的 pod 不再作为 victim
+func (eq *ElasticQuota) selectVictimsOnNode(ctx context.Context, state *framework.CycleState, pod *v1.Pod, nodeInfo *framework.NodeInfo,
+	used map[string]v1.ResourceList, mins map[string]v1.ResourceList) ([]*v1.Pod, error) {
+	ph := eq.handle.PreemptHandle()
+	nodeInfoCopy := nodeInfo.Clone()
+	stateCopy := state.Clone()
+	fits := func() bool {
+		return ph.RunFilterPlugins(ctx, stateCopy, pod, nodeInfoCopy).Merge().IsSuccess()
+	}
+
+	var candidates []*v1.Pod
+	for _, podInfo := range nodeInfo.Pods {
+		if _, ok := used[podInfo.Pod.Namespace]; ok {
+			candidates = append(candidates, podInfo.Pod)
+		}
+	}
+	preemption.SortVictims(candidates)
+
+	nsUsed := map[string]v1.ResourceList{}
+	for namespace, list := range used {
+		nsUsed[namespace] = list.DeepCopy()
+	}
+	var victims []*v1.Pod
+	for _, candidate := range candidates {
+		if fits() {
+			break
+		}
+		if fitsWithin(nsUsed[candidate.Namespace], nil, mins[candidate.Namespace]) {
+			continue
+		}
+		if err := nodeInfoCopy.RemovePod(candidate); err != nil {
+			return nil, err
+		}
+		if status := ph.RunPreFilterExtensionRemovePod(ctx, stateCopy, pod, candidate, nodeInfoCopy); !status.IsSuccess() {
+			return nil, status.AsError()
+		}
+		subtractResourceList(nsUsed[candidate.Namespace], podRequests(candidate))
+		victims = append(victims, candidate)
+	}
+	if !fits() {
+		return nil, nil
+	}
+	return victims, nil
+}
+
+// pod 调度成功立即计入用量,informer 之后的 add 事件不会重复计算
+func (eq *ElasticQuota) Reserve(ctx context.Context, state *framework.CycleState, pod *v1.Pod, nodeName string) *framework.Status {
+	eq.mutex.Lock()
+	defer eq.mutex.Unlock()
+	eq.quotaInfo(pod.Namespace).addPod(pod)
+	return framework.NewStatus(framework.Success, "")
+}
+
+// 后面的阶段失败时撤销 Reserve 计入的用量
+func (eq *ElasticQuota) Unreserve(ctx context.Context, state *framework.CycleState, pod *v1.Pod, nodeName string) {
+	eq.mutex.Lock()
+	defer eq.mutex.Unlock()
+	eq.quotaInfo(pod.Namespace).removePod(pod.UID)
+}
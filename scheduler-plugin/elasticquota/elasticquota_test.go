@@ -0,0 +1,316 @@
+package elasticquota
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	policyv1beta1 "k8s.io/api/policy/v1beta1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/informers"
+	clientsetfake "k8s.io/client-go/kubernetes/fake"
+	clienttesting "k8s.io/client-go/testing"
+	"k8s.io/kubernetes/pkg/scheduler/apis/config"
+	"k8s.io/kubernetes/pkg/scheduler/framework/plugins/noderesources"
+	frameworkruntime "k8s.io/kubernetes/pkg/scheduler/framework/runtime"
+	framework "k8s.io/kubernetes/pkg/scheduler/framework/v1alpha1"
+
+	"schedulePlugin/apis/scheduling/v1alpha1"
+	"schedulePlugin/fake"
+)
+
+func makeQuota(t *testing.T, namespace, min, max string) *unstructured.Unstructured {
+	t.Helper()
+	quota := &v1alpha1.ElasticQuota{
+		TypeMeta:   metav1.TypeMeta{APIVersion: v1alpha1.SchemeGroupVersion.String(), Kind: "ElasticQuota"},
+		ObjectMeta: metav1.ObjectMeta{Name: "quota", Namespace: namespace},
+		Spec: v1alpha1.ElasticQuotaSpec{
+			Min: v1.ResourceList{v1.ResourceCPU: resource.MustParse(min)},
+			Max: v1.ResourceList{v1.ResourceCPU: resource.MustParse(max)},
+		},
+	}
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(quota)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &unstructured.Unstructured{Object: content}
+}
+
+// nodeName 为空表示还没有调度
+func makePod(namespace, name, cpu, nodeName string, priority int32, started time.Time) *v1.Pod {
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace, UID: types.UID(namespace + "-" + name)},
+		Spec: v1.PodSpec{
+			NodeName: nodeName,
+			Priority: &priority,
+			Containers: []v1.Container{{
+				Name:      "c",
+				Resources: v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse(cpu)}},
+			}},
+		},
+		Status: v1.PodStatus{StartTime: &metav1.Time{Time: started}},
+	}
+}
+
+// testFramework 运行 ElasticQuota 和 NodeResourcesFit 插件的 framework
+type testFramework struct {
+	framework.Framework
+	plugin    *ElasticQuota
+	clientSet *clientsetfake.Clientset
+}
+
+func newTestFramework(t *testing.T, quotas []runtime.Object, pods []*v1.Pod, nodeCPU string) *testFramework {
+	t.Helper()
+	var objs []runtime.Object
+	for _, pod := range pods {
+		objs = append(objs, pod)
+	}
+	clientSet := clientsetfake.NewSimpleClientset(objs...)
+	fake.AddEvictionReactor(clientSet)
+	informerFactory := informers.NewSharedInformerFactory(clientSet, 0)
+	node := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node-1"},
+		Status: v1.NodeStatus{Allocatable: v1.ResourceList{
+			v1.ResourceCPU:  resource.MustParse(nodeCPU),
+			v1.ResourcePods: resource.MustParse("110"),
+		}},
+	}
+	snapshot := fake.NewSharedLister([]*v1.Node{node}, pods)
+
+	stopCh := make(chan struct{})
+	t.Cleanup(func() { close(stopCh) })
+	tf := &testFramework{clientSet: clientSet}
+	registry := frameworkruntime.Registry{
+		Name: func(_ runtime.Object, f framework.FrameworkHandle) (framework.Plugin, error) {
+			p, err := newElasticQuota(f, dynamicfake.NewSimpleDynamicClient(runtime.NewScheme(), quotas...), stopCh)
+			tf.plugin = p
+			return p, err
+		},
+		noderesources.FitName: func(_ runtime.Object, f framework.FrameworkHandle) (framework.Plugin, error) {
+			return noderesources.NewFit(&config.NodeResourcesFitArgs{}, f)
+		},
+	}
+	plugins := &config.Plugins{
+		PreFilter:  &config.PluginSet{Enabled: []config.Plugin{{Name: noderesources.FitName}, {Name: Name}}},
+		Filter:     &config.PluginSet{Enabled: []config.Plugin{{Name: noderesources.FitName}}},
+		PostFilter: &config.PluginSet{Enabled: []config.Plugin{{Name: Name}}},
+		Reserve:    &config.PluginSet{Enabled: []config.Plugin{{Name: Name}}},
+	}
+	fwk, err := fake.NewFramework(registry, plugins,
+		frameworkruntime.WithClientSet(clientSet),
+		frameworkruntime.WithInformerFactory(informerFactory),
+		frameworkruntime.WithSnapshotSharedLister(snapshot))
+	if err != nil {
+		t.Fatalf("NewFramework: %v", err)
+	}
+	tf.Framework = fwk
+
+	informerFactory.Start(stopCh)
+	informerFactory.WaitForCacheSync(stopCh)
+	return tf
+}
+
+// informer 的 handler 是异步调用的,等待用量更新
+func (tf *testFramework) waitForUsage(t *testing.T, namespace, cpu string) {
+	t.Helper()
+	want := resource.MustParse(cpu)
+	err := wait.PollImmediate(10*time.Millisecond, wait.ForeverTestTimeout, func() (bool, error) {
+		tf.plugin.mutex.RLock()
+		defer tf.plugin.mutex.RUnlock()
+		q, ok := tf.plugin.quotas[namespace]
+		if !ok {
+			return want.IsZero(), nil
+		}
+		got := q.used[v1.ResourceCPU]
+		return got.Cmp(want) == 0, nil
+	})
+	if err != nil {
+		t.Fatalf("expected namespace %s to use %s cpu: %v", namespace, cpu, err)
+	}
+}
+
+func TestUsageRebuiltFromInformer(t *testing.T) {
+	now := time.Now()
+	done := makePod("a", "done", "1", "node-1", 0, now)
+	done.Status.Phase = v1.PodSucceeded
+	pods := []*v1.Pod{
+		makePod("a", "a1", "1", "node-1", 0, now),
+		makePod("a", "a2", "500m", "node-1", 0, now),
+		makePod("a", "pending", "1", "", 0, now),
+		done,
+	}
+	tf := newTestFramework(t, []runtime.Object{makeQuota(t, "a", "2", "4")}, pods, "8")
+	tf.waitForUsage(t, "a", "1500m")
+
+	ctx := context.Background()
+	pending := pods[2]
+	if st := tf.RunReservePluginsReserve(ctx, framework.NewCycleState(), pending, "node-1"); !st.IsSuccess() {
+		t.Fatalf("Reserve: %v", st)
+	}
+	tf.waitForUsage(t, "a", "2500m")
+	// bind 之前的更新事件不会移除 Reserve 计入的用量
+	waiting := pending.DeepCopy()
+	waiting.Labels = map[string]string{"waiting": "true"}
+	if _, err := tf.clientSet.CoreV1().Pods("a").Update(ctx, waiting, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tf.clientSet.CoreV1().Pods("a").Delete(ctx, "a1", metav1.DeleteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	tf.waitForUsage(t, "a", "1500m")
+
+	// bind 之后 informer 的事件不会重复计算
+	bound := waiting.DeepCopy()
+	bound.Spec.NodeName = "node-1"
+	if _, err := tf.clientSet.CoreV1().Pods("a").Update(ctx, bound, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	// 结束的 pod 不再占用配额
+	finished := pods[1].DeepCopy()
+	finished.Status.Phase = v1.PodFailed
+	if _, err := tf.clientSet.CoreV1().Pods("a").Update(ctx, finished, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	tf.waitForUsage(t, "a", "1")
+
+	tf.RunReservePluginsUnreserve(ctx, framework.NewCycleState(), bound, "node-1")
+	tf.waitForUsage(t, "a", "0")
+}
+
+func TestNewValidatesArgs(t *testing.T) {
+	_, err := New(&runtime.Unknown{Raw: []byte(`{"kubeMaster": "10.0.0.1:6443"}`), ContentType: runtime.ContentTypeJSON}, nil)
+	if want := "kubeMaster: Invalid value"; err == nil || !strings.Contains(err.Error(), want) {
+		t.Errorf("expected error containing %q, got %v", want, err)
+	}
+}
+
+func TestPreFilter(t *testing.T) {
+	now := time.Now()
+	quotas := []runtime.Object{makeQuota(t, "a", "2", "4"), makeQuota(t, "b", "2", "4")}
+	tf := newTestFramework(t, quotas, []*v1.Pod{makePod("a", "a1", "2", "node-1", 0, now)}, "8")
+	tf.waitForUsage(t, "a", "2")
+	ctx := context.Background()
+
+	tests := []struct {
+		name     string
+		pod      *v1.Pod
+		wantCode framework.Code
+	}{
+		{"namespace without quota", makePod("c", "c1", "100", "", 0, now), framework.Success},
+		{"borrow unused min of b", makePod("a", "p", "2", "", 0, now), framework.Success},
+		{"exceeds max", makePod("a", "p", "3", "", 0, now), framework.Unschedulable},
+		{"within min", makePod("b", "p", "2", "", 0, now), framework.Success},
+	}
+	for _, tt := range tests {
+		if st := tf.RunPreFilterPlugins(ctx, framework.NewCycleState(), tt.pod); st.Code() != tt.wantCode {
+			t.Errorf("%s: expected code %v, got %v", tt.name, tt.wantCode, st)
+		}
+	}
+
+	// b 用完了自己的 min,a 没有可以借用的资源
+	if st := tf.RunReservePluginsReserve(ctx, framework.NewCycleState(), makePod("b", "b1", "2", "", 0, now), "node-1"); !st.IsSuccess() {
+		t.Fatalf("Reserve: %v", st)
+	}
+	if st := tf.RunPreFilterPlugins(ctx, framework.NewCycleState(), makePod("a", "p", "1", "", 0, now)); st.Code() != framework.Unschedulable {
+		t.Errorf("expected Unschedulable without unused min, got %v", st)
+	}
+}
+
+func TestPostFilterPreemptsBorrowingPods(t *testing.T) {
+	now := time.Now()
+	quotas := []runtime.Object{makeQuota(t, "a", "2", "4"), makeQuota(t, "b", "2", "4")}
+	// b 借用了 a 的 1 个 cpu,节点已经满了
+	pods := []*v1.Pod{
+		makePod("a", "a1", "1", "node-1", 0, now),
+		makePod("b", "b1", "1", "node-1", 10, now),
+		makePod("b", "b2", "1", "node-1", 0, now.Add(-time.Minute)),
+		makePod("b", "b3", "1", "node-1", 0, now),
+	}
+	tf := newTestFramework(t, quotas, pods, "4")
+	tf.waitForUsage(t, "a", "1")
+	tf.waitForUsage(t, "b", "3")
+	ctx := context.Background()
+
+	// a 还有 1 个 cpu 的 min 没有用,回收借出去的资源
+	pod := makePod("a", "a2", "1", "", 0, now)
+	state := framework.NewCycleState()
+	if st := tf.RunPreFilterPlugins(ctx, state, pod); !st.IsSuccess() {
+		t.Fatalf("PreFilter: %v", st)
+	}
+	statusMap := framework.NodeToStatusMap{"node-1": framework.NewStatus(framework.Unschedulable, "Insufficient cpu")}
+	result, st := tf.RunPostFilterPlugins(ctx, state, pod, statusMap)
+	if !st.IsSuccess() {
+		t.Fatalf("expected preemption to succeed, got %v", st)
+	}
+	if result.NominatedNodeName != "node-1" {
+		t.Errorf("expected node-1 to be nominated, got %q", result.NominatedNodeName)
+	}
+	// 优先级最低、启动最晚的 b3 被抢占,b 回到 min 之后其他 pod 保留
+	if _, err := tf.clientSet.CoreV1().Pods("b").Get(ctx, "b3", metav1.GetOptions{}); !errors.IsNotFound(err) {
+		t.Errorf("expected b3 to be preempted, got %v", err)
+	}
+	for _, name := range []string{"b1", "b2"} {
+		if _, err := tf.clientSet.CoreV1().Pods("b").Get(ctx, name, metav1.GetOptions{}); err != nil {
+			t.Errorf("expected %s to be kept, got %v", name, err)
+		}
+	}
+	tf.waitForUsage(t, "b", "2")
+
+	// 超过 min 的 pod 不能抢占
+	pod = makePod("a", "a3", "2", "", 0, now)
+	state = framework.NewCycleState()
+	tf.RunPreFilterPlugins(ctx, state, pod)
+	if _, st := tf.RunPostFilterPlugins(ctx, state, pod, statusMap); st.IsSuccess() {
+		t.Errorf("expected pod beyond min not to preempt")
+	}
+}
+
+// 第二个 victim 不允许驱逐,第一个已经驱逐了,释放的资源留给抢占的 pod
+func TestPostFilterNominatesAfterPartialEviction(t *testing.T) {
+	now := time.Now()
+	quotas := []runtime.Object{makeQuota(t, "a", "3", "4"), makeQuota(t, "b", "0", "4")}
+	pods := []*v1.Pod{
+		makePod("a", "a1", "1", "node-1", 0, now),
+		makePod("b", "b1", "1", "node-1", 0, now.Add(-time.Minute)),
+		makePod("b", "b2", "1", "node-1", 0, now),
+	}
+	tf := newTestFramework(t, quotas, pods, "3")
+	tf.waitForUsage(t, "a", "1")
+	tf.waitForUsage(t, "b", "2")
+	tf.clientSet.PrependReactor("create", "pods", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		if create := action.(clienttesting.CreateAction); create.GetSubresource() == "eviction" && create.GetObject().(*policyv1beta1.Eviction).Name == "b1" {
+			return true, nil, errors.NewTooManyRequests("Cannot evict pod as it would violate the pod's disruption budget.", 0)
+		}
+		return false, nil, nil
+	})
+	ctx := context.Background()
+
+	pod := makePod("a", "a2", "2", "", 0, now)
+	state := framework.NewCycleState()
+	if st := tf.RunPreFilterPlugins(ctx, state, pod); !st.IsSuccess() {
+		t.Fatalf("PreFilter: %v", st)
+	}
+	statusMap := framework.NodeToStatusMap{"node-1": framework.NewStatus(framework.Unschedulable, "Insufficient cpu")}
+	result, st := tf.RunPostFilterPlugins(ctx, state, pod, statusMap)
+	if !st.IsSuccess() {
+		t.Fatalf("expected preemption to succeed, got %v", st)
+	}
+	if result == nil || result.NominatedNodeName != "node-1" {
+		t.Errorf("expected node-1 to be nominated, got %+v", result)
+	}
+	if _, err := tf.clientSet.CoreV1().Pods("b").Get(ctx, "b2", metav1.GetOptions{}); !errors.IsNotFound(err) {
+		t.Errorf("expected b2 to be preempted, got %v", err)
+	}
+	if _, err := tf.clientSet.CoreV1().Pods("b").Get(ctx, "b1", metav1.GetOptions{}); err != nil {
+		t.Errorf("expected b1 to be kept, got %v", err)
+	}
+}
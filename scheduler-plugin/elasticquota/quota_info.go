@@ -0,0 +1,106 @@
+package elasticquota
+
+import (
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/types"
+	resourcehelper "k8s.io/kubernetes/pkg/api/v1/resource"
+)
+
+// quotaInfo 一个 namespace 的配额和用量
+// 用量按 pod 的 uid 记录,pod 的 add 和 reserve 重复计算时不会算两次
+type quotaInfo struct {
+	// namespace 是否有 ElasticQuota,没有的 namespace 只记录用量
+	hasQuota bool
+	min      v1.ResourceList
+	max      v1.ResourceList
+	used     v1.ResourceList
+	// uid -> pod 的 requests
+	pods map[types.UID]v1.ResourceList
+}
+
+func newQuotaInfo() *quotaInfo {
+	return &quotaInfo{
+		used: v1.ResourceList{},
+		pods: map[types.UID]v1.ResourceList{},
+	}
+}
+
+// 记录 pod 的用量,已经记录过返回 false
+func (q *quotaInfo) addPod(pod *v1.Pod) bool {
+	if _, ok := q.pods[pod.UID]; ok {
+		return false
+	}
+	requests := podRequests(pod)
+	q.pods[pod.UID] = requests
+	addResourceList(q.used, requests)
+	return true
+}
+
+// 删除 pod 的用量,没有记录过返回 false
+func (q *quotaInfo) removePod(uid types.UID) bool {
+	requests, ok := q.pods[uid]
+	if !ok {
+		return false
+	}
+	delete(q.pods, uid)
+	subtractResourceList(q.used, requests)
+	return true
+}
+
+// 用量超过 min,说明在借用其他 namespace 的资源
+func (q *quotaInfo) borrowing() bool {
+	return q.hasQuota && !fitsWithin(q.used, nil, q.min)
+}
+
+// pod 的 requests,包括 init container 和 overhead
+func podRequests(pod *v1.Pod) v1.ResourceList {
+	requests, _ := resourcehelper.PodRequestsAndLimits(pod)
+	return requests
+}
+
+func addResourceList(list, add v1.ResourceList) {
+	for name, quantity := range add {
+		value := list[name]
+		value.Add(quantity)
+		list[name] = value
+	}
+}
+
+func subtractResourceList(list, sub v1.ResourceList) {
+	for name, quantity := range sub {
+		value := list[name]
+		value.Sub(quantity)
+		if value.Sign() <= 0 {
+			delete(list, name)
+			continue
+		}
+		list[name] = value
+	}
+}
+
+// used 加上 requests 之后是否不超过 limit,只检查 limit 中设置了的资源
+// requests 为 nil 时检查 used 本身,否则只检查 pod 申请了的资源,pod 没有申请的资源不会因为别人的用量被拒绝
+func fitsWithin(used, requests, limit v1.ResourceList) bool {
+	_, ok := exceeded(used, requests, limit)
+	return ok
+}
+
+// 返回第一个超过 limit 的资源
+func exceeded(used, requests, limit v1.ResourceList) (v1.ResourceName, bool) {
+	for name, max := range limit {
+		var value resource.Quantity
+		if requests != nil {
+			request, ok := requests[name]
+			if !ok || request.IsZero() {
+				continue
+			}
+			value = request.DeepCopy()
+		}
+		value.Add(used[name])
+		if value.Cmp(max) > 0 {
+			return name, false
+		}
+	}
+	return "", true
+}
@@ -0,0 +1,65 @@
+package fake
+
+import (
+	v1 "k8s.io/api/core/v1"
+	policyv1beta1 "k8s.io/api/policy/v1beta1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientsetfake "k8s.io/client-go/kubernetes/fake"
+	clienttesting "k8s.io/client-go/testing"
+)
+
+var (
+	podsResource = v1.SchemeGroupVersion.WithResource("pods")
+	pdbsResource = policyv1beta1.SchemeGroupVersion.WithResource("poddisruptionbudgets")
+	pdbKind      = policyv1beta1.SchemeGroupVersion.WithKind("PodDisruptionBudget")
+)
+
+// AddEvictionReactor 和 apiserver 一样处理 pod 的 Eviction 子资源
+// 匹配的 PodDisruptionBudget 不允许驱逐时返回 429,否则扣减 disruptionsAllowed 并删除 pod
+// fake clientset 在调用 reactor 时持有锁,这里只能通过 tracker 读写对象
+func AddEvictionReactor(clientSet *clientsetfake.Clientset) {
+	tracker := clientSet.Tracker()
+	clientSet.PrependReactor("create", "pods", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		create, ok := action.(clienttesting.CreateAction)
+		if !ok || create.GetSubresource() != "eviction" {
+			return false, nil, nil
+		}
+		eviction, ok := create.GetObject().(*policyv1beta1.Eviction)
+		if !ok {
+			return true, nil, errors.NewBadRequest("expected an Eviction object")
+		}
+		namespace := action.GetNamespace()
+		obj, err := tracker.Get(podsResource, namespace, eviction.Name)
+		if err != nil {
+			return true, nil, err
+		}
+		pod := obj.(*v1.Pod)
+
+		list, err := tracker.List(pdbsResource, pdbKind, namespace)
+		if err != nil {
+			return true, nil, err
+		}
+		var pdbs []*policyv1beta1.PodDisruptionBudget
+		for i := range list.(*policyv1beta1.PodDisruptionBudgetList).Items {
+			pdb := &list.(*policyv1beta1.PodDisruptionBudgetList).Items[i]
+			selector, err := metav1.LabelSelectorAsSelector(pdb.Spec.Selector)
+			if err != nil || selector.Empty() || !selector.Matches(labels.Set(pod.Labels)) {
+				continue
+			}
+			if pdb.Status.DisruptionsAllowed <= 0 {
+				return true, nil, errors.NewTooManyRequests("Cannot evict pod as it would violate the pod's disruption budget.", 0)
+			}
+			pdbs = append(pdbs, pdb)
+		}
+		for _, pdb := range pdbs {
+			pdb.Status.DisruptionsAllowed--
+			if err := tracker.Update(pdbsResource, pdb, namespace); err != nil {
+				return true, nil, err
+			}
+		}
+		return true, nil, tracker.Delete(podsResource, namespace, eviction.Name)
+	})
+}
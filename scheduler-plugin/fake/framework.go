@@ -0,0 +1,129 @@
+package fake
+
+import (
+	"fmt"
+	"sync"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/kubernetes/pkg/scheduler/apis/config"
+	"k8s.io/kubernetes/pkg/scheduler/framework/plugins/defaultbinder"
+	"k8s.io/kubernetes/pkg/scheduler/framework/plugins/queuesort"
+	frameworkruntime "k8s.io/kubernetes/pkg/scheduler/framework/runtime"
+	framework "k8s.io/kubernetes/pkg/scheduler/framework/v1alpha1"
+)
+
+// 单元测试使用的调度快照和 framework
+
+// SharedLister 用给定的节点和 pod 构造调度快照,可以在测试中继续添加 pod 模拟 assume
+type SharedLister struct {
+	mutex     sync.RWMutex
+	nodeInfos []*framework.NodeInfo
+}
+
+var _ framework.SharedLister = &SharedLister{}
+var _ framework.NodeInfoLister = &SharedLister{}
+
+// NewSharedLister 实例化调度快照,pod 按照 spec.nodeName 放到节点上
+func NewSharedLister(nodes []*v1.Node, pods []*v1.Pod) *SharedLister {
+	l := &SharedLister{}
+	for _, node := range nodes {
+		nodeInfo := framework.NewNodeInfo()
+		nodeInfo.SetNode(node)
+		l.nodeInfos = append(l.nodeInfos, nodeInfo)
+	}
+	for _, pod := range pods {
+		l.AddPod(pod)
+	}
+	return l
+}
+
+// AddPod 把 pod 添加到 spec.nodeName 节点上,节点不存在时忽略
+func (l *SharedLister) AddPod(pod *v1.Pod) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	for _, nodeInfo := range l.nodeInfos {
+		if nodeInfo.Node().Name == pod.Spec.NodeName {
+			nodeInfo.AddPod(pod)
+		}
+	}
+}
+
+// RemovePod 把 pod 从 spec.nodeName 节点上删除,模拟 forget
+func (l *SharedLister) RemovePod(pod *v1.Pod) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	for _, nodeInfo := range l.nodeInfos {
+		if nodeInfo.Node().Name == pod.Spec.NodeName {
+			return nodeInfo.RemovePod(pod)
+		}
+	}
+	return nil
+}
+
+func (l *SharedLister) NodeInfos() framework.NodeInfoLister {
+	return l
+}
+
+func (l *SharedLister) List() ([]*framework.NodeInfo, error) {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+	return l.nodeInfos, nil
+}
+
+// 节点上有带 pod 亲和性的 pod,InterPodAffinity 插件使用
+func (l *SharedLister) HavePodsWithAffinityList() ([]*framework.NodeInfo, error) {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+	var result []*framework.NodeInfo
+	for _, nodeInfo := range l.nodeInfos {
+		if len(nodeInfo.PodsWithAffinity) > 0 {
+			result = append(result, nodeInfo)
+		}
+	}
+	return result, nil
+}
+
+func (l *SharedLister) HavePodsWithRequiredAntiAffinityList() ([]*framework.NodeInfo, error) {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+	var result []*framework.NodeInfo
+	for _, nodeInfo := range l.nodeInfos {
+		if len(nodeInfo.PodsWithRequiredAntiAffinity) > 0 {
+			result = append(result, nodeInfo)
+		}
+	}
+	return result, nil
+}
+
+func (l *SharedLister) Get(nodeName string) (*framework.NodeInfo, error) {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+	for _, nodeInfo := range l.nodeInfos {
+		if nodeInfo.Node().Name == nodeName {
+			return nodeInfo, nil
+		}
+	}
+	return nil, fmt.Errorf("node %q not found", nodeName)
+}
+
+// NewFramework 实例化一个 framework,registry 和 plugins 中的插件之外自动加上默认的 queue sort 和 bind 插件
+func NewFramework(registry frameworkruntime.Registry, plugins *config.Plugins, opts ...frameworkruntime.Option) (framework.Framework, error) {
+	// framework 必须有 queue sort 和 bind 插件
+	r := frameworkruntime.Registry{
+		queuesort.Name:     queuesort.New,
+		defaultbinder.Name: defaultbinder.New,
+	}
+	if err := r.Merge(registry); err != nil {
+		return nil, err
+	}
+	if plugins == nil {
+		plugins = &config.Plugins{}
+	}
+	if plugins.QueueSort == nil {
+		plugins.QueueSort = &config.PluginSet{Enabled: []config.Plugin{{Name: queuesort.Name}}}
+	}
+	if plugins.Bind == nil {
+		plugins.Bind = &config.PluginSet{Enabled: []config.Plugin{{Name: defaultbinder.Name}}}
+	}
+	return frameworkruntime.NewFramework(r, plugins, nil, opts...)
+}
@@ -3,12 +3,15 @@ module schedulePlugin
 go 1.18
 
 require (
+	github.com/spf13/cobra v1.0.0
 	github.com/spf13/pflag v1.0.5
 	k8s.io/api v0.19.9
 	k8s.io/apimachinery v0.19.9
+	k8s.io/client-go v0.19.9
 	k8s.io/component-base v0.19.9
 	k8s.io/klog v1.0.0
 	k8s.io/kubernetes v1.19.9
+	sigs.k8s.io/yaml v1.2.0
 )
 
 require (
@@ -57,7 +60,6 @@ require (
 	github.com/prometheus/common v0.10.0 // indirect
 	github.com/prometheus/procfs v0.1.3 // indirect
 	github.com/sirupsen/logrus v1.6.0 // indirect
-	github.com/spf13/cobra v1.0.0 // indirect
 	go.etcd.io/etcd v0.5.0-alpha.5.0.20200819165624-17cef6e3e9d5 // indirect
 	go.uber.org/atomic v1.4.0 // indirect
 	go.uber.org/multierr v1.1.0 // indirect
@@ -77,7 +79,6 @@ require (
 	gopkg.in/natefinch/lumberjack.v2 v2.0.0 // indirect
 	gopkg.in/yaml.v2 v2.2.8 // indirect
 	k8s.io/apiserver v0.19.9 // indirect
-	k8s.io/client-go v0.19.9 // indirect
 	k8s.io/cloud-provider v0.19.9 // indirect
 	k8s.io/csi-translation-lib v0.19.9 // indirect
 	k8s.io/klog/v2 v2.2.0 // indirect
@@ -86,7 +87,6 @@ require (
 	k8s.io/utils v0.0.0-20200729134348-d5654de09c73 // indirect
 	sigs.k8s.io/apiserver-network-proxy/konnectivity-client v0.0.15 // indirect
 	sigs.k8s.io/structured-merge-diff/v4 v4.0.1 // indirect
-	sigs.k8s.io/yaml v1.2.0 // indirect
 )
 
 replace (
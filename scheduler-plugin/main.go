@@ -7,18 +7,40 @@ import (
 	_ "k8s.io/component-base/metrics/prometheus/clientgo"
 	_ "k8s.io/component-base/metrics/prometheus/version"
 	"k8s.io/kubernetes/cmd/kube-scheduler/app"
+	frameworkruntime "k8s.io/kubernetes/pkg/scheduler/framework/runtime"
 	"math/rand"
 	"os"
+	"schedulePlugin/coscheduling"
+	"schedulePlugin/elasticquota"
 	plugins "schedulePlugin/plugin"
+	"schedulePlugin/simulator"
+	"schedulePlugin/topologyspread"
 	"time"
 )
 
 func main() {
 	rand.Seed(time.Now().UnixNano())
 
+	// 自定义的插件,调度器和 simulate 子命令共用
+	registry := frameworkruntime.Registry{
+		plugins.Name: plugins.New,
+		// gang scheduling
+		coscheduling.Name: coscheduling.New,
+		// namespace 弹性配额
+		elasticquota.Name: elasticquota.New,
+		// 按自定义的节点 label 打散
+		topologyspread.Name: topologyspread.New,
+	}
+
 	// withplugin用来注册插件,返回的是一个option,插件的参数,可以自定义
 	// 将自定义的插件注入到整体的插件中,合并到默认的插件中
-	command := app.NewSchedulerCommand(app.WithPlugin(plugins.Name, plugins.New))
+	var options []app.Option
+	for name, factory := range registry {
+		options = append(options, app.WithPlugin(name, factory))
+	}
+	command := app.NewSchedulerCommand(options...)
+	// 离线模拟调度
+	command.AddCommand(simulator.NewCommand(registry))
 
 	// utilflag.InitFlags() (by removing its pflag.Parse() call). For now, we have to set the
 	// normalize func and add the go flag set by hand.
@@ -0,0 +1,104 @@
+package plugins
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+const (
+	// 节点上的 agent 定期把利用率写到节点的 annotation 上
+	// cpu 和内存利用率是百分比,例如 "35.5"
+	CPUUtilizationAnnotation    = "sample-scheduler.io/cpu-utilization"
+	MemoryUtilizationAnnotation = "sample-scheduler.io/memory-utilization"
+	// 采集时间,RFC3339 格式
+	MetricsTimestampAnnotation = "sample-scheduler.io/metrics-timestamp"
+)
+
+// NodeMetrics 节点实际的资源利用率
+type NodeMetrics struct {
+	// 利用率百分比 0-100
+	CPUUtilization    float64
+	MemoryUtilization float64
+	// 采集时间,用来判断数据是否过期
+	Timestamp time.Time
+}
+
+// MetricsSource 节点利用率的数据来源,可以替换成 metrics-server、prometheus 等实现
+type MetricsSource interface {
+	// 获取节点的利用率,没有数据时返回 nil
+	GetNodeMetrics(node *v1.Node) (*NodeMetrics, error)
+}
+
+// annotationMetricsSource 从节点的 annotation 中读取利用率
+type annotationMetricsSource struct{}
+
+// NewAnnotationMetricsSource 实例化一个读取节点 annotation 的 MetricsSource
+func NewAnnotationMetricsSource() MetricsSource {
+	return &annotationMetricsSource{}
+}
+
+func (a *annotationMetricsSource) GetNodeMetrics(node *v1.Node) (*NodeMetrics, error) {
+	annotations := node.GetAnnotations()
+	ts, ok := annotations[MetricsTimestampAnnotation]
+	if !ok {
+		return nil, nil
+	}
+	timestamp, err := time.Parse(time.RFC3339, ts)
+	if err != nil {
+		return nil, fmt.Errorf("node %s annotation %s=%q invalid: %v", node.Name, MetricsTimestampAnnotation, ts, err)
+	}
+	cpu, err := parseUtilization(annotations, CPUUtilizationAnnotation)
+	if err != nil {
+		return nil, fmt.Errorf("node %s: %v", node.Name, err)
+	}
+	memory, err := parseUtilization(annotations, MemoryUtilizationAnnotation)
+	if err != nil {
+		return nil, fmt.Errorf("node %s: %v", node.Name, err)
+	}
+	return &NodeMetrics{
+		CPUUtilization:    cpu,
+		MemoryUtilization: memory,
+		Timestamp:         timestamp,
+	}, nil
+}
+
+func parseUtilization(annotations map[string]string, key string) (float64, error) {
+	value, ok := annotations[key]
+	if !ok {
+		return 0, fmt.Errorf("annotation %s not found", key)
+	}
+	u, err := strconv.ParseFloat(value, 64)
+	if err != nil || u < 0 || u > 100 {
+		return 0, fmt.Errorf("annotation %s=%q must be a percentage between 0 and 100", key, value)
+	}
+	return u, nil
+}
+
+// FakeMetricsSource 在内存中保存节点的利用率,用于单元测试
+type FakeMetricsSource struct {
+	mutex sync.RWMutex
+	// node name -> metrics
+	Metrics map[string]*NodeMetrics
+}
+
+// NewFakeMetricsSource 实例化一个 fake MetricsSource
+func NewFakeMetricsSource() *FakeMetricsSource {
+	return &FakeMetricsSource{Metrics: map[string]*NodeMetrics{}}
+}
+
+// Set 设置节点的利用率
+func (f *FakeMetricsSource) Set(nodeName string, metrics *NodeMetrics) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.Metrics[nodeName] = metrics
+}
+
+func (f *FakeMetricsSource) GetNodeMetrics(node *v1.Node) (*NodeMetrics, error) {
+	f.mutex.RLock()
+	defer f.mutex.RUnlock()
+	return f.Metrics[node.Name], nil
+}
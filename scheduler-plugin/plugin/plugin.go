@@ -4,11 +4,17 @@ import (
 	"context"
 	"fmt"
 	"k8s.io/klog/v2"
+	"time"
 
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	policylisters "k8s.io/client-go/listers/policy/v1beta1"
 	frameworkruntime "k8s.io/kubernetes/pkg/scheduler/framework/runtime"
 	framework "k8s.io/kubernetes/pkg/scheduler/framework/v1alpha1"
+
+	pluginconfig "schedulePlugin/apis/config"
+	configscheme "schedulePlugin/apis/config/scheme"
+	"schedulePlugin/apis/config/validation"
 )
 
 const (
@@ -22,23 +28,19 @@ const (
 // prefilterplugin的interface
 var _ framework.PreFilterPlugin = &Sample{}
 var _ framework.FilterPlugin = &Sample{}
+var _ framework.PreFilterExtensions = &Sample{}
 
-// 调度插件的参数
-type SampleArgs struct {
-	FavoriteColor  string `json:"favorColor,omitempty"`
-	FavoriteNumber int    `json:"favorNumber,omitempty"`
-	ThanksTo       string `json:"thanksTo,omitempty"`
-}
-
-// 获取插件配置的参数
-func getSampleArgs(object runtime.Object) (*SampleArgs, error) {
-	// 如果参数格式符合runtime.Object也就是typemeta等那些,直接断言即可,将object传递给参数结构体
-	sa := &SampleArgs{}
-	// decodeinto将object转换成参数结构体
-	if err := frameworkruntime.DecodeInto(object, sa); err != nil {
+// 获取插件配置的参数,填充默认值之后校验
+func getSampleArgs(object runtime.Object) (*pluginconfig.SampleArgs, error) {
+	args := &pluginconfig.SampleArgs{}
+	// 按 v1beta1 版本解码,转换成内部版本
+	if err := configscheme.DecodeInto(object, args); err != nil {
 		return nil, err
 	}
-	return sa, nil
+	if err := validation.ValidateSampleArgs(args); err != nil {
+		return nil, fmt.Errorf("invalid %s args: %v", Name, err)
+	}
+	return args, nil
 }
 
 type preFilterState struct {
@@ -46,8 +48,9 @@ type preFilterState struct {
 }
 
 // 实现这个函数 实现接口
+// 抢占时会 clone CycleState 做模拟,这里要深拷贝
 func (s *preFilterState) Clone() framework.StateData {
-	return s
+	return &preFilterState{Resource: *s.Resource.Clone()}
 }
 
 func getPreFilterState(state *framework.CycleState) (*preFilterState, error) {
@@ -65,8 +68,16 @@ func getPreFilterState(state *framework.CycleState) (*preFilterState, error) {
 
 // 要实现这个prefilter插件,sample要实现以下方法
 type Sample struct {
-	args   *SampleArgs
+	args   *pluginconfig.SampleArgs
 	handle framework.FrameworkHandle
+	// 节点利用率的数据来源
+	metrics MetricsSource
+	// 当前时间,测试时可以替换
+	now func() time.Time
+	// 扩展资源已经 reserve 的数量
+	ledger *ledger
+	// 抢占时统计违反的 PodDisruptionBudget
+	pdbLister policylisters.PodDisruptionBudgetLister
 }
 
 func (s *Sample) Name() string {
@@ -89,10 +100,30 @@ func (s *Sample) PreFilter(ctx context.Context, state *framework.CycleState, pod
 	}
 	// key value
 	state.Write(preFilterStateKey, computePodResourceLimit(pod))
+	if s.args.ReservedResourceName != "" {
+		state.Write(ledgerStateKey, s.ledger.snapshot())
+	}
 	return nil
 }
 
 func (s *Sample) PreFilterExtensions() framework.PreFilterExtensions {
+	return s
+}
+
+// 抢占模拟时在节点上添加一个 pod
+// 框架在调用之前已经把 pod 加到了 nodeInfo.Requested 中,preFilterState 只和待调度的 pod 有关,不需要调整
+func (s *Sample) AddPod(ctx context.Context, state *framework.CycleState, podToSchedule *v1.Pod, podToAdd *v1.Pod, nodeInfo *framework.NodeInfo) *framework.Status {
+	if _, err := getPreFilterState(state); err != nil {
+		return framework.NewStatus(framework.Error, err.Error())
+	}
+	return nil
+}
+
+// 抢占模拟时从节点上移除一个 pod,同 AddPod
+func (s *Sample) RemovePod(ctx context.Context, state *framework.CycleState, podToSchedule *v1.Pod, podToRemove *v1.Pod, nodeInfo *framework.NodeInfo) *framework.Status {
+	if _, err := getPreFilterState(state); err != nil {
+		return framework.NewStatus(framework.Error, err.Error())
+	}
 	return nil
 }
 
@@ -104,26 +135,79 @@ func (s *Sample) Filter(ctx context.Context, state *framework.CycleState, pod *v
 	if klog.V(2).Enabled() {
 		klog.InfoS("Start Filter Pod", "pod", pod.Name, "node", nodeInfo.Node().Name, "preFilterState", preState)
 	}
-	// logic
+	// 节点剩余的资源放不下 pod 的 limits 就过滤掉
 	// 调度错误和错误描述
+	reasons := insufficientResources(&preState.Resource, nodeInfo, v1.ResourceName(s.args.ReservedResourceName))
+	// 账本维护的扩展资源不看 nodeInfo.Requested,扣掉账本中已经 reserve 的数量
+	if amount := s.reservedAmount(preState); s.args.ReservedResourceName != "" && amount > 0 {
+		fits, err := s.ledgerFits(state, amount, nodeInfo)
+		if err != nil {
+			return framework.NewStatus(framework.Error, err.Error())
+		}
+		if !fits {
+			reasons = append(reasons, fmt.Sprintf("Insufficient %v", s.args.ReservedResourceName))
+		}
+	}
+	if len(reasons) > 0 {
+		return framework.NewStatus(framework.Unschedulable, reasons...)
+	}
 	return framework.NewStatus(framework.Success, "")
 }
 
+// 节点剩余的资源 = nodeInfo.Allocatable - nodeInfo.Requested,返回不足的资源
+// pod 没有设置 limits 的资源不做检查,skip 是账本维护的扩展资源
+func insufficientResources(limits *framework.Resource, nodeInfo *framework.NodeInfo, skip v1.ResourceName) []string {
+	allocatable, requested := nodeInfo.Allocatable, nodeInfo.Requested
+	var reasons []string
+	if limits.MilliCPU > 0 && limits.MilliCPU > allocatable.MilliCPU-requested.MilliCPU {
+		reasons = append(reasons, fmt.Sprintf("Insufficient %v", v1.ResourceCPU))
+	}
+	if limits.Memory > 0 && limits.Memory > allocatable.Memory-requested.Memory {
+		reasons = append(reasons, fmt.Sprintf("Insufficient %v", v1.ResourceMemory))
+	}
+	if limits.EphemeralStorage > 0 && limits.EphemeralStorage > allocatable.EphemeralStorage-requested.EphemeralStorage {
+		reasons = append(reasons, fmt.Sprintf("Insufficient %v", v1.ResourceEphemeralStorage))
+	}
+	for name, quantity := range limits.ScalarResources {
+		if name == skip {
+			continue
+		}
+		if quantity > 0 && quantity > allocatable.ScalarResources[name]-requested.ScalarResources[name] {
+			reasons = append(reasons, fmt.Sprintf("Insufficient %v", name))
+		}
+	}
+	return reasons
+}
+
 //type PluginFactory = func(configuration runtime.Object, f v1alpha1.FrameworkHandle) (v1alpha1.Plugin, error)
 // 调度框架
 // 初始化插件
 func New(object runtime.Object, f framework.FrameworkHandle) (framework.Plugin, error) {
+	return newSample(object, f, time.Now)
+}
+
+// NewWithClock 返回用 now 作为当前时间的插件工厂,离线模拟时固定时间,判断节点 metrics 是否过期的结果不随运行时间变化
+func NewWithClock(now func() time.Time) frameworkruntime.PluginFactory {
+	return func(object runtime.Object, f framework.FrameworkHandle) (framework.Plugin, error) {
+		return newSample(object, f, now)
+	}
+}
+
+func newSample(object runtime.Object, f framework.FrameworkHandle, now func() time.Time) (*Sample, error) {
 	// 获取参数
 	args, err := getSampleArgs(object)
 	if err != nil {
 		return nil, err
 	}
-	// validate args
 	if klog.V(2).Enabled() {
 		klog.InfoS("Successfully get plugin config args", "plugin", Name, "args", args)
 	}
 	return &Sample{
-		args:   args,
-		handle: f,
+		args:      args,
+		handle:    f,
+		metrics:   NewAnnotationMetricsSource(),
+		now:       now,
+		ledger:    newLedger(),
+		pdbLister: newPDBLister(f),
 	}, nil
 }
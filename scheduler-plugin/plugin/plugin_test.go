@@ -0,0 +1,145 @@
+package plugins
+
+import (
+	"context"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	framework "k8s.io/kubernetes/pkg/scheduler/framework/v1alpha1"
+
+	pluginconfig "schedulePlugin/apis/config"
+)
+
+func makePod(name, cpu, memory string, limits bool) *v1.Pod {
+	rl := v1.ResourceList{
+		v1.ResourceCPU:    resource.MustParse(cpu),
+		v1.ResourceMemory: resource.MustParse(memory),
+	}
+	container := v1.Container{Name: "c"}
+	if limits {
+		container.Resources.Limits = rl
+	} else {
+		container.Resources.Requests = rl
+	}
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", UID: types.UID("uid-" + name)},
+		Spec:       v1.PodSpec{Containers: []v1.Container{container}},
+	}
+}
+
+func makeNodeInfo(cpu, memory string, pods ...*v1.Pod) *framework.NodeInfo {
+	nodeInfo := framework.NewNodeInfo(pods...)
+	nodeInfo.SetNode(&v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node-1"},
+		Status: v1.NodeStatus{Allocatable: v1.ResourceList{
+			v1.ResourceCPU:    resource.MustParse(cpu),
+			v1.ResourceMemory: resource.MustParse(memory),
+			v1.ResourcePods:   resource.MustParse("110"),
+		}},
+	})
+	return nodeInfo
+}
+
+func TestFilter(t *testing.T) {
+	tests := []struct {
+		name     string
+		pod      *v1.Pod
+		nodeInfo *framework.NodeInfo
+		wantCode framework.Code
+		reasons  []string
+	}{
+		{
+			name:     "fits on an empty node",
+			pod:      makePod("p", "1", "1Gi", true),
+			nodeInfo: makeNodeInfo("2", "2Gi"),
+			wantCode: framework.Success,
+		},
+		{
+			name:     "no limits",
+			pod:      &v1.Pod{Spec: v1.PodSpec{Containers: []v1.Container{{Name: "c"}}}},
+			nodeInfo: makeNodeInfo("1", "1Gi", makePod("existing", "1", "1Gi", false)),
+			wantCode: framework.Success,
+		},
+		{
+			name:     "not enough cpu left",
+			pod:      makePod("p", "1", "1Gi", true),
+			nodeInfo: makeNodeInfo("2", "4Gi", makePod("existing", "1500m", "1Gi", false)),
+			wantCode: framework.Unschedulable,
+			reasons:  []string{"Insufficient cpu"},
+		},
+		{
+			name:     "not enough cpu and memory left",
+			pod:      makePod("p", "1", "2Gi", true),
+			nodeInfo: makeNodeInfo("2", "2Gi", makePod("existing", "1500m", "1Gi", false)),
+			wantCode: framework.Unschedulable,
+			reasons:  []string{"Insufficient cpu", "Insufficient memory"},
+		},
+	}
+
+	s := &Sample{args: &pluginconfig.SampleArgs{}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			state := framework.NewCycleState()
+			if st := s.PreFilter(context.Background(), state, tt.pod); !st.IsSuccess() {
+				t.Fatalf("PreFilter: %v", st)
+			}
+			st := s.Filter(context.Background(), state, tt.pod, tt.nodeInfo)
+			if st.Code() != tt.wantCode {
+				t.Fatalf("expected code %v, got %v", tt.wantCode, st)
+			}
+			if st.IsSuccess() {
+				return
+			}
+			if len(st.Reasons()) != len(tt.reasons) {
+				t.Fatalf("expected reasons %v, got %v", tt.reasons, st.Reasons())
+			}
+			for i := range tt.reasons {
+				if st.Reasons()[i] != tt.reasons[i] {
+					t.Errorf("expected reasons %v, got %v", tt.reasons, st.Reasons())
+				}
+			}
+		})
+	}
+}
+
+// 抢占模拟: 在 clone 的 nodeInfo 和 CycleState 上移除 victim 后 pod 可以调度
+func TestFilterPreemptionSimulation(t *testing.T) {
+	s := &Sample{args: &pluginconfig.SampleArgs{}}
+	pod := makePod("p", "1", "1Gi", true)
+	victim := makePod("victim", "1500m", "1Gi", false)
+	nodeInfo := makeNodeInfo("2", "4Gi", victim)
+
+	state := framework.NewCycleState()
+	s.PreFilter(context.Background(), state, pod)
+	if st := s.Filter(context.Background(), state, pod, nodeInfo); st.Code() != framework.Unschedulable {
+		t.Fatalf("expected Unschedulable before preemption, got %v", st)
+	}
+
+	stateCopy := state.Clone()
+	nodeInfoCopy := nodeInfo.Clone()
+	if err := nodeInfoCopy.RemovePod(victim); err != nil {
+		t.Fatal(err)
+	}
+	if st := s.PreFilterExtensions().RemovePod(context.Background(), stateCopy, pod, victim, nodeInfoCopy); !st.IsSuccess() {
+		t.Fatalf("RemovePod: %v", st)
+	}
+	if st := s.Filter(context.Background(), stateCopy, pod, nodeInfoCopy); !st.IsSuccess() {
+		t.Fatalf("expected pod to fit after removing the victim, got %v", st)
+	}
+
+	nodeInfoCopy.AddPod(victim)
+	if st := s.PreFilterExtensions().AddPod(context.Background(), stateCopy, pod, victim, nodeInfoCopy); !st.IsSuccess() {
+		t.Fatalf("AddPod: %v", st)
+	}
+	if st := s.Filter(context.Background(), stateCopy, pod, nodeInfoCopy); st.Code() != framework.Unschedulable {
+		t.Fatalf("expected Unschedulable after adding the victim back, got %v", st)
+	}
+
+	// 没有 PreFilter 的状态时返回错误
+	if st := s.RemovePod(context.Background(), framework.NewCycleState(), pod, victim, nodeInfo); st.Code() != framework.Error {
+		t.Errorf("expected Error without prefilter state, got %v", st)
+	}
+}
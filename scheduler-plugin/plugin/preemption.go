@@ -0,0 +1,280 @@
+package plugins
+
+import (
+	"context"
+	"fmt"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	policylisters "k8s.io/client-go/listers/policy/v1beta1"
+	"k8s.io/klog/v2"
+	podutil "k8s.io/kubernetes/pkg/api/v1/pod"
+	framework "k8s.io/kubernetes/pkg/scheduler/framework/v1alpha1"
+
+	pluginconfig "schedulePlugin/apis/config"
+	"schedulePlugin/preemption"
+)
+
+// postfilter
+// 高优先级的 pod 没有通过 Filter 时,抢占节点上优先级更低的 pod
+// 在 clone 的 nodeInfo 上按 victim 顺序逐个移除,直到 pod 可以调度,再尽量把重要的 victim 放回去
+// 选择违反 PodDisruptionBudget 最少、victim 最少的节点,通过 Eviction 驱逐 victim 并提名节点
+// 选中的节点仍然违反 PodDisruptionBudget 时不驱逐,这次抢占失败,pod 等待下一次调度
+// apiserver 驱逐时仍然检查 PodDisruptionBudget,已经驱逐了一部分 victim 时仍然提名节点
+// dry run 时只打印选择的结果,用来调整 victim 的顺序
+var _ framework.PostFilterPlugin = &Sample{}
+
+// nodeVictims 一个节点上需要抢占的 pod
+type nodeVictims struct {
+	nodeName string
+	pods     []*v1.Pod
+	// 驱逐这些 pod 会违反 PodDisruptionBudget 的数量
+	pdbViolations int
+}
+
+// 违反 PodDisruptionBudget 少的更好,相同时 victim 少的更好
+func (v *nodeVictims) betterThan(other *nodeVictims) bool {
+	if v.pdbViolations != other.pdbViolations {
+		return v.pdbViolations < other.pdbViolations
+	}
+	return len(v.pods) < len(other.pods)
+}
+
+func (s *Sample) PostFilter(ctx context.Context, state *framework.CycleState, pod *v1.Pod, filteredNodeStatusMap framework.NodeToStatusMap) (*framework.PostFilterResult, *framework.Status) {
+	if msg, ok := s.eligibleToPreempt(pod); !ok {
+		return nil, framework.NewStatus(framework.Unschedulable, msg)
+	}
+	nodeInfos, err := s.handle.SnapshotSharedLister().NodeInfos().List()
+	if err != nil {
+		return nil, framework.NewStatus(framework.Error, err.Error())
+	}
+	budgets, err := s.pdbBudgets()
+	if err != nil {
+		return nil, framework.NewStatus(framework.Error, err.Error())
+	}
+
+	var best *nodeVictims
+	for _, nodeInfo := range nodeInfos {
+		if status, ok := filteredNodeStatusMap[nodeInfo.Node().Name]; ok && status.Code() == framework.UnschedulableAndUnresolvable {
+			continue
+		}
+		victims, err := s.selectVictimsOnNode(ctx, state, pod, nodeInfo, budgets)
+		if err != nil {
+			return nil, framework.NewStatus(framework.Error, err.Error())
+		}
+		if victims != nil && (best == nil || victims.betterThan(best)) {
+			best = victims
+		}
+	}
+	if best == nil {
+		return nil, framework.NewStatus(framework.Unschedulable, "抢占低优先级的 pod 之后也没有可以调度的节点")
+	}
+
+	if s.args.PreemptionDryRun {
+		klog.InfoS("Preemption dry run", "pod", klog.KObj(pod), "node", best.nodeName, "victims", podNames(best.pods),
+			"pdbViolations", best.pdbViolations, "victimOrder", s.args.PreemptionVictimOrder)
+		return nil, framework.NewStatus(framework.Unschedulable, fmt.Sprintf("dry run: 抢占节点 %s 上的 %d 个 pod", best.nodeName, len(best.pods)))
+	}
+	// apiserver 会拒绝违反 PodDisruptionBudget 的驱逐,驱逐任何 victim 之前就放弃,不会只驱逐一部分
+	if best.pdbViolations > 0 {
+		return nil, framework.NewStatus(framework.Unschedulable,
+			fmt.Sprintf("抢占节点 %s 上的 pod 会违反 %d 个 PodDisruptionBudget", best.nodeName, best.pdbViolations))
+	}
+	result, status := preemption.EvictAll(ctx, s.handle, Name, pod, best.nodeName, best.pods)
+	if status.IsSuccess() {
+		klog.V(3).InfoS("Preempted lower priority pods", "pod", klog.KObj(pod), "node", best.nodeName, "victims", len(best.pods))
+	}
+	return result, status
+}
+
+// 不允许抢占的 pod,或者上一次抢占的 victim 还在退出,不再抢占
+func (s *Sample) eligibleToPreempt(pod *v1.Pod) (string, bool) {
+	if pod.Spec.PreemptionPolicy != nil && *pod.Spec.PreemptionPolicy == v1.PreemptNever {
+		return fmt.Sprintf("pod %s/%s 的 preemptionPolicy 是 Never", pod.Namespace, pod.Name), false
+	}
+	nominatedNodeName := pod.Status.NominatedNodeName
+	if nominatedNodeName == "" {
+		return "", true
+	}
+	nodeInfo, err := s.handle.SnapshotSharedLister().NodeInfos().Get(nominatedNodeName)
+	if err != nil {
+		return "", true
+	}
+	priority := podutil.GetPodPriority(pod)
+	for _, podInfo := range nodeInfo.Pods {
+		if podInfo.Pod.DeletionTimestamp != nil && podutil.GetPodPriority(podInfo.Pod) < priority {
+			return fmt.Sprintf("等待节点 %s 上被抢占的 pod 退出", nominatedNodeName), false
+		}
+	}
+	return "", true
+}
+
+// 在 clone 的 nodeInfo 和 CycleState 上模拟移除 victim,pod 不能调度返回 nil
+func (s *Sample) selectVictimsOnNode(ctx context.Context, state *framework.CycleState, pod *v1.Pod, nodeInfo *framework.NodeInfo, budgets []*pdbBudget) (*nodeVictims, error) {
+	ph := s.handle.PreemptHandle()
+	nodeInfoCopy := nodeInfo.Clone()
+	stateCopy := state.Clone()
+	fits := func() bool {
+		return ph.RunFilterPlugins(ctx, stateCopy, pod, nodeInfoCopy).Merge().IsSuccess()
+	}
+	removePod := func(victim *v1.Pod) error {
+		if err := nodeInfoCopy.RemovePod(victim); err != nil {
+			return err
+		}
+		return ph.RunPreFilterExtensionRemovePod(ctx, stateCopy, pod, victim, nodeInfoCopy).AsError()
+	}
+	addPod := func(victim *v1.Pod) error {
+		nodeInfoCopy.AddPod(victim)
+		return ph.RunPreFilterExtensionAddPod(ctx, stateCopy, pod, victim, nodeInfoCopy).AsError()
+	}
+
+	priority := podutil.GetPodPriority(pod)
+	var candidates []*v1.Pod
+	for _, podInfo := range nodeInfo.Pods {
+		if podutil.GetPodPriority(podInfo.Pod) < priority {
+			candidates = append(candidates, podInfo.Pod)
+		}
+	}
+	preemption.SortVictims(candidates)
+
+	// 按顺序移除,直到 pod 可以调度
+	simulated := copyBudgets(budgets)
+	var removed []*v1.Pod
+	for !fits() {
+		if len(candidates) == 0 {
+			return nil, nil
+		}
+		i := s.nextVictim(candidates, simulated)
+		victim := candidates[i]
+		candidates = append(candidates[:i], candidates[i+1:]...)
+		if err := removePod(victim); err != nil {
+			return nil, err
+		}
+		consumeBudgets(victim, simulated)
+		removed = append(removed, victim)
+	}
+
+	// 后移除的 pod 更重要,先尝试放回去,放回之后不能调度的才是 victim
+	var victims []*v1.Pod
+	for i := len(removed) - 1; i >= 0; i-- {
+		if err := addPod(removed[i]); err != nil {
+			return nil, err
+		}
+		if fits() {
+			continue
+		}
+		if err := removePod(removed[i]); err != nil {
+			return nil, err
+		}
+		victims = append(victims, removed[i])
+	}
+
+	result := &nodeVictims{nodeName: nodeInfo.Node().Name}
+	simulated = copyBudgets(budgets)
+	for i := len(victims) - 1; i >= 0; i-- {
+		if violatesBudgets(victims[i], simulated) {
+			result.pdbViolations++
+		}
+		consumeBudgets(victims[i], simulated)
+		result.pods = append(result.pods, victims[i])
+	}
+	return result, nil
+}
+
+// 下一个移除的 candidate,candidates 已经按优先级和启动时间排序
+func (s *Sample) nextVictim(candidates []*v1.Pod, budgets []*pdbBudget) int {
+	if s.args.PreemptionVictimOrder == pluginconfig.VictimOrderFewestPDBViolations {
+		for i, candidate := range candidates {
+			if !violatesBudgets(candidate, budgets) {
+				return i
+			}
+		}
+	}
+	return 0
+}
+
+func podNames(pods []*v1.Pod) []string {
+	names := make([]string, 0, len(pods))
+	for _, pod := range pods {
+		names = append(names, pod.Namespace+"/"+pod.Name)
+	}
+	return names
+}
+
+// pdbBudget 一个 PodDisruptionBudget 还允许驱逐的 pod 数量
+type pdbBudget struct {
+	namespace string
+	selector  labels.Selector
+	// 已经被驱逐、PodDisruptionBudget 已经计算过的 pod
+	disrupted map[string]metav1.Time
+	allowed   int32
+}
+
+func (b *pdbBudget) matches(pod *v1.Pod) bool {
+	if b.namespace != pod.Namespace || !b.selector.Matches(labels.Set(pod.Labels)) {
+		return false
+	}
+	_, ok := b.disrupted[pod.Name]
+	return !ok
+}
+
+// 没有 informer 时(例如单元测试)不考虑 PodDisruptionBudget
+func (s *Sample) pdbBudgets() ([]*pdbBudget, error) {
+	if s.pdbLister == nil {
+		return nil, nil
+	}
+	pdbs, err := s.pdbLister.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	var budgets []*pdbBudget
+	for _, pdb := range pdbs {
+		selector, err := metav1.LabelSelectorAsSelector(pdb.Spec.Selector)
+		// 空的 selector 不匹配任何 pod
+		if err != nil || selector.Empty() {
+			continue
+		}
+		budgets = append(budgets, &pdbBudget{
+			namespace: pdb.Namespace,
+			selector:  selector,
+			disrupted: pdb.Status.DisruptedPods,
+			allowed:   pdb.Status.DisruptionsAllowed,
+		})
+	}
+	return budgets, nil
+}
+
+func copyBudgets(budgets []*pdbBudget) []*pdbBudget {
+	copied := make([]*pdbBudget, 0, len(budgets))
+	for _, b := range budgets {
+		c := *b
+		copied = append(copied, &c)
+	}
+	return copied
+}
+
+func violatesBudgets(pod *v1.Pod, budgets []*pdbBudget) bool {
+	for _, b := range budgets {
+		if b.matches(pod) && b.allowed <= 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func consumeBudgets(pod *v1.Pod, budgets []*pdbBudget) {
+	for _, b := range budgets {
+		if b.matches(pod) {
+			b.allowed--
+		}
+	}
+}
+
+// 需要在 informer 启动之前注册
+func newPDBLister(f framework.FrameworkHandle) policylisters.PodDisruptionBudgetLister {
+	if f.SharedInformerFactory() == nil {
+		return nil
+	}
+	return f.SharedInformerFactory().Policy().V1beta1().PodDisruptionBudgets().Lister()
+}
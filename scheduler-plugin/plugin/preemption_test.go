@@ -0,0 +1,301 @@
+package plugins
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	policyv1beta1 "k8s.io/api/policy/v1beta1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/informers"
+	clientsetfake "k8s.io/client-go/kubernetes/fake"
+	clienttesting "k8s.io/client-go/testing"
+	"k8s.io/kubernetes/pkg/scheduler/apis/config"
+	frameworkruntime "k8s.io/kubernetes/pkg/scheduler/framework/runtime"
+	framework "k8s.io/kubernetes/pkg/scheduler/framework/v1alpha1"
+
+	"schedulePlugin/fake"
+)
+
+// nodeName 为空表示还没有调度,requests 和 limits 相同
+func makePriorityPod(name, cpu, nodeName string, priority int32, started time.Time) *v1.Pod {
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", UID: types.UID("uid-" + name), Labels: map[string]string{"app": name}},
+		Spec: v1.PodSpec{
+			NodeName: nodeName,
+			Priority: &priority,
+			Containers: []v1.Container{{
+				Name: "c",
+				Resources: v1.ResourceRequirements{
+					Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse(cpu)},
+					Limits:   v1.ResourceList{v1.ResourceCPU: resource.MustParse(cpu)},
+				},
+			}},
+		},
+		Status: v1.PodStatus{StartTime: &metav1.Time{Time: started}},
+	}
+}
+
+func makeCPUNode(name, cpu string) *v1.Node {
+	return &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Status: v1.NodeStatus{Allocatable: v1.ResourceList{
+			v1.ResourceCPU:  resource.MustParse(cpu),
+			v1.ResourcePods: resource.MustParse("110"),
+		}},
+	}
+}
+
+// 不允许驱逐 pod 的 PodDisruptionBudget
+func makeBlockingPDB(podName string) *policyv1beta1.PodDisruptionBudget {
+	return &policyv1beta1.PodDisruptionBudget{
+		ObjectMeta: metav1.ObjectMeta{Name: podName, Namespace: "default"},
+		Spec:       policyv1beta1.PodDisruptionBudgetSpec{Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": podName}}},
+		Status:     policyv1beta1.PodDisruptionBudgetStatus{DisruptionsAllowed: 0},
+	}
+}
+
+type preemptionFramework struct {
+	framework.Framework
+	clientSet *clientsetfake.Clientset
+}
+
+func newPreemptionFramework(t *testing.T, args string, nodes []*v1.Node, pods []*v1.Pod, pdbs ...*policyv1beta1.PodDisruptionBudget) *preemptionFramework {
+	t.Helper()
+	var objs []runtime.Object
+	for _, pod := range pods {
+		objs = append(objs, pod)
+	}
+	for _, pdb := range pdbs {
+		objs = append(objs, pdb)
+	}
+	clientSet := clientsetfake.NewSimpleClientset(objs...)
+	fake.AddEvictionReactor(clientSet)
+	informerFactory := informers.NewSharedInformerFactory(clientSet, 0)
+	registry := frameworkruntime.Registry{
+		Name: func(_ runtime.Object, f framework.FrameworkHandle) (framework.Plugin, error) {
+			return New(&runtime.Unknown{Raw: []byte(args), ContentType: runtime.ContentTypeJSON}, f)
+		},
+	}
+	plugins := &config.Plugins{
+		PreFilter:  &config.PluginSet{Enabled: []config.Plugin{{Name: Name}}},
+		Filter:     &config.PluginSet{Enabled: []config.Plugin{{Name: Name}}},
+		PostFilter: &config.PluginSet{Enabled: []config.Plugin{{Name: Name}}},
+	}
+	fwk, err := fake.NewFramework(registry, plugins,
+		frameworkruntime.WithClientSet(clientSet),
+		frameworkruntime.WithInformerFactory(informerFactory),
+		frameworkruntime.WithSnapshotSharedLister(fake.NewSharedLister(nodes, pods)))
+	if err != nil {
+		t.Fatalf("NewFramework: %v", err)
+	}
+	stopCh := make(chan struct{})
+	t.Cleanup(func() { close(stopCh) })
+	informerFactory.Start(stopCh)
+	informerFactory.WaitForCacheSync(stopCh)
+	return &preemptionFramework{Framework: fwk, clientSet: clientSet}
+}
+
+// 跑 PreFilter 和 PostFilter,所有节点都按 Filter 失败处理
+func (pf *preemptionFramework) preempt(t *testing.T, pod *v1.Pod, nodes ...string) (*framework.PostFilterResult, *framework.Status) {
+	t.Helper()
+	ctx := context.Background()
+	state := framework.NewCycleState()
+	if st := pf.RunPreFilterPlugins(ctx, state, pod); !st.IsSuccess() {
+		t.Fatalf("PreFilter: %v", st)
+	}
+	statusMap := framework.NodeToStatusMap{}
+	for _, node := range nodes {
+		statusMap[node] = framework.NewStatus(framework.Unschedulable, "Insufficient cpu")
+	}
+	return pf.RunPostFilterPlugins(ctx, state, pod, statusMap)
+}
+
+// 检查哪些 pod 被驱逐了
+func (pf *preemptionFramework) expectPods(t *testing.T, evicted []string, kept []string) {
+	t.Helper()
+	for _, name := range evicted {
+		if _, err := pf.clientSet.CoreV1().Pods("default").Get(context.Background(), name, metav1.GetOptions{}); !errors.IsNotFound(err) {
+			t.Errorf("expected %s to be preempted, got %v", name, err)
+		}
+	}
+	for _, name := range kept {
+		if _, err := pf.clientSet.CoreV1().Pods("default").Get(context.Background(), name, metav1.GetOptions{}); err != nil {
+			t.Errorf("expected %s to be kept, got %v", name, err)
+		}
+	}
+}
+
+func TestPostFilterVictimOrder(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name string
+		args string
+		// 抢占的 pod 请求的 cpu 和不允许驱逐的 pod
+		request   string
+		blocked   string
+		wantCode  framework.Code
+		nominated string
+		evicted   []string
+		kept      []string
+	}{
+		{
+			// 启动晚的 young 是 victim,违反 PodDisruptionBudget,不驱逐
+			name:     "youngest first",
+			args:     `{}`,
+			request:  "1",
+			blocked:  "young",
+			wantCode: framework.Unschedulable,
+			kept:     []string{"old", "young", "mid"},
+		},
+		{
+			// young 不允许驱逐,改为抢占 old
+			name:      "fewest pdb violations",
+			args:      `{"preemptionVictimOrder": "FewestPDBViolations"}`,
+			request:   "1",
+			blocked:   "young",
+			wantCode:  framework.Success,
+			nominated: "node-1",
+			evicted:   []string{"old"},
+			kept:      []string{"young", "mid"},
+		},
+		{
+			// young 和 old 都是 victim,old 不允许驱逐,驱逐之前就放弃,young 也不驱逐
+			name:     "checks budgets before evicting",
+			args:     `{}`,
+			request:  "2",
+			blocked:  "old",
+			wantCode: framework.Unschedulable,
+			kept:     []string{"old", "young", "mid"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pods := []*v1.Pod{
+				makePriorityPod("old", "1", "node-1", 0, now.Add(-time.Hour)),
+				makePriorityPod("young", "1", "node-1", 0, now),
+				makePriorityPod("mid", "1", "node-1", 5, now),
+			}
+			pf := newPreemptionFramework(t, tt.args, []*v1.Node{makeCPUNode("node-1", "3")}, pods, makeBlockingPDB(tt.blocked))
+
+			result, st := pf.preempt(t, makePriorityPod("high", tt.request, "", 10, now), "node-1")
+			if st.Code() != tt.wantCode {
+				t.Fatalf("expected code %v, got %v", tt.wantCode, st)
+			}
+			if result != nil && result.NominatedNodeName != tt.nominated {
+				t.Errorf("expected %q to be nominated, got %q", tt.nominated, result.NominatedNodeName)
+			}
+			pf.expectPods(t, tt.evicted, tt.kept)
+		})
+	}
+}
+
+// 计算 victim 之后 PodDisruptionBudget 发生了变化,已经驱逐了一部分 victim 时仍然提名节点
+func TestPostFilterNominatesAfterPartialEviction(t *testing.T) {
+	now := time.Now()
+	pods := []*v1.Pod{
+		makePriorityPod("old", "1", "node-1", 0, now.Add(-time.Hour)),
+		makePriorityPod("young", "1", "node-1", 0, now),
+	}
+	pf := newPreemptionFramework(t, `{}`, []*v1.Node{makeCPUNode("node-1", "2")}, pods)
+	pf.clientSet.PrependReactor("create", "pods", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		if create := action.(clienttesting.CreateAction); create.GetSubresource() == "eviction" && create.GetObject().(*policyv1beta1.Eviction).Name == "old" {
+			return true, nil, errors.NewTooManyRequests("Cannot evict pod as it would violate the pod's disruption budget.", 0)
+		}
+		return false, nil, nil
+	})
+
+	result, st := pf.preempt(t, makePriorityPod("high", "2", "", 10, now), "node-1")
+	if !st.IsSuccess() {
+		t.Fatalf("expected preemption to succeed, got %v", st)
+	}
+	if result == nil || result.NominatedNodeName != "node-1" {
+		t.Errorf("expected node-1 to be nominated, got %+v", result)
+	}
+	pf.expectPods(t, []string{"young"}, []string{"old"})
+}
+
+func TestPostFilterReprievesVictims(t *testing.T) {
+	now := time.Now()
+	// 先移除优先级最低的 small 还放不下,再移除 big 之后 small 可以放回去
+	pods := []*v1.Pod{
+		makePriorityPod("small", "1", "node-1", 0, now),
+		makePriorityPod("big", "2", "node-1", 1, now),
+	}
+	pf := newPreemptionFramework(t, `{}`, []*v1.Node{makeCPUNode("node-1", "3")}, pods)
+
+	if _, st := pf.preempt(t, makePriorityPod("high", "2", "", 10, now), "node-1"); !st.IsSuccess() {
+		t.Fatalf("expected preemption to succeed, got %v", st)
+	}
+	pf.expectPods(t, []string{"big"}, []string{"small"})
+}
+
+func TestPostFilterPrefersFewestVictims(t *testing.T) {
+	now := time.Now()
+	pods := []*v1.Pod{
+		makePriorityPod("a1", "1", "node-a", 0, now),
+		makePriorityPod("a2", "1", "node-a", 0, now),
+		makePriorityPod("b1", "2", "node-b", 0, now),
+	}
+	nodes := []*v1.Node{makeCPUNode("node-a", "2"), makeCPUNode("node-b", "2")}
+	pf := newPreemptionFramework(t, `{}`, nodes, pods)
+
+	result, st := pf.preempt(t, makePriorityPod("high", "2", "", 10, now), "node-a", "node-b")
+	if !st.IsSuccess() {
+		t.Fatalf("expected preemption to succeed, got %v", st)
+	}
+	if result.NominatedNodeName != "node-b" {
+		t.Errorf("expected node-b to be nominated, got %q", result.NominatedNodeName)
+	}
+	pf.expectPods(t, []string{"b1"}, []string{"a1", "a2"})
+}
+
+func TestPostFilterNotPreempted(t *testing.T) {
+	now := time.Now()
+	never := v1.PreemptNever
+	tests := []struct {
+		name string
+		args string
+		pod  func() *v1.Pod
+	}{
+		{
+			name: "no lower priority pods",
+			args: `{}`,
+			pod:  func() *v1.Pod { return makePriorityPod("low", "1", "", 0, now) },
+		},
+		{
+			name: "preemption policy never",
+			args: `{}`,
+			pod: func() *v1.Pod {
+				pod := makePriorityPod("high", "1", "", 10, now)
+				pod.Spec.PreemptionPolicy = &never
+				return pod
+			},
+		},
+		{
+			name: "dry run",
+			args: `{"preemptionDryRun": true}`,
+			pod:  func() *v1.Pod { return makePriorityPod("high", "1", "", 10, now) },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pods := []*v1.Pod{makePriorityPod("running", "1", "node-1", 5, now)}
+			pf := newPreemptionFramework(t, tt.args, []*v1.Node{makeCPUNode("node-1", "1")}, pods)
+
+			result, st := pf.preempt(t, tt.pod(), "node-1")
+			if st.Code() != framework.Unschedulable {
+				t.Errorf("expected Unschedulable, got %v", st)
+			}
+			if result != nil && result.NominatedNodeName != "" {
+				t.Errorf("expected no nominated node, got %q", result.NominatedNodeName)
+			}
+			pf.expectPods(t, nil, []string{"running"})
+		})
+	}
+}
@@ -0,0 +1,153 @@
+package plugins
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+	framework "k8s.io/kubernetes/pkg/scheduler/framework/v1alpha1"
+)
+
+// reserve unreserve postbind
+// 设备 agent 在 kubelet 之外维护的扩展资源,pod 从 reserve 到 bind 完成之间可能被下一个调度周期重复分配
+// 插件用本地账本记录每个节点上已经 reserve 的数量,filter 时扣掉账本中的数量
+// unreserve 或者 bind 成功之后从账本中释放,bind 之后 pod 已经在调度快照的 nodeInfo 中了
+var _ framework.ReservePlugin = &Sample{}
+var _ framework.PostBindPlugin = &Sample{}
+
+const ledgerStateKey = "Ledger" + Name
+
+// ledger 每个节点上已经 reserve 还没有 bind 的数量
+type ledger struct {
+	mutex sync.RWMutex
+	// node name -> pod uid -> 数量
+	reserved map[string]map[types.UID]int64
+}
+
+func newLedger() *ledger {
+	return &ledger{reserved: map[string]map[types.UID]int64{}}
+}
+
+// 记录 pod 在节点上 reserve 的数量,重复调用以最后一次为准
+func (l *ledger) reserve(nodeName string, uid types.UID, amount int64) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	pods, ok := l.reserved[nodeName]
+	if !ok {
+		pods = map[types.UID]int64{}
+		l.reserved[nodeName] = pods
+	}
+	pods[uid] = amount
+}
+
+// 释放 pod 在节点上 reserve 的数量,没有记录时什么也不做
+func (l *ledger) release(nodeName string, uid types.UID) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	pods := l.reserved[nodeName]
+	delete(pods, uid)
+	if len(pods) == 0 {
+		delete(l.reserved, nodeName)
+	}
+}
+
+// 复制一份写到 CycleState 中,同一个调度周期内 filter 看到的账本不变
+func (l *ledger) snapshot() *ledgerState {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+	s := &ledgerState{reserved: make(map[string]map[types.UID]int64, len(l.reserved))}
+	for nodeName, pods := range l.reserved {
+		copied := make(map[types.UID]int64, len(pods))
+		for uid, amount := range pods {
+			copied[uid] = amount
+		}
+		s.reserved[nodeName] = copied
+	}
+	return s
+}
+
+// ledgerState PreFilter 时账本的快照
+type ledgerState struct {
+	reserved map[string]map[types.UID]int64
+}
+
+// 快照不会被修改,不需要拷贝
+func (s *ledgerState) Clone() framework.StateData {
+	return s
+}
+
+func getLedgerState(state *framework.CycleState) (*ledgerState, error) {
+	data, err := state.Read(ledgerStateKey)
+	if err != nil {
+		return nil, err
+	}
+	s, ok := data.(*ledgerState)
+	if !ok {
+		return nil, fmt.Errorf("%+v convert to SamplePlugin ledgerState error", data)
+	}
+	return s, nil
+}
+
+// pod 申请的数量,扩展资源的 requests 和 limits 相等,这里用 limits
+func (s *Sample) reservedAmount(preState *preFilterState) int64 {
+	return preState.ScalarResources[v1.ResourceName(s.args.ReservedResourceName)]
+}
+
+// 节点上剩余的数量 = allocatable - 已经 bind 的 pod - 账本中 reserve 的数量
+// assume 的 pod 已经在 nodeInfo 中了,和账本重复的不计算
+func (s *Sample) ledgerFits(state *framework.CycleState, amount int64, nodeInfo *framework.NodeInfo) (bool, error) {
+	ledgerState, err := getLedgerState(state)
+	if err != nil {
+		return false, err
+	}
+	name := v1.ResourceName(s.args.ReservedResourceName)
+	reserved := ledgerState.reserved[nodeInfo.Node().Name]
+
+	var used int64
+	for _, podInfo := range nodeInfo.Pods {
+		if _, ok := reserved[podInfo.Pod.UID]; ok {
+			continue
+		}
+		used += computePodResourceLimit(podInfo.Pod).ScalarResources[name]
+	}
+	for _, amount := range reserved {
+		used += amount
+	}
+	return amount <= nodeInfo.Allocatable.ScalarResources[name]-used, nil
+}
+
+func (s *Sample) Reserve(ctx context.Context, state *framework.CycleState, pod *v1.Pod, nodeName string) *framework.Status {
+	if s.args.ReservedResourceName == "" {
+		return nil
+	}
+	preState, err := getPreFilterState(state)
+	if err != nil {
+		return framework.NewStatus(framework.Error, err.Error())
+	}
+	if amount := s.reservedAmount(preState); amount > 0 {
+		s.ledger.reserve(nodeName, pod.UID, amount)
+		if klog.V(4).Enabled() {
+			klog.InfoS("Reserve extended resource", "pod", klog.KObj(pod), "node", nodeName, "resource", s.args.ReservedResourceName, "amount", amount)
+		}
+	}
+	return nil
+}
+
+// 可能在没有 Reserve 的情况下调用,release 是幂等的
+func (s *Sample) Unreserve(ctx context.Context, state *framework.CycleState, pod *v1.Pod, nodeName string) {
+	if s.args.ReservedResourceName == "" {
+		return
+	}
+	s.ledger.release(nodeName, pod.UID)
+}
+
+// bind 成功之后 pod 由调度器缓存计入 nodeInfo,从账本中释放
+func (s *Sample) PostBind(ctx context.Context, state *framework.CycleState, pod *v1.Pod, nodeName string) {
+	if s.args.ReservedResourceName == "" {
+		return
+	}
+	s.ledger.release(nodeName, pod.UID)
+}
@@ -0,0 +1,139 @@
+package plugins
+
+import (
+	"context"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/kubernetes/pkg/scheduler/apis/config"
+	frameworkruntime "k8s.io/kubernetes/pkg/scheduler/framework/runtime"
+	framework "k8s.io/kubernetes/pkg/scheduler/framework/v1alpha1"
+
+	pluginconfig "schedulePlugin/apis/config"
+	"schedulePlugin/fake"
+)
+
+const deviceResource = "example.com/device"
+
+func makeDevicePod(name, devices string) *v1.Pod {
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", UID: types.UID("uid-" + name)},
+		Spec: v1.PodSpec{Containers: []v1.Container{{
+			Name:      "c",
+			Resources: v1.ResourceRequirements{Limits: v1.ResourceList{deviceResource: resource.MustParse(devices)}},
+		}}},
+	}
+}
+
+// 调度快照不会更新,模拟 bind 完成之前下一个调度周期看到的 nodeInfo 中还没有上一个 pod
+func newLedgerFramework(t *testing.T, devices string) (framework.Framework, *framework.NodeInfo) {
+	t.Helper()
+	node := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node-1"},
+		Status: v1.NodeStatus{Allocatable: v1.ResourceList{
+			deviceResource:  resource.MustParse(devices),
+			v1.ResourcePods: resource.MustParse("110"),
+		}},
+	}
+	snapshot := fake.NewSharedLister([]*v1.Node{node}, nil)
+	registry := frameworkruntime.Registry{
+		Name: func(_ runtime.Object, f framework.FrameworkHandle) (framework.Plugin, error) {
+			return New(&runtime.Unknown{Raw: []byte(`{"reservedResourceName": "` + deviceResource + `"}`), ContentType: runtime.ContentTypeJSON}, f)
+		},
+	}
+	plugins := &config.Plugins{
+		PreFilter: &config.PluginSet{Enabled: []config.Plugin{{Name: Name}}},
+		Filter:    &config.PluginSet{Enabled: []config.Plugin{{Name: Name}}},
+		Reserve:   &config.PluginSet{Enabled: []config.Plugin{{Name: Name}}},
+		PostBind:  &config.PluginSet{Enabled: []config.Plugin{{Name: Name}}},
+	}
+	fwk, err := fake.NewFramework(registry, plugins, frameworkruntime.WithSnapshotSharedLister(snapshot))
+	if err != nil {
+		t.Fatalf("NewFramework: %v", err)
+	}
+	nodeInfo, err := snapshot.Get("node-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fwk, nodeInfo
+}
+
+// 跑一个调度周期的 PreFilter 和 Filter
+func runFilter(t *testing.T, fwk framework.Framework, pod *v1.Pod, nodeInfo *framework.NodeInfo) (*framework.CycleState, *framework.Status) {
+	t.Helper()
+	state := framework.NewCycleState()
+	if st := fwk.RunPreFilterPlugins(context.Background(), state, pod); !st.IsSuccess() {
+		t.Fatalf("PreFilter: %v", st)
+	}
+	return state, fwk.RunFilterPlugins(context.Background(), state, pod, nodeInfo).Merge()
+}
+
+func TestLedgerPreventsDoubleBooking(t *testing.T) {
+	fwk, nodeInfo := newLedgerFramework(t, "1")
+	ctx := context.Background()
+	p1, p2 := makeDevicePod("p1", "1"), makeDevicePod("p2", "1")
+
+	state, st := runFilter(t, fwk, p1, nodeInfo)
+	if !st.IsSuccess() {
+		t.Fatalf("expected p1 to fit, got %v", st)
+	}
+	if st := fwk.RunReservePluginsReserve(ctx, state, p1, "node-1"); !st.IsSuccess() {
+		t.Fatalf("Reserve: %v", st)
+	}
+
+	// p1 还在 bind,快照中没有 p1,只有账本知道设备已经被占用
+	if _, st := runFilter(t, fwk, p2, nodeInfo); st.Code() != framework.Unschedulable {
+		t.Fatalf("expected p2 to be rejected while p1 is reserved, got %v", st)
+	}
+
+	// p1 bind 失败,释放之后 p2 可以调度
+	fwk.RunReservePluginsUnreserve(ctx, state, p1, "node-1")
+	if _, st := runFilter(t, fwk, p2, nodeInfo); !st.IsSuccess() {
+		t.Errorf("expected p2 to fit after p1 was unreserved, got %v", st)
+	}
+}
+
+func TestLedgerReleasedOnBind(t *testing.T) {
+	fwk, nodeInfo := newLedgerFramework(t, "2")
+	ctx := context.Background()
+	p1, p2 := makeDevicePod("p1", "1"), makeDevicePod("p2", "2")
+
+	state, _ := runFilter(t, fwk, p1, nodeInfo)
+	if st := fwk.RunReservePluginsReserve(ctx, state, p1, "node-1"); !st.IsSuccess() {
+		t.Fatalf("Reserve: %v", st)
+	}
+	// assume 之后 p1 同时在快照和账本中,只计算一次
+	assumed := p1.DeepCopy()
+	assumed.Spec.NodeName = "node-1"
+	nodeInfo.AddPod(assumed)
+	if _, st := runFilter(t, fwk, makeDevicePod("p3", "1"), nodeInfo); !st.IsSuccess() {
+		t.Fatalf("expected one device left, got %v", st)
+	}
+
+	// bind 之后从账本中释放,p1 由快照计算
+	fwk.RunPostBindPlugins(ctx, state, p1, "node-1")
+	if _, st := runFilter(t, fwk, p2, nodeInfo); st.Code() != framework.Unschedulable {
+		t.Errorf("expected p2 to be rejected by the bound p1, got %v", st)
+	}
+	if err := nodeInfo.RemovePod(assumed); err != nil {
+		t.Fatal(err)
+	}
+	if _, st := runFilter(t, fwk, p2, nodeInfo); !st.IsSuccess() {
+		t.Errorf("expected p2 to fit once p1 is gone, got %v", st)
+	}
+}
+
+func TestLedgerDisabled(t *testing.T) {
+	s := &Sample{args: &pluginconfig.SampleArgs{}}
+	pod := makeDevicePod("p", "1")
+	state := framework.NewCycleState()
+	s.PreFilter(context.Background(), state, pod)
+	if st := s.Reserve(context.Background(), state, pod, "node-1"); !st.IsSuccess() {
+		t.Errorf("expected Reserve to be a no-op, got %v", st)
+	}
+	s.Unreserve(context.Background(), state, pod, "node-1")
+}
@@ -0,0 +1,75 @@
+package plugins
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+	framework "k8s.io/kubernetes/pkg/scheduler/framework/v1alpha1"
+)
+
+// score
+// 根据节点实际的 cpu 和内存利用率打分,利用率越低分数越高
+var _ framework.ScorePlugin = &Sample{}
+
+// 没有数据或者数据过期时的中间分数
+const neutralScore = framework.MaxNodeScore / 2
+
+func (s *Sample) Score(ctx context.Context, state *framework.CycleState, pod *v1.Pod, nodeName string) (int64, *framework.Status) {
+	nodeInfo, err := s.handle.SnapshotSharedLister().NodeInfos().Get(nodeName)
+	if err != nil {
+		return 0, framework.NewStatus(framework.Error, fmt.Sprintf("getting node %q from Snapshot: %v", nodeName, err))
+	}
+	node := nodeInfo.Node()
+	if node == nil {
+		return 0, framework.NewStatus(framework.Error, fmt.Sprintf("node %q not found", nodeName))
+	}
+
+	metrics, err := s.metrics.GetNodeMetrics(node)
+	if err != nil {
+		// 数据有问题不影响调度,按中间分数处理
+		klog.ErrorS(err, "Failed to get node metrics, fall back to neutral score", "node", nodeName)
+		return neutralScore, nil
+	}
+	if metrics == nil || s.now().Sub(metrics.Timestamp) > s.staleness() {
+		if klog.V(4).Enabled() {
+			klog.InfoS("Node metrics missing or stale, fall back to neutral score", "node", nodeName, "metrics", metrics)
+		}
+		return neutralScore, nil
+	}
+
+	score := s.loadScore(metrics)
+	if klog.V(4).Enabled() {
+		klog.InfoS("Score node", "pod", pod.Name, "node", nodeName, "metrics", metrics, "score", score)
+	}
+	return score, nil
+}
+
+// 按权重计算空闲资源的加权平均,范围 [0, MaxNodeScore]
+// 利用率达到阈值的节点直接打最低分
+func (s *Sample) loadScore(metrics *NodeMetrics) int64 {
+	if metrics.CPUUtilization >= float64(s.args.CPUUtilizationThreshold) || metrics.MemoryUtilization >= float64(s.args.MemoryUtilizationThreshold) {
+		return framework.MinNodeScore
+	}
+	cpuWeight, memoryWeight := s.args.CPUWeight, s.args.MemoryWeight
+	free := float64(cpuWeight)*(100-metrics.CPUUtilization) + float64(memoryWeight)*(100-metrics.MemoryUtilization)
+	score := int64(free / float64(cpuWeight+memoryWeight) * float64(framework.MaxNodeScore) / 100)
+	if score < framework.MinNodeScore {
+		return framework.MinNodeScore
+	}
+	if score > framework.MaxNodeScore {
+		return framework.MaxNodeScore
+	}
+	return score
+}
+
+func (s *Sample) staleness() time.Duration {
+	return time.Duration(s.args.MetricsStalenessSeconds) * time.Second
+}
+
+// 分数已经在 [0, MaxNodeScore] 范围内,不需要 normalize,中间分数不受其他节点分数的影响
+func (s *Sample) ScoreExtensions() framework.ScoreExtensions {
+	return nil
+}
@@ -0,0 +1,130 @@
+package plugins
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	frameworkruntime "k8s.io/kubernetes/pkg/scheduler/framework/runtime"
+	framework "k8s.io/kubernetes/pkg/scheduler/framework/v1alpha1"
+
+	"schedulePlugin/fake"
+)
+
+func makeNode(name string, annotations map[string]string) *v1.Node {
+	return &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: name, Annotations: annotations}}
+}
+
+// 实例化一个只带调度快照的 framework handle
+func newFakeHandle(t *testing.T, nodes []*v1.Node) framework.FrameworkHandle {
+	t.Helper()
+	fh, err := fake.NewFramework(nil, nil, frameworkruntime.WithSnapshotSharedLister(fake.NewSharedLister(nodes, nil)))
+	if err != nil {
+		t.Fatalf("NewFramework: %v", err)
+	}
+	return fh
+}
+
+func TestScore(t *testing.T) {
+	now := time.Date(2023, 7, 1, 12, 0, 0, 0, time.UTC)
+	nodes := []*v1.Node{makeNode("idle", nil), makeNode("busy", nil), makeNode("stale", nil), makeNode("unknown", nil), makeNode("hot", nil)}
+	source := NewFakeMetricsSource()
+	source.Set("idle", &NodeMetrics{CPUUtilization: 10, MemoryUtilization: 30, Timestamp: now.Add(-time.Minute)})
+	source.Set("busy", &NodeMetrics{CPUUtilization: 90, MemoryUtilization: 50, Timestamp: now.Add(-time.Minute)})
+	source.Set("stale", &NodeMetrics{CPUUtilization: 0, MemoryUtilization: 0, Timestamp: now.Add(-time.Hour)})
+	source.Set("hot", &NodeMetrics{CPUUtilization: 10, MemoryUtilization: 85, Timestamp: now.Add(-time.Minute)})
+
+	p, err := New(&runtime.Unknown{Raw: []byte(`{"cpuWeight": 3, "memoryWeight": 1, "memoryUtilizationThreshold": 80}`), ContentType: runtime.ContentTypeJSON}, newFakeHandle(t, nodes))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	s := p.(*Sample)
+	s.metrics = source
+	s.now = func() time.Time { return now }
+
+	want := map[string]int64{
+		// (3*90 + 1*70) / 4
+		"idle": 85,
+		// (3*10 + 1*50) / 4
+		"busy":    20,
+		"stale":   neutralScore,
+		"unknown": neutralScore,
+		// 内存利用率超过阈值
+		"hot": framework.MinNodeScore,
+	}
+	pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "p"}}
+	for _, node := range nodes {
+		score, st := s.Score(context.Background(), framework.NewCycleState(), pod, node.Name)
+		if !st.IsSuccess() {
+			t.Fatalf("Score %s: %v", node.Name, st)
+		}
+		if score != want[node.Name] {
+			t.Errorf("node %s: expected score %d, got %d", node.Name, want[node.Name], score)
+		}
+	}
+	// 不 normalize,中间分数是固定的
+	if s.ScoreExtensions() != nil {
+		t.Errorf("expected no score extensions")
+	}
+
+	if _, st := s.Score(context.Background(), framework.NewCycleState(), pod, "missing"); st.Code() != framework.Error {
+		t.Errorf("expected Error for a missing node, got %v", st)
+	}
+}
+
+func TestAnnotationMetricsSource(t *testing.T) {
+	source := NewAnnotationMetricsSource()
+	metrics, err := source.GetNodeMetrics(makeNode("n", map[string]string{
+		CPUUtilizationAnnotation:    "35.5",
+		MemoryUtilizationAnnotation: "60",
+		MetricsTimestampAnnotation:  "2023-07-01T12:00:00Z",
+	}))
+	if err != nil {
+		t.Fatalf("GetNodeMetrics: %v", err)
+	}
+	if metrics.CPUUtilization != 35.5 || metrics.MemoryUtilization != 60 || metrics.Timestamp.IsZero() {
+		t.Errorf("unexpected metrics: %+v", metrics)
+	}
+
+	if metrics, err := source.GetNodeMetrics(makeNode("n", nil)); err != nil || metrics != nil {
+		t.Errorf("expected no metrics for a node without annotations, got %+v, %v", metrics, err)
+	}
+	if _, err := source.GetNodeMetrics(makeNode("n", map[string]string{
+		CPUUtilizationAnnotation:    "135",
+		MemoryUtilizationAnnotation: "60",
+		MetricsTimestampAnnotation:  "2023-07-01T12:00:00Z",
+	})); err == nil {
+		t.Errorf("expected error for an invalid utilization")
+	}
+}
+
+func TestNewValidatesArgs(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    string
+		wantErr string
+	}{
+		{"defaults", `{}`, ""},
+		{"negative weight", `{"cpuWeight": -1}`, "cpuWeight: Invalid value: -1"},
+		{"both weights zero", `{"cpuWeight": 0, "memoryWeight": 0}`, "cpuWeight: Invalid value: 0"},
+		{"threshold out of range", `{"cpuUtilizationThreshold": 120}`, "cpuUtilizationThreshold: Invalid value: 120"},
+		{"unknown field", `{"favorColor": "#326CE5"}`, `unknown field "favorColor"`},
+		{"unknown victim order", `{"preemptionVictimOrder": "OldestFirst"}`, `preemptionVictimOrder: Unsupported value: "OldestFirst"`},
+	}
+	for _, tt := range tests {
+		_, err := New(&runtime.Unknown{Raw: []byte(tt.args), ContentType: runtime.ContentTypeJSON}, newFakeHandle(t, nil))
+		if tt.wantErr == "" {
+			if err != nil {
+				t.Errorf("%s: unexpected error: %v", tt.name, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("%s: expected error containing %q, got %v", tt.name, tt.wantErr, err)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package preemption
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	v1 "k8s.io/api/core/v1"
+	policyv1beta1 "k8s.io/api/policy/v1beta1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+	podutil "k8s.io/kubernetes/pkg/api/v1/pod"
+	framework "k8s.io/kubernetes/pkg/scheduler/framework/v1alpha1"
+)
+
+// 抢占插件共用的 victim 排序和驱逐
+
+// SortVictims 优先级低的在前,优先级相同时启动晚的在前
+func SortVictims(pods []*v1.Pod) {
+	sort.SliceStable(pods, func(i, j int) bool {
+		pi, pj := podutil.GetPodPriority(pods[i]), podutil.GetPodPriority(pods[j])
+		if pi != pj {
+			return pi < pj
+		}
+		return podStartTime(pods[i]).After(podStartTime(pods[j]).Time)
+	})
+}
+
+func podStartTime(pod *v1.Pod) *metav1.Time {
+	if pod.Status.StartTime != nil {
+		return pod.Status.StartTime
+	}
+	return &pod.CreationTimestamp
+}
+
+// Evict 通过 Eviction 子资源驱逐 victim,victim 还在 Permit 阶段等待时直接拒绝
+// apiserver 检查 PodDisruptionBudget,不允许驱逐时返回的错误满足 IsDisruptionBudgetError
+func Evict(ctx context.Context, handle framework.FrameworkHandle, pluginName string, preemptor, victim *v1.Pod) error {
+	if waitingPod := handle.GetWaitingPod(victim.UID); waitingPod != nil {
+		waitingPod.Reject("preempted by " + pluginName)
+		return nil
+	}
+	eviction := &policyv1beta1.Eviction{
+		ObjectMeta: metav1.ObjectMeta{Name: victim.Name, Namespace: victim.Namespace},
+	}
+	err := handle.ClientSet().PolicyV1beta1().Evictions(victim.Namespace).Evict(ctx, eviction)
+	if err != nil && !errors.IsNotFound(err) {
+		return fmt.Errorf("驱逐 victim %s/%s 失败: %w", victim.Namespace, victim.Name, err)
+	}
+	if recorder := handle.EventRecorder(); recorder != nil {
+		recorder.Eventf(victim, preemptor, v1.EventTypeNormal, "Preempted", "Preempting",
+			"Preempted by %s/%s on node %s (%s)", preemptor.Namespace, preemptor.Name, victim.Spec.NodeName, pluginName)
+	}
+	return nil
+}
+
+// EvictAll 按顺序驱逐 nodeName 上的 victim,全部驱逐后提名节点
+// 中途失败时已经驱逐的 victim 不能恢复,仍然提名节点,让释放的资源留给 preemptor
+func EvictAll(ctx context.Context, handle framework.FrameworkHandle, pluginName string, preemptor *v1.Pod, nodeName string, victims []*v1.Pod) (*framework.PostFilterResult, *framework.Status) {
+	for i, victim := range victims {
+		err := Evict(ctx, handle, pluginName, preemptor, victim)
+		if err == nil {
+			continue
+		}
+		if i > 0 {
+			klog.InfoS("Preemption partially failed, nominate the node for the evicted victims", "pod", klog.KObj(preemptor), "node", nodeName,
+				"evicted", i, "victims", len(victims), "err", err)
+			return &framework.PostFilterResult{NominatedNodeName: nodeName}, framework.NewStatus(framework.Success, "")
+		}
+		if IsDisruptionBudgetError(err) {
+			return nil, framework.NewStatus(framework.Unschedulable, err.Error())
+		}
+		return nil, framework.NewStatus(framework.Error, err.Error())
+	}
+	return &framework.PostFilterResult{NominatedNodeName: nodeName}, framework.NewStatus(framework.Success, "")
+}
+
+// IsDisruptionBudgetError apiserver 因为 PodDisruptionBudget 拒绝了驱逐
+func IsDisruptionBudgetError(err error) bool {
+	return errors.IsTooManyRequests(err)
+}
@@ -0,0 +1,134 @@
+package simulator
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	frameworkruntime "k8s.io/kubernetes/pkg/scheduler/framework/runtime"
+)
+
+// NewCommand simulate 子命令,outOfTree 是 main 中注册到调度器的插件
+//
+//	sample-scheduler simulate --config scheduler-config.yaml --nodes nodes.yaml --pods pods.yaml
+func NewCommand(outOfTree frameworkruntime.Registry) *cobra.Command {
+	var configFile, profileName string
+	var nodeFiles, podFiles, objectFiles []string
+	cmd := &cobra.Command{
+		Use:   "simulate",
+		Short: "Simulate scheduling pods from YAML files without a cluster",
+		Long: `Load nodes and pods from YAML files, build the scheduling framework of a profile
+in the scheduler config, schedule the pending pods in queue order and print the
+placement of each pod with the Filter rejection reasons and Score breakdown per node.
+Pods with spec.nodeName are treated as already running. PodGroups and
+ElasticQuotas are served to the plugins by a fake dynamic client. Ties are broken
+by node name and the current time is pinned to the newest metrics timestamp on
+the nodes, so the output is deterministic.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if configFile == "" {
+				return fmt.Errorf("--config is required")
+			}
+			cfg, err := LoadConfig(configFile)
+			if err != nil {
+				return err
+			}
+			objects, err := LoadObjects(append(append(nodeFiles, podFiles...), objectFiles...)...)
+			if err != nil {
+				return err
+			}
+			sim, err := New(cfg, profileName, outOfTree, objects)
+			if err != nil {
+				return err
+			}
+			defer sim.Close()
+			results, err := sim.Run(context.Background())
+			if err != nil {
+				return err
+			}
+			return PrintResults(cmd.OutOrStdout(), results)
+		},
+	}
+	// 调度器的根命令替换了 usage 和 help,子命令只打印自己的参数
+	usage := func(c *cobra.Command) string {
+		return fmt.Sprintf("Usage:\n  %s\n\nFlags:\n%s", c.UseLine(), c.LocalFlags().FlagUsages())
+	}
+	cmd.SetUsageFunc(func(c *cobra.Command) error {
+		fmt.Fprint(c.OutOrStderr(), usage(c))
+		return nil
+	})
+	cmd.SetHelpFunc(func(c *cobra.Command, _ []string) {
+		fmt.Fprintf(c.OutOrStdout(), "%s\n\n%s", c.Long, usage(c))
+	})
+
+	fs := cmd.Flags()
+	fs.StringVar(&configFile, "config", "", "KubeSchedulerConfiguration file")
+	fs.StringVar(&profileName, "profile", "", "schedulerName of the profile to simulate, defaults to the first profile")
+	fs.StringSliceVar(&nodeFiles, "nodes", nil, "YAML files with Node objects")
+	fs.StringSliceVar(&podFiles, "pods", nil, "YAML files with Pod objects")
+	fs.StringSliceVar(&objectFiles, "objects", nil, "YAML files with PodGroup and ElasticQuota objects")
+	return cmd
+}
+
+// PrintResults 打印模拟结果,节点和插件都按名字排序
+func PrintResults(w io.Writer, results []*Result) error {
+	for _, result := range results {
+		if result.NodeName != "" {
+			fmt.Fprintf(w, "pod %s: scheduled to %s\n", result.Pod, result.NodeName)
+		} else {
+			fmt.Fprintf(w, "pod %s: unschedulable: %s\n", result.Pod, result.Message)
+		}
+
+		if len(result.FilterReasons) > 0 {
+			fmt.Fprintln(w, "  filtered:")
+			var nodes []string
+			for node := range result.FilterReasons {
+				nodes = append(nodes, node)
+			}
+			sort.Strings(nodes)
+			for _, node := range nodes {
+				reasons := result.FilterReasons[node]
+				var plugins []string
+				for plugin := range reasons {
+					plugins = append(plugins, plugin)
+				}
+				sort.Strings(plugins)
+				var parts []string
+				for _, plugin := range plugins {
+					parts = append(parts, fmt.Sprintf("%s: %s", plugin, strings.Join(reasons[plugin], ", ")))
+				}
+				fmt.Fprintf(w, "    %s: %s\n", node, strings.Join(parts, "; "))
+			}
+		}
+
+		if len(result.Scores) > 0 {
+			fmt.Fprintln(w, "  scores:")
+			var nodes, plugins []string
+			for node := range result.Scores {
+				nodes = append(nodes, node)
+			}
+			sort.Strings(nodes)
+			for plugin := range result.Scores[nodes[0]] {
+				plugins = append(plugins, plugin)
+			}
+			sort.Strings(plugins)
+			tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+			fmt.Fprintf(tw, "    NODE\t%s\tTOTAL\n", strings.Join(plugins, "\t"))
+			for _, node := range nodes {
+				fmt.Fprintf(tw, "    %s", node)
+				for _, plugin := range plugins {
+					fmt.Fprintf(tw, "\t%d", result.Scores[node][plugin])
+				}
+				fmt.Fprintf(tw, "\t%d\n", totalScore(result.Scores[node]))
+			}
+			if err := tw.Flush(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
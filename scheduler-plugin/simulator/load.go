@@ -0,0 +1,138 @@
+package simulator
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/kubernetes/pkg/scheduler/apis/config"
+	kubeschedulerscheme "k8s.io/kubernetes/pkg/scheduler/apis/config/scheme"
+
+	"schedulePlugin/apis/scheduling/v1alpha1"
+)
+
+// LoadConfig 读取 KubeSchedulerConfiguration 文件,和调度器一样填充默认值
+func LoadConfig(path string) (*config.KubeSchedulerConfiguration, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	obj, gvk, err := kubeschedulerscheme.Codecs.UniversalDecoder().Decode(data, nil, nil)
+	if err != nil {
+		return nil, fmt.Errorf("解析调度器配置 %s 失败: %v", path, err)
+	}
+	cfg, ok := obj.(*config.KubeSchedulerConfiguration)
+	if !ok {
+		return nil, fmt.Errorf("%s 不是调度器配置: %v", path, gvk)
+	}
+	return cfg, nil
+}
+
+// Objects 从 yaml 文件读取的对象
+type Objects struct {
+	Nodes []*v1.Node
+	Pods  []*v1.Pod
+	// PodGroup 和 ElasticQuota,模拟时通过 fake 动态客户端提供给插件
+	CustomResources []*unstructured.Unstructured
+}
+
+// LoadObjects 读取 yaml 文件中的 Node、Pod、PodGroup 和 ElasticQuota,一个文件可以有多个 "---" 分隔的对象,Node 和 Pod 也可以是 List
+// 没有 namespace 的 pod 和 crd 放到 default,没有 uid 的 pod 用 namespace/name 作为 uid
+func LoadObjects(paths ...string) (*Objects, error) {
+	objects := &Objects{}
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		err = decodeObjects(f, objects)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("解析 %s 失败: %v", path, err)
+		}
+	}
+	for _, pod := range objects.Pods {
+		if pod.Namespace == "" {
+			pod.Namespace = v1.NamespaceDefault
+		}
+		if pod.UID == "" {
+			pod.UID = types.UID(pod.Namespace + "/" + pod.Name)
+		}
+	}
+	for _, cr := range objects.CustomResources {
+		if cr.GetNamespace() == "" {
+			cr.SetNamespace(v1.NamespaceDefault)
+		}
+	}
+	return objects, nil
+}
+
+func decodeObjects(r io.Reader, objects *Objects) error {
+	reader := utilyaml.NewYAMLReader(bufio.NewReader(r))
+	for {
+		doc, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if len(bytes.TrimSpace(doc)) == 0 {
+			continue
+		}
+		obj, gvk, err := clientgoscheme.Codecs.UniversalDeserializer().Decode(doc, nil, nil)
+		if runtime.IsNotRegisteredError(err) {
+			cr, err := decodeCustomResource(doc)
+			if err != nil {
+				return err
+			}
+			objects.CustomResources = append(objects.CustomResources, cr)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		switch o := obj.(type) {
+		case *v1.Node:
+			objects.Nodes = append(objects.Nodes, o)
+		case *v1.Pod:
+			objects.Pods = append(objects.Pods, o)
+		case *v1.NodeList:
+			for i := range o.Items {
+				objects.Nodes = append(objects.Nodes, &o.Items[i])
+			}
+		case *v1.PodList:
+			for i := range o.Items {
+				objects.Pods = append(objects.Pods, &o.Items[i])
+			}
+		default:
+			return fmt.Errorf("不支持的对象 %v", gvk)
+		}
+	}
+}
+
+// clientgo 的 scheme 中没有 crd,按 unstructured 解析
+func decodeCustomResource(doc []byte) (*unstructured.Unstructured, error) {
+	data, err := utilyaml.ToJSON(doc)
+	if err != nil {
+		return nil, err
+	}
+	cr := &unstructured.Unstructured{}
+	if err := cr.UnmarshalJSON(data); err != nil {
+		return nil, err
+	}
+	gvk := cr.GroupVersionKind()
+	if gvk.GroupVersion() != v1alpha1.SchemeGroupVersion || (gvk.Kind != "PodGroup" && gvk.Kind != "ElasticQuota") {
+		return nil, fmt.Errorf("不支持的对象 %v", gvk)
+	}
+	return cr, nil
+}
@@ -0,0 +1,398 @@
+package simulator
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/informers"
+	clientsetfake "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/kubernetes/pkg/scheduler/algorithmprovider"
+	"k8s.io/kubernetes/pkg/scheduler/apis/config"
+	frameworkplugins "k8s.io/kubernetes/pkg/scheduler/framework/plugins"
+	frameworkruntime "k8s.io/kubernetes/pkg/scheduler/framework/runtime"
+	framework "k8s.io/kubernetes/pkg/scheduler/framework/v1alpha1"
+
+	"schedulePlugin/coscheduling"
+	"schedulePlugin/elasticquota"
+	"schedulePlugin/fake"
+	plugins "schedulePlugin/plugin"
+)
+
+// 离线模拟调度,不需要集群
+// 用 fake clientset 和调度快照构造 framework,按照 queue sort 的顺序逐个调度 pending 的 pod
+// 和真正的调度器不同,分数相同时选择名字最小的节点,当前时间也是固定的,结果是确定的,可以在 CI 中比较
+// PodGroup 和 ElasticQuota 从输入的 yaml 中读取,通过 fake 动态客户端提供给插件
+
+// Result 一个 pod 的模拟结果
+type Result struct {
+	// namespace/name
+	Pod string
+	// 调度到的节点,调度失败为空
+	NodeName string
+	// 调度失败的原因
+	Message string
+	// node -> plugin -> Filter 拒绝的原因
+	FilterReasons map[string]map[string][]string
+	// node -> plugin -> 加权之后的分数
+	Scores map[string]map[string]int64
+}
+
+// Simulator 模拟调度器
+type Simulator struct {
+	framework framework.Framework
+	snapshot  *fake.SharedLister
+	clientSet *clientsetfake.Clientset
+	nodes     []*v1.Node
+	pending   []*v1.Pod
+	// Permit 返回 Wait 的 pod,已经 assume 到节点上,等待 gang 的其他成员
+	waiting []*waitingPod
+	stopCh  chan struct{}
+}
+
+// waitingPod 在 Permit 阶段等待的 pod,放行后继续 bind
+type waitingPod struct {
+	pod      *v1.Pod
+	state    *framework.CycleState
+	nodeName string
+	result   *Result
+}
+
+// New 用调度器配置中名为 profileName 的 profile 构造模拟器,profileName 为空使用第一个 profile
+// 已经设置了 spec.nodeName 的 pod 作为节点上已有的 pod,其他的 pod 等待调度
+// outOfTree 是 main 中注册的插件,其中需要集群的插件替换成使用 fake 客户端和固定时间的版本
+func New(cfg *config.KubeSchedulerConfiguration, profileName string, outOfTree frameworkruntime.Registry, objects *Objects) (*Simulator, error) {
+	profile, err := findProfile(cfg, profileName)
+	if err != nil {
+		return nil, err
+	}
+	enabled, err := profilePlugins(cfg, profile)
+	if err != nil {
+		return nil, err
+	}
+
+	nodes := sortedNodes(objects.Nodes)
+	var objs, crs []runtime.Object
+	var assigned, pending []*v1.Pod
+	for _, node := range nodes {
+		objs = append(objs, node)
+	}
+	for _, pod := range objects.Pods {
+		objs = append(objs, pod)
+		switch {
+		case pod.Spec.NodeName != "":
+			assigned = append(assigned, pod)
+		case pod.Spec.SchedulerName == "" || pod.Spec.SchedulerName == profile.SchedulerName:
+			pending = append(pending, pod)
+		}
+	}
+	for _, cr := range objects.CustomResources {
+		crs = append(crs, cr)
+	}
+	clientSet := clientsetfake.NewSimpleClientset(objs...)
+	informerFactory := informers.NewSharedInformerFactory(clientSet, 0)
+	snapshot := fake.NewSharedLister(nodes, assigned)
+
+	registry := frameworkplugins.NewInTreeRegistry()
+	if err := registry.Merge(outOfTree); err != nil {
+		return nil, err
+	}
+	stopCh := make(chan struct{})
+	now := snapshotTime(nodes)
+	dynamicClient := dynamicfake.NewSimpleDynamicClient(runtime.NewScheme(), crs...)
+	offline := frameworkruntime.Registry{
+		plugins.Name:      plugins.NewWithClock(func() time.Time { return now }),
+		coscheduling.Name: coscheduling.NewWithClient(dynamicClient, stopCh),
+		elasticquota.Name: elasticquota.NewWithClient(dynamicClient, stopCh),
+	}
+	for name, factory := range offline {
+		if _, ok := outOfTree[name]; ok {
+			registry[name] = factory
+		}
+	}
+
+	fwk, err := frameworkruntime.NewFramework(registry, enabled, profile.PluginConfig,
+		frameworkruntime.WithClientSet(clientSet),
+		frameworkruntime.WithInformerFactory(informerFactory),
+		frameworkruntime.WithSnapshotSharedLister(snapshot),
+		frameworkruntime.WithProfileName(profile.SchedulerName),
+		// 收集所有 Filter 插件拒绝的原因
+		frameworkruntime.WithRunAllFilters(true))
+	if err != nil {
+		close(stopCh)
+		return nil, fmt.Errorf("初始化 profile %s 失败: %v", profile.SchedulerName, err)
+	}
+
+	informerFactory.Start(stopCh)
+	informerFactory.WaitForCacheSync(stopCh)
+	return &Simulator{
+		framework: fwk,
+		snapshot:  snapshot,
+		clientSet: clientSet,
+		nodes:     nodes,
+		pending:   pending,
+		stopCh:    stopCh,
+	}, nil
+}
+
+// 模拟时的当前时间,使用节点 metrics annotation 中最新的时间,没有 metrics 时为零值
+// 结果只取决于输入的对象,和运行的时间无关
+func snapshotTime(nodes []*v1.Node) time.Time {
+	var now time.Time
+	for _, node := range nodes {
+		ts, err := time.Parse(time.RFC3339, node.Annotations[plugins.MetricsTimestampAnnotation])
+		if err == nil && ts.After(now) {
+			now = ts
+		}
+	}
+	return now
+}
+
+// Close 停止 informer
+func (s *Simulator) Close() {
+	close(s.stopCh)
+}
+
+func findProfile(cfg *config.KubeSchedulerConfiguration, name string) (*config.KubeSchedulerProfile, error) {
+	if len(cfg.Profiles) == 0 {
+		return nil, fmt.Errorf("调度器配置中没有 profile")
+	}
+	if name == "" {
+		return &cfg.Profiles[0], nil
+	}
+	for i := range cfg.Profiles {
+		if cfg.Profiles[i].SchedulerName == name {
+			return &cfg.Profiles[i], nil
+		}
+	}
+	return nil, fmt.Errorf("调度器配置中没有名为 %s 的 profile", name)
+}
+
+// 和调度器一样,在 algorithm provider 默认的插件上应用 profile 中的配置
+func profilePlugins(cfg *config.KubeSchedulerConfiguration, profile *config.KubeSchedulerProfile) (*config.Plugins, error) {
+	provider := config.SchedulerDefaultProviderName
+	if cfg.AlgorithmSource.Provider != nil {
+		provider = *cfg.AlgorithmSource.Provider
+	}
+	defaults, ok := algorithmprovider.NewRegistry()[provider]
+	if !ok {
+		return nil, fmt.Errorf("algorithm provider %q 不存在", provider)
+	}
+	plugins := &config.Plugins{}
+	plugins.Append(defaults)
+	plugins.Apply(profile.Plugins)
+	return plugins, nil
+}
+
+func sortedNodes(nodes []*v1.Node) []*v1.Node {
+	sorted := append([]*v1.Node(nil), nodes...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Name < sorted[j].Name })
+	return sorted
+}
+
+func podKey(pod *v1.Pod) string {
+	return pod.Namespace + "/" + pod.Name
+}
+
+// 按 queue sort 插件排序,相同时按 namespace/name 排序
+// 入队时间使用 pod 的创建时间,不依赖当前时间
+func (s *Simulator) sortPending() []*v1.Pod {
+	less := s.framework.QueueSortFunc()
+	queued := func(pod *v1.Pod) *framework.QueuedPodInfo {
+		return &framework.QueuedPodInfo{Pod: pod, Timestamp: pod.CreationTimestamp.Time, InitialAttemptTimestamp: pod.CreationTimestamp.Time}
+	}
+	pods := append([]*v1.Pod(nil), s.pending...)
+	sort.SliceStable(pods, func(i, j int) bool {
+		a, b := queued(pods[i]), queued(pods[j])
+		if less(a, b) {
+			return true
+		}
+		if less(b, a) {
+			return false
+		}
+		return podKey(pods[i]) < podKey(pods[j])
+	})
+	return pods
+}
+
+// Run 调度所有 pending 的 pod,返回每个 pod 的结果
+// 调度成功的 pod 会加到快照中,影响后面的 pod
+// Permit 返回 Wait 的 pod 和调度器一样先 assume,被放行后再 bind,所有 pod 调度完还在等待的当作超时拒绝
+func (s *Simulator) Run(ctx context.Context) ([]*Result, error) {
+	var results []*Result
+	for _, pod := range s.sortPending() {
+		result, err := s.schedulePod(ctx, pod)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, result)
+		if err := s.bindAllowedPods(ctx); err != nil {
+			return nil, err
+		}
+	}
+	if err := s.rejectWaitingPods(ctx); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// bind 所有 Permit 插件都已经放行的等待中的 pod
+func (s *Simulator) bindAllowedPods(ctx context.Context) error {
+	var waiting []*waitingPod
+	for _, w := range s.waiting {
+		wp := s.framework.GetWaitingPod(w.pod.UID)
+		if wp == nil || len(wp.GetPendingPlugins()) != 0 {
+			waiting = append(waiting, w)
+			continue
+		}
+		// 已经放行,WaitOnPermit 不会阻塞
+		if status := s.framework.WaitOnPermit(ctx, w.pod); !status.IsSuccess() {
+			if err := s.forget(ctx, w, status); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := s.bind(ctx, w.state, w.pod, w.nodeName, w.result); err != nil {
+			return err
+		}
+	}
+	s.waiting = waiting
+	return nil
+}
+
+// 模拟结束时还在等待的 pod 已经不会被放行,拒绝之后 unreserve
+// 等待期间被插件拒绝的 pod 保留插件给出的原因
+func (s *Simulator) rejectWaitingPods(ctx context.Context) error {
+	for _, w := range s.waiting {
+		if wp := s.framework.GetWaitingPod(w.pod.UID); wp != nil {
+			wp.Reject("模拟结束时仍在 Permit 阶段等待")
+		}
+		if err := s.forget(ctx, w, s.framework.WaitOnPermit(ctx, w.pod)); err != nil {
+			return err
+		}
+	}
+	s.waiting = nil
+	return nil
+}
+
+// 从快照中删除 assume 的 pod 并且 unreserve
+func (s *Simulator) forget(ctx context.Context, w *waitingPod, status *framework.Status) error {
+	if err := s.snapshot.RemovePod(w.pod); err != nil {
+		return err
+	}
+	s.framework.RunReservePluginsUnreserve(ctx, w.state, w.pod, w.nodeName)
+	w.result.Message = fmt.Sprintf("Permit: %s", status.Message())
+	return nil
+}
+
+func (s *Simulator) schedulePod(ctx context.Context, pod *v1.Pod) (*Result, error) {
+	result := &Result{
+		Pod:           podKey(pod),
+		FilterReasons: map[string]map[string][]string{},
+		Scores:        map[string]map[string]int64{},
+	}
+	fwk := s.framework
+	state := framework.NewCycleState()
+	if status := fwk.RunPreFilterPlugins(ctx, state, pod); !status.IsSuccess() {
+		result.Message = fmt.Sprintf("PreFilter: %s", status.Message())
+		return result, nil
+	}
+
+	var feasible []*v1.Node
+	for _, node := range s.nodes {
+		nodeInfo, err := s.snapshot.Get(node.Name)
+		if err != nil {
+			return nil, err
+		}
+		statuses := fwk.RunFilterPlugins(ctx, state, pod, nodeInfo)
+		if statuses.Merge().IsSuccess() {
+			feasible = append(feasible, node)
+			continue
+		}
+		reasons := map[string][]string{}
+		for plugin, status := range statuses {
+			if !status.IsSuccess() {
+				reasons[plugin] = status.Reasons()
+			}
+		}
+		result.FilterReasons[node.Name] = reasons
+	}
+	if len(feasible) == 0 {
+		result.Message = fmt.Sprintf("0/%d nodes are available", len(s.nodes))
+		return result, nil
+	}
+
+	if status := fwk.RunPreScorePlugins(ctx, state, pod, feasible); !status.IsSuccess() {
+		return nil, fmt.Errorf("pod %s PreScore: %v", result.Pod, status.AsError())
+	}
+	pluginScores, status := fwk.RunScorePlugins(ctx, state, pod, feasible)
+	if !status.IsSuccess() {
+		return nil, fmt.Errorf("pod %s Score: %v", result.Pod, status.AsError())
+	}
+	for _, node := range feasible {
+		result.Scores[node.Name] = map[string]int64{}
+	}
+	for plugin, scores := range pluginScores {
+		for _, score := range scores {
+			result.Scores[score.Name][plugin] = score.Score
+		}
+	}
+	// feasible 按名字排序,分数相同时选择第一个
+	selected := feasible[0].Name
+	for _, node := range feasible[1:] {
+		if totalScore(result.Scores[node.Name]) > totalScore(result.Scores[selected]) {
+			selected = node.Name
+		}
+	}
+
+	if status := fwk.RunReservePluginsReserve(ctx, state, pod, selected); !status.IsSuccess() {
+		fwk.RunReservePluginsUnreserve(ctx, state, pod, selected)
+		result.Message = fmt.Sprintf("Reserve: %s", status.Message())
+		return result, nil
+	}
+	status = fwk.RunPermitPlugins(ctx, state, pod, selected)
+	if status.Code() == framework.Wait {
+		// assume 到节点上,gang 中后面的成员可以看到
+		assumed := pod.DeepCopy()
+		assumed.Spec.NodeName = selected
+		s.snapshot.AddPod(assumed)
+		s.waiting = append(s.waiting, &waitingPod{pod: assumed, state: state, nodeName: selected, result: result})
+		return result, nil
+	}
+	if !status.IsSuccess() {
+		fwk.RunReservePluginsUnreserve(ctx, state, pod, selected)
+		result.Message = fmt.Sprintf("Permit: %s", status.Message())
+		return result, nil
+	}
+
+	bound := pod.DeepCopy()
+	bound.Spec.NodeName = selected
+	s.snapshot.AddPod(bound)
+	if err := s.bind(ctx, state, bound, selected, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// 模拟 bind: 更新 fake clientset,pod 已经在快照中
+func (s *Simulator) bind(ctx context.Context, state *framework.CycleState, pod *v1.Pod, nodeName string, result *Result) error {
+	if _, err := s.clientSet.CoreV1().Pods(pod.Namespace).Update(ctx, pod, metav1.UpdateOptions{}); err != nil {
+		return err
+	}
+	s.framework.RunPostBindPlugins(ctx, state, pod, nodeName)
+	result.NodeName = nodeName
+	return nil
+}
+
+func totalScore(scores map[string]int64) int64 {
+	var total int64
+	for _, score := range scores {
+		total += score
+	}
+	return total
+}
@@ -0,0 +1,148 @@
+package simulator
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	frameworkruntime "k8s.io/kubernetes/pkg/scheduler/framework/runtime"
+
+	"schedulePlugin/coscheduling"
+	"schedulePlugin/elasticquota"
+	plugins "schedulePlugin/plugin"
+	"schedulePlugin/topologyspread"
+)
+
+var testRegistry = frameworkruntime.Registry{
+	plugins.Name:        plugins.New,
+	topologyspread.Name: topologyspread.New,
+}
+
+func simulate(t *testing.T) []*Result {
+	t.Helper()
+	cfg, err := LoadConfig("testdata/config.yaml")
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	objects, err := LoadObjects("testdata/nodes.yaml", "testdata/pods.yaml")
+	if err != nil {
+		t.Fatalf("LoadObjects: %v", err)
+	}
+	sim, err := New(cfg, "", testRegistry, objects)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer sim.Close()
+	results, err := sim.Run(context.Background())
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	return results
+}
+
+func TestSimulate(t *testing.T) {
+	results := simulate(t)
+	want := map[string]string{
+		"default/big": "",
+		// rack a 已经有 web-0,打散到 rack b
+		"default/web-1": "node-b1",
+		// 两个 rack 各有一个,分数相同时选择名字最小的节点
+		"default/web-2": "node-a1",
+	}
+	if len(results) != len(want) {
+		t.Fatalf("expected %d results, got %d", len(want), len(results))
+	}
+	for _, result := range results {
+		if result.NodeName != want[result.Pod] {
+			t.Errorf("pod %s: expected node %q, got %q (%s)", result.Pod, want[result.Pod], result.NodeName, result.Message)
+		}
+	}
+	if reasons := results[0].FilterReasons["node-b1"][plugins.Name]; len(reasons) != 1 || reasons[0] != "Insufficient cpu" {
+		t.Errorf("expected sample-plugin to reject big on node-b1, got %v", results[0].FilterReasons)
+	}
+}
+
+// 输出是确定的,和 testdata/expected.txt 一致
+func TestSimulateDeterministic(t *testing.T) {
+	expected, err := ioutil.ReadFile("testdata/expected.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		var out bytes.Buffer
+		if err := PrintResults(&out, simulate(t)); err != nil {
+			t.Fatal(err)
+		}
+		if out.String() != string(expected) {
+			t.Fatalf("run %d: unexpected output:\n%s\nexpected:\n%s", i, out.String(), expected)
+		}
+	}
+}
+
+func TestUnknownProfile(t *testing.T) {
+	cfg, err := LoadConfig("testdata/config.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := New(cfg, "missing", testRegistry, &Objects{}); err == nil {
+		t.Errorf("expected error for an unknown profile")
+	}
+}
+
+// 需要集群的 Coscheduling 和 ElasticQuota 使用 fake 动态客户端,sample-plugin 使用固定的当前时间
+func TestSimulateCustomResources(t *testing.T) {
+	cfg, err := LoadConfig("testdata/crd-config.yaml")
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	objects, err := LoadObjects("testdata/crd-objects.yaml")
+	if err != nil {
+		t.Fatalf("LoadObjects: %v", err)
+	}
+	if len(objects.CustomResources) != 3 {
+		t.Fatalf("expected PodGroups and ElasticQuota, got %d custom resources", len(objects.CustomResources))
+	}
+	registry := frameworkruntime.Registry{
+		plugins.Name:      plugins.New,
+		coscheduling.Name: coscheduling.New,
+		elasticquota.Name: elasticquota.New,
+	}
+	sim, err := New(cfg, "", registry, objects)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer sim.Close()
+	results, err := sim.Run(context.Background())
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+
+	want := map[string]string{
+		"default/worker-1": "node-2",
+		"default/worker-2": "node-2",
+		"default/stuck-1":  "",
+		"default/stuck-2":  "",
+		"team/team-1":      "node-2",
+		"team/team-2":      "node-2",
+		"team/team-3":      "",
+	}
+	if len(results) != len(want) {
+		t.Fatalf("expected %d results, got %d", len(want), len(results))
+	}
+	for _, result := range results {
+		if result.NodeName != want[result.Pod] {
+			t.Errorf("pod %s: expected node %q, got %q (%s)", result.Pod, want[result.Pod], result.NodeName, result.Message)
+		}
+	}
+	// gang 没有凑够 minMember,等待的成员在模拟结束时被拒绝
+	for _, result := range results {
+		if result.Pod == "default/stuck-1" && !strings.Contains(result.Message, "Permit") {
+			t.Errorf("expected stuck-1 to be rejected at Permit, got %q", result.Message)
+		}
+	}
+	if last := results[len(results)-1]; !strings.Contains(last.Message, "ElasticQuota") {
+		t.Errorf("expected team-3 to be rejected by ElasticQuota, got %q", last.Message)
+	}
+}
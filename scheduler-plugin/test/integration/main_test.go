@@ -0,0 +1,252 @@
+package integration
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/kubernetes/cmd/kube-scheduler/app"
+
+	plugins "schedulePlugin/plugin"
+)
+
+// 集成测试,和 envtest 一样启动本地的 etcd 和 kube-apiserver,再在进程内启动带 sample-plugin 的调度器
+// etcd 和 kube-apiserver 从 $KUBEBUILDER_ASSETS 目录或者 PATH 中查找,版本需要和 k8s.io/kubernetes 一致(v1.19)
+// 找不到时跳过所有测试,go test ./... 不受影响
+//
+//	KUBEBUILDER_ASSETS=/usr/local/kubebuilder/bin go test ./test/integration/ -v
+
+const (
+	// 测试使用的调度器名字,pod 的 spec.schedulerName
+	schedulerName = "sample-scheduler"
+	// 节点和 pod 通过这个 label 隔离,每个测试只会调度到自己创建的节点上
+	testLabel = "integration.sample-scheduler.io/test"
+)
+
+var (
+	// 找不到二进制时跳过的原因
+	skipReason string
+	client     kubernetes.Interface
+)
+
+func TestMain(m *testing.M) {
+	os.Exit(run(m))
+}
+
+func run(m *testing.M) int {
+	etcdPath, apiserverPath, err := findBinaries()
+	if err != nil {
+		skipReason = err.Error()
+		return m.Run()
+	}
+	dir, err := ioutil.TempDir("", "sample-scheduler-integration")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer os.RemoveAll(dir)
+
+	env, err := startControlPlane(dir, etcdPath, apiserverPath)
+	defer env.stop()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "启动 etcd 和 kube-apiserver 失败: %v\n", err)
+		return 1
+	}
+	if err := startScheduler(dir, env.kubeconfig); err != nil {
+		fmt.Fprintf(os.Stderr, "启动调度器失败: %v\n", err)
+		return 1
+	}
+	return m.Run()
+}
+
+func findBinaries() (string, string, error) {
+	find := func(name string) (string, error) {
+		if dir := os.Getenv("KUBEBUILDER_ASSETS"); dir != "" {
+			path := filepath.Join(dir, name)
+			if _, err := os.Stat(path); err == nil {
+				return path, nil
+			}
+		}
+		path, err := exec.LookPath(name)
+		if err != nil {
+			return "", fmt.Errorf("%s not found in $KUBEBUILDER_ASSETS or PATH, skipping integration tests", name)
+		}
+		return path, nil
+	}
+	etcdPath, err := find("etcd")
+	if err != nil {
+		return "", "", err
+	}
+	apiserverPath, err := find("kube-apiserver")
+	if err != nil {
+		return "", "", err
+	}
+	return etcdPath, apiserverPath, nil
+}
+
+// controlPlane 本地启动的 etcd 和 kube-apiserver
+type controlPlane struct {
+	processes  []*exec.Cmd
+	kubeconfig string
+}
+
+func (c *controlPlane) stop() {
+	// 先停 apiserver 再停 etcd
+	for i := len(c.processes) - 1; i >= 0; i-- {
+		p := c.processes[i]
+		p.Process.Kill()
+		p.Wait()
+	}
+}
+
+func (c *controlPlane) start(name string, args ...string) error {
+	cmd := exec.Command(name, args...)
+	if testing.Verbose() {
+		cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	c.processes = append(c.processes, cmd)
+	return nil
+}
+
+func startControlPlane(dir, etcdPath, apiserverPath string) (*controlPlane, error) {
+	c := &controlPlane{}
+	ports, err := freePorts(4)
+	if err != nil {
+		return c, err
+	}
+	etcdURL := "http://127.0.0.1:" + strconv.Itoa(ports[0])
+	err = c.start(etcdPath,
+		"--data-dir="+filepath.Join(dir, "etcd"),
+		"--listen-client-urls="+etcdURL,
+		"--advertise-client-urls="+etcdURL,
+		"--listen-peer-urls=http://127.0.0.1:"+strconv.Itoa(ports[1]))
+	if err != nil {
+		return c, err
+	}
+	if err := waitForHealthy(etcdURL + "/health"); err != nil {
+		return c, fmt.Errorf("etcd: %v", err)
+	}
+
+	// 和 envtest 一样使用不需要认证的 insecure port
+	apiserverURL := "http://127.0.0.1:" + strconv.Itoa(ports[2])
+	err = c.start(apiserverPath,
+		"--etcd-servers="+etcdURL,
+		"--cert-dir="+filepath.Join(dir, "certs"),
+		"--insecure-port="+strconv.Itoa(ports[2]),
+		"--insecure-bind-address=127.0.0.1",
+		"--secure-port="+strconv.Itoa(ports[3]),
+		"--advertise-address=127.0.0.1",
+		"--service-cluster-ip-range=10.0.0.0/24",
+		"--disable-admission-plugins=ServiceAccount",
+		"--allow-privileged=true")
+	if err != nil {
+		return c, err
+	}
+	if err := waitForHealthy(apiserverURL + "/healthz"); err != nil {
+		return c, fmt.Errorf("kube-apiserver: %v", err)
+	}
+
+	c.kubeconfig = filepath.Join(dir, "kubeconfig")
+	if err := writeKubeconfig(c.kubeconfig, apiserverURL); err != nil {
+		return c, err
+	}
+	config, err := clientcmd.BuildConfigFromFlags("", c.kubeconfig)
+	if err != nil {
+		return c, err
+	}
+	client, err = kubernetes.NewForConfig(config)
+	return c, err
+}
+
+func freePorts(n int) ([]int, error) {
+	var ports []int
+	for i := 0; i < n; i++ {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			return nil, err
+		}
+		defer l.Close()
+		ports = append(ports, l.Addr().(*net.TCPAddr).Port)
+	}
+	return ports, nil
+}
+
+func waitForHealthy(url string) error {
+	return wait.PollImmediate(100*time.Millisecond, time.Minute, func() (bool, error) {
+		resp, err := http.Get(url)
+		if err != nil {
+			return false, nil
+		}
+		resp.Body.Close()
+		return resp.StatusCode == http.StatusOK, nil
+	})
+}
+
+func writeKubeconfig(path, server string) error {
+	kubeconfig := fmt.Sprintf(`apiVersion: v1
+kind: Config
+clusters:
+- name: integration
+  cluster:
+    server: %s
+contexts:
+- name: integration
+  context:
+    cluster: integration
+current-context: integration
+`, server)
+	return ioutil.WriteFile(path, []byte(kubeconfig), 0600)
+}
+
+// 调度器配置,sample-plugin 的 Filter 按 limits 过滤,Score 按节点 annotation 中的利用率打分
+const schedulerConfig = `apiVersion: kubescheduler.config.k8s.io/v1beta1
+kind: KubeSchedulerConfiguration
+clientConnection:
+  kubeconfig: %s
+leaderElection:
+  leaderElect: false
+profiles:
+  - schedulerName: %s
+    plugins:
+      preFilter:
+        enabled:
+          - name: "sample-plugin"
+      filter:
+        enabled:
+          - name: "sample-plugin"
+      score:
+        enabled:
+          - name: "sample-plugin"
+`
+
+// 和 main 一样通过 app.NewSchedulerCommand 注册插件,调度器一直运行到测试进程退出
+// 命令中的 SetupSignalHandler 只能调用一次,所有测试共用一个调度器
+func startScheduler(dir, kubeconfig string) error {
+	configFile := filepath.Join(dir, "scheduler-config.yaml")
+	if err := ioutil.WriteFile(configFile, []byte(fmt.Sprintf(schedulerConfig, kubeconfig, schedulerName)), 0600); err != nil {
+		return err
+	}
+	command := app.NewSchedulerCommand(app.WithPlugin(plugins.Name, plugins.New))
+	command.SetArgs([]string{
+		"--config=" + configFile,
+		// 不需要 healthz 和 metrics 端口
+		"--port=0",
+		"--secure-port=0",
+	})
+	// 启动失败时命令会直接退出进程,测试中等待 pod 被调度的超时包含了调度器启动的时间
+	go command.Execute()
+	return nil
+}
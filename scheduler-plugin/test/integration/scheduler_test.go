@@ -0,0 +1,210 @@
+package integration
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+
+	plugins "schedulePlugin/plugin"
+)
+
+// 等待调度结果的超时,包含调度器第一次启动的时间
+const scheduleTimeout = time.Minute
+
+// testContext 每个测试一个 namespace,节点带上 namespace 名字的 label,pod 只会调度到本测试的节点上
+type testContext struct {
+	t         *testing.T
+	namespace string
+}
+
+func newTestContext(t *testing.T) *testContext {
+	if skipReason != "" {
+		t.Skip(skipReason)
+	}
+	ns, err := client.CoreV1().Namespaces().Create(context.TODO(), &v1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{GenerateName: "integration-"},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		t.Fatalf("创建 namespace 失败: %v", err)
+	}
+	tc := &testContext{t: t, namespace: ns.Name}
+	t.Cleanup(tc.cleanup)
+	return tc
+}
+
+func (tc *testContext) cleanup() {
+	ctx := context.TODO()
+	selector := metav1.ListOptions{LabelSelector: testLabel + "=" + tc.namespace}
+	if err := client.CoreV1().Nodes().DeleteCollection(ctx, metav1.DeleteOptions{}, selector); err != nil {
+		tc.t.Errorf("删除节点失败: %v", err)
+	}
+	if err := client.CoreV1().Namespaces().Delete(ctx, tc.namespace, metav1.DeleteOptions{}); err != nil {
+		tc.t.Errorf("删除 namespace 失败: %v", err)
+	}
+}
+
+// 创建节点,节点名字带上 namespace 避免不同测试冲突
+func (tc *testContext) createNode(name, cpu, memory string, annotations map[string]string) string {
+	node := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        tc.namespace + "-" + name,
+			Labels:      map[string]string{testLabel: tc.namespace},
+			Annotations: annotations,
+		},
+		Status: v1.NodeStatus{
+			Capacity: v1.ResourceList{
+				v1.ResourceCPU:    resource.MustParse(cpu),
+				v1.ResourceMemory: resource.MustParse(memory),
+				v1.ResourcePods:   resource.MustParse("110"),
+			},
+			Allocatable: v1.ResourceList{
+				v1.ResourceCPU:    resource.MustParse(cpu),
+				v1.ResourceMemory: resource.MustParse(memory),
+				v1.ResourcePods:   resource.MustParse("110"),
+			},
+			Conditions: []v1.NodeCondition{{Type: v1.NodeReady, Status: v1.ConditionTrue}},
+		},
+	}
+	node, err := client.CoreV1().Nodes().Create(context.TODO(), node, metav1.CreateOptions{})
+	if err != nil {
+		tc.t.Fatalf("创建节点 %s 失败: %v", name, err)
+	}
+	return node.Name
+}
+
+// 创建 pod,requests 很小,默认的 NodeResourcesFit 不会过滤,是否能放下由 sample-plugin 按 limits 决定
+func (tc *testContext) createPod(name, cpuLimit string) *v1.Pod {
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: tc.namespace},
+		Spec: v1.PodSpec{
+			SchedulerName: schedulerName,
+			NodeSelector:  map[string]string{testLabel: tc.namespace},
+			Containers: []v1.Container{{
+				Name:  "pause",
+				Image: "k8s.gcr.io/pause:3.2",
+				Resources: v1.ResourceRequirements{
+					Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse("100m")},
+					Limits:   v1.ResourceList{v1.ResourceCPU: resource.MustParse(cpuLimit)},
+				},
+			}},
+		},
+	}
+	pod, err := client.CoreV1().Pods(tc.namespace).Create(context.TODO(), pod, metav1.CreateOptions{})
+	if err != nil {
+		tc.t.Fatalf("创建 pod %s 失败: %v", name, err)
+	}
+	return pod
+}
+
+// 等待 pod 被 bind,返回节点名字
+func (tc *testContext) waitForBinding(pod *v1.Pod) string {
+	var nodeName string
+	err := wait.PollImmediate(100*time.Millisecond, scheduleTimeout, func() (bool, error) {
+		p, err := client.CoreV1().Pods(pod.Namespace).Get(context.TODO(), pod.Name, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		nodeName = p.Spec.NodeName
+		return nodeName != "", nil
+	})
+	if err != nil {
+		tc.t.Fatalf("等待 pod %s 调度失败: %v", pod.Name, err)
+	}
+	return nodeName
+}
+
+// 等待调度器把 pod 标记为 Unschedulable
+func (tc *testContext) waitForUnschedulable(pod *v1.Pod) {
+	err := wait.PollImmediate(100*time.Millisecond, scheduleTimeout, func() (bool, error) {
+		p, err := client.CoreV1().Pods(pod.Namespace).Get(context.TODO(), pod.Name, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		if p.Spec.NodeName != "" {
+			tc.t.Fatalf("expected pod %s to be unschedulable, but it was bound to %s", pod.Name, p.Spec.NodeName)
+		}
+		for _, c := range p.Status.Conditions {
+			if c.Type == v1.PodScheduled && c.Status == v1.ConditionFalse && c.Reason == v1.PodReasonUnschedulable {
+				return true, nil
+			}
+		}
+		return false, nil
+	})
+	if err != nil {
+		tc.t.Fatalf("等待 pod %s 变成 Unschedulable 失败: %v", pod.Name, err)
+	}
+}
+
+func metricsAnnotations(cpu, memory float64, timestamp time.Time) map[string]string {
+	return map[string]string{
+		plugins.CPUUtilizationAnnotation:    strconv.FormatFloat(cpu, 'f', -1, 64),
+		plugins.MemoryUtilizationAnnotation: strconv.FormatFloat(memory, 'f', -1, 64),
+		plugins.MetricsTimestampAnnotation:  timestamp.Format(time.RFC3339),
+	}
+}
+
+func TestFilterByLimits(t *testing.T) {
+	tc := newTestContext(t)
+	tc.createNode("small", "1", "4Gi", nil)
+	large := tc.createNode("large", "4", "4Gi", nil)
+
+	// limits 放不下小节点
+	pod := tc.createPod("fits-large", "2")
+	if got := tc.waitForBinding(pod); got != large {
+		t.Errorf("expected pod to be bound to %s, got %s", large, got)
+	}
+}
+
+func TestFilterUnschedulable(t *testing.T) {
+	tc := newTestContext(t)
+	tc.createNode("small", "1", "4Gi", nil)
+	tc.createNode("large", "4", "4Gi", nil)
+
+	// requests 可以放下,limits 哪个节点都放不下
+	pod := tc.createPod("too-large", "8")
+	tc.waitForUnschedulable(pod)
+}
+
+func TestFilterCountsBoundPods(t *testing.T) {
+	tc := newTestContext(t)
+	node := tc.createNode("node", "2", "4Gi", nil)
+
+	first := tc.createPod("first", "2")
+	if got := tc.waitForBinding(first); got != node {
+		t.Fatalf("expected first pod to be bound to %s, got %s", node, got)
+	}
+	// 已经 bind 的 pod 占用了 100m,剩余的 1900m 放不下第二个 pod 的 limits
+	second := tc.createPod("second", "2")
+	tc.waitForUnschedulable(second)
+}
+
+func TestScorePrefersIdleNode(t *testing.T) {
+	tc := newTestContext(t)
+	now := time.Now()
+	tc.createNode("busy", "4", "4Gi", metricsAnnotations(90, 90, now))
+	idle := tc.createNode("idle", "4", "4Gi", metricsAnnotations(10, 10, now))
+
+	pod := tc.createPod("prefers-idle", "1")
+	if got := tc.waitForBinding(pod); got != idle {
+		t.Errorf("expected pod to be bound to %s, got %s", idle, got)
+	}
+}
+
+func TestScoreFreshMetricsBeatStale(t *testing.T) {
+	tc := newTestContext(t)
+	now := time.Now()
+	// 过期的数据按中间分数处理,不会因为很久之前空闲而被选中
+	tc.createNode("stale", "4", "4Gi", metricsAnnotations(0, 0, now.Add(-time.Hour)))
+	fresh := tc.createNode("fresh", "4", "4Gi", metricsAnnotations(5, 5, now))
+
+	pod := tc.createPod("prefers-fresh", "1")
+	if got := tc.waitForBinding(pod); got != fresh {
+		t.Errorf("expected pod to be bound to %s, got %s", fresh, got)
+	}
+}
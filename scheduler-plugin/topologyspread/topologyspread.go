@@ -0,0 +1,190 @@
+package topologyspread
+
+import (
+	"context"
+	"fmt"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/klog/v2"
+	framework "k8s.io/kubernetes/pkg/scheduler/framework/v1alpha1"
+
+	pluginconfig "schedulePlugin/apis/config"
+	configscheme "schedulePlugin/apis/config/scheme"
+	"schedulePlugin/apis/config/validation"
+	"schedulePlugin/apis/scheduling/v1alpha1"
+)
+
+const (
+	// Name 定义插件名称
+	Name             = "LabelTopologySpread"
+	preScoreStateKey = "PreScore" + Name
+)
+
+// 按节点的自定义 label (例如 rack) 打散 pod,不依赖 PodTopologySpread,老集群也能用
+// preScore: 从调度快照中统计每个拓扑域里匹配的 pod 数量
+// score: 拓扑域里的 pod 越少分数越高,没有拓扑 label 的节点打最低分
+var _ framework.PreScorePlugin = &LabelTopologySpread{}
+var _ framework.ScorePlugin = &LabelTopologySpread{}
+
+// LabelTopologySpread 插件
+type LabelTopologySpread struct {
+	args     *pluginconfig.LabelTopologySpreadArgs
+	handle   framework.FrameworkHandle
+	selector labels.Selector
+}
+
+func (ts *LabelTopologySpread) Name() string {
+	return Name
+}
+
+// 初始化插件
+func New(object runtime.Object, f framework.FrameworkHandle) (framework.Plugin, error) {
+	args := &pluginconfig.LabelTopologySpreadArgs{}
+	if err := configscheme.DecodeInto(object, args); err != nil {
+		return nil, err
+	}
+	if err := validation.ValidateLabelTopologySpreadArgs(args); err != nil {
+		return nil, fmt.Errorf("invalid %s args: %v", Name, err)
+	}
+	ts := &LabelTopologySpread{args: args, handle: f}
+	if args.LabelSelector != nil {
+		selector, err := metav1.LabelSelectorAsSelector(args.LabelSelector)
+		if err != nil {
+			return nil, err
+		}
+		ts.selector = selector
+	}
+	if klog.V(2).Enabled() {
+		klog.InfoS("Successfully get plugin config args", "plugin", Name, "args", args)
+	}
+	return ts, nil
+}
+
+// preScoreState PreScore 统计的结果,Score 只读不写
+type preScoreState struct {
+	// 为 true 时 pod 不需要打散,所有节点打相同的分数
+	skip bool
+	// 拓扑域 -> 匹配的 pod 数量
+	counts map[string]int64
+	// 可调度节点所在拓扑域中最多的 pod 数量
+	maxCount int64
+}
+
+// 统计结果不会被修改,不需要拷贝
+func (s *preScoreState) Clone() framework.StateData {
+	return s
+}
+
+// 统计哪些 pod: 配置了 labelSelector 时使用 labelSelector,否则使用 pod 所属的 PodGroup
+// 不匹配 labelSelector 或者不属于任何 PodGroup 的 pod 返回 nil,不需要打散
+func (ts *LabelTopologySpread) podSelector(pod *v1.Pod) labels.Selector {
+	if ts.selector != nil {
+		if !ts.selector.Matches(labels.Set(pod.Labels)) {
+			return nil
+		}
+		return ts.selector
+	}
+	group, ok := pod.Labels[v1alpha1.PodGroupLabel]
+	if !ok {
+		return nil
+	}
+	return labels.SelectorFromSet(labels.Set{v1alpha1.PodGroupLabel: group})
+}
+
+func (ts *LabelTopologySpread) PreScore(ctx context.Context, state *framework.CycleState, pod *v1.Pod, nodes []*v1.Node) *framework.Status {
+	s := &preScoreState{counts: map[string]int64{}}
+	selector := ts.podSelector(pod)
+	if selector == nil {
+		s.skip = true
+		state.Write(preScoreStateKey, s)
+		return nil
+	}
+
+	nodeInfos, err := ts.handle.SnapshotSharedLister().NodeInfos().List()
+	if err != nil {
+		return framework.NewStatus(framework.Error, fmt.Sprintf("listing nodes from Snapshot: %v", err))
+	}
+	for _, nodeInfo := range nodeInfos {
+		node := nodeInfo.Node()
+		if node == nil {
+			continue
+		}
+		domain, ok := node.Labels[ts.args.TopologyKey]
+		if !ok {
+			continue
+		}
+		s.counts[domain] += countMatchingPods(nodeInfo, pod.Namespace, selector)
+	}
+	for _, node := range nodes {
+		if domain, ok := node.Labels[ts.args.TopologyKey]; ok && s.counts[domain] > s.maxCount {
+			s.maxCount = s.counts[domain]
+		}
+	}
+	if klog.V(4).Enabled() {
+		klog.InfoS("Counted pods per topology domain", "pod", klog.KObj(pod), "topologyKey", ts.args.TopologyKey, "counts", s.counts)
+	}
+	state.Write(preScoreStateKey, s)
+	return nil
+}
+
+// 同一个 namespace 下匹配 selector 并且没有在删除的 pod
+func countMatchingPods(nodeInfo *framework.NodeInfo, namespace string, selector labels.Selector) int64 {
+	var count int64
+	for _, podInfo := range nodeInfo.Pods {
+		p := podInfo.Pod
+		if p.Namespace != namespace || p.DeletionTimestamp != nil {
+			continue
+		}
+		if selector.Matches(labels.Set(p.Labels)) {
+			count++
+		}
+	}
+	return count
+}
+
+func getPreScoreState(state *framework.CycleState) (*preScoreState, error) {
+	data, err := state.Read(preScoreStateKey)
+	if err != nil {
+		return nil, err
+	}
+	s, ok := data.(*preScoreState)
+	if !ok {
+		return nil, fmt.Errorf("%+v convert to LabelTopologySpread preScoreState error", data)
+	}
+	return s, nil
+}
+
+// 分数 = MaxNodeScore * (maxCount - count) / maxCount,拓扑域里没有匹配的 pod 得到最高分
+func (ts *LabelTopologySpread) Score(ctx context.Context, state *framework.CycleState, pod *v1.Pod, nodeName string) (int64, *framework.Status) {
+	s, err := getPreScoreState(state)
+	if err != nil {
+		return 0, framework.NewStatus(framework.Error, err.Error())
+	}
+	if s.skip {
+		return framework.MinNodeScore, nil
+	}
+	nodeInfo, err := ts.handle.SnapshotSharedLister().NodeInfos().Get(nodeName)
+	if err != nil {
+		return 0, framework.NewStatus(framework.Error, fmt.Sprintf("getting node %q from Snapshot: %v", nodeName, err))
+	}
+	node := nodeInfo.Node()
+	if node == nil {
+		return 0, framework.NewStatus(framework.Error, fmt.Sprintf("node %q not found", nodeName))
+	}
+	domain, ok := node.Labels[ts.args.TopologyKey]
+	if !ok {
+		return framework.MinNodeScore, nil
+	}
+	if s.maxCount == 0 {
+		return framework.MaxNodeScore, nil
+	}
+	return framework.MaxNodeScore * (s.maxCount - s.counts[domain]) / s.maxCount, nil
+}
+
+// 分数已经在 [MinNodeScore, MaxNodeScore] 范围内
+func (ts *LabelTopologySpread) ScoreExtensions() framework.ScoreExtensions {
+	return nil
+}
@@ -0,0 +1,132 @@
+package topologyspread
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	frameworkruntime "k8s.io/kubernetes/pkg/scheduler/framework/runtime"
+	framework "k8s.io/kubernetes/pkg/scheduler/framework/v1alpha1"
+
+	"schedulePlugin/apis/scheduling/v1alpha1"
+	"schedulePlugin/fake"
+)
+
+func makeNode(name, rack string) *v1.Node {
+	node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: name}}
+	if rack != "" {
+		node.Labels = map[string]string{"rack": rack}
+	}
+	return node
+}
+
+func makePod(name, nodeName string, labels map[string]string) *v1.Pod {
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", Labels: labels},
+		Spec:       v1.PodSpec{NodeName: nodeName},
+	}
+}
+
+func newPlugin(t *testing.T, args string, nodes []*v1.Node, pods []*v1.Pod) *LabelTopologySpread {
+	t.Helper()
+	fh, err := fake.NewFramework(nil, nil, frameworkruntime.WithSnapshotSharedLister(fake.NewSharedLister(nodes, pods)))
+	if err != nil {
+		t.Fatalf("NewFramework: %v", err)
+	}
+	p, err := New(&runtime.Unknown{Raw: []byte(args), ContentType: runtime.ContentTypeJSON}, fh)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	return p.(*LabelTopologySpread)
+}
+
+func scoreNodes(t *testing.T, ts *LabelTopologySpread, pod *v1.Pod, nodes []*v1.Node) map[string]int64 {
+	t.Helper()
+	state := framework.NewCycleState()
+	if st := ts.PreScore(context.Background(), state, pod, nodes); !st.IsSuccess() {
+		t.Fatalf("PreScore: %v", st)
+	}
+	scores := map[string]int64{}
+	for _, node := range nodes {
+		score, st := ts.Score(context.Background(), state, pod, node.Name)
+		if !st.IsSuccess() {
+			t.Fatalf("Score %s: %v", node.Name, st)
+		}
+		scores[node.Name] = score
+	}
+	return scores
+}
+
+func TestScoreBySelector(t *testing.T) {
+	app := map[string]string{"app": "web"}
+	nodes := []*v1.Node{makeNode("a1", "a"), makeNode("a2", "a"), makeNode("b1", "b"), makeNode("c1", "c"), makeNode("none", "")}
+	pods := []*v1.Pod{
+		makePod("w1", "a1", app),
+		makePod("w2", "a2", app),
+		makePod("w3", "b1", app),
+		makePod("other", "c1", map[string]string{"app": "db"}),
+		makePod("w4", "none", app),
+	}
+	ts := newPlugin(t, `{"labelSelector": {"matchLabels": {"app": "web"}}}`, nodes, pods)
+
+	got := scoreNodes(t, ts, makePod("new", "", app), nodes)
+	want := map[string]int64{
+		// rack a 有 2 个,rack b 有 1 个,rack c 没有
+		"a1":   framework.MinNodeScore,
+		"a2":   framework.MinNodeScore,
+		"b1":   framework.MaxNodeScore / 2,
+		"c1":   framework.MaxNodeScore,
+		"none": framework.MinNodeScore,
+	}
+	for name, score := range want {
+		if got[name] != score {
+			t.Errorf("node %s: expected score %d, got %d", name, score, got[name])
+		}
+	}
+
+	// 不匹配 labelSelector 的 pod 不打散,不会被 app=web 的 pod 推开
+	got = scoreNodes(t, ts, makePod("db", "", map[string]string{"app": "db"}), nodes)
+	for _, node := range nodes {
+		if got[node.Name] != got["a1"] {
+			t.Errorf("expected equal scores for a pod not matching the selector, got %v", got)
+			break
+		}
+	}
+}
+
+func TestScoreByPodGroup(t *testing.T) {
+	group := map[string]string{v1alpha1.PodGroupLabel: "pg"}
+	nodes := []*v1.Node{makeNode("a1", "a"), makeNode("b1", "b")}
+	pods := []*v1.Pod{makePod("m1", "a1", group), makePod("m2", "a1", group), makePod("x", "b1", map[string]string{v1alpha1.PodGroupLabel: "other"})}
+	ts := newPlugin(t, `{}`, nodes, pods)
+
+	got := scoreNodes(t, ts, makePod("m3", "", group), nodes)
+	if got["a1"] != framework.MinNodeScore || got["b1"] != framework.MaxNodeScore {
+		t.Errorf("expected PodGroup members to be spread to rack b, got %v", got)
+	}
+
+	// 不属于 PodGroup 也没有配置 labelSelector 时不打散
+	got = scoreNodes(t, ts, makePod("alone", "", nil), nodes)
+	if got["a1"] != got["b1"] {
+		t.Errorf("expected equal scores for a pod without group, got %v", got)
+	}
+}
+
+func TestNewValidatesArgs(t *testing.T) {
+	fh, err := fake.NewFramework(nil, nil)
+	if err != nil {
+		t.Fatalf("NewFramework: %v", err)
+	}
+	for args, wantErr := range map[string]string{
+		`{"topologyKey": "not a key!"}`:                                               "topologyKey: Invalid value",
+		`{"labelSelector": {"matchExpressions": [{"key": "app", "operator": "In"}]}}`: "labelSelector.matchExpressions[0].values",
+	} {
+		_, err := New(&runtime.Unknown{Raw: []byte(args), ContentType: runtime.ContentTypeJSON}, fh)
+		if err == nil || !strings.Contains(err.Error(), wantErr) {
+			t.Errorf("args %s: expected error containing %q, got %v", args, wantErr, err)
+		}
+	}
+}